@@ -1,9 +1,15 @@
 // CLI entry point
 package main
 
-import "cdn-edge-server/internal/ui"
+import (
+	"cdn-edge-server/internal/ui"
+	"os"
+)
 
 func main() {
-	// Start CLI (client)
+	// Run a command non-interactively (see "cli help"), or start the interactive CLI (client)
+	if len(os.Args) > 1 {
+		os.Exit(ui.RunCommand(os.Args[1:]))
+	}
 	ui.Run()
 }
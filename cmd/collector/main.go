@@ -0,0 +1,128 @@
+// Trace collector stub: receives OTLP JSON span exports from the edge and origin and prints
+// one line per span, to inspect traces locally without an observability backend.
+package main
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/logging"
+	"cdn-edge-server/internal/tracing"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// out serializes printed batches, so lines of concurrent exports don't interleave
+var out sync.Mutex
+
+func main() {
+	if err := logging.Setup(config.LogLevel); err != nil {
+		slog.Error("Logging error", "err", err)
+		os.Exit(1)
+	}
+	if config.TraceCollector == "" {
+		slog.Error("TRACE_COLLECTOR is not set (host:port to listen on)")
+		os.Exit(1)
+	}
+
+	ln, err := net.Listen("tcp", config.TraceCollector)
+	if err != nil {
+		slog.Error("Listen error", "err", err)
+		os.Exit(1)
+	}
+	slog.Info("Trace collector listening", "addr", config.TraceCollector, "path", tracing.CollectorPath)
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			slog.Error("Accept error", "err", err)
+			continue
+		}
+		go handle(conn)
+	}
+}
+
+func handle(conn net.Conn) {
+	defer conn.Close()
+
+	req, err := http.ParseReq(bufio.NewReader(conn))
+	if err != nil || req == nil {
+		return
+	}
+	if req.Path != tracing.CollectorPath {
+		conn.Write([]byte(http.BuildErrorResponse(404).HeadString()))
+		return
+	}
+	if req.Method != "POST" {
+		conn.Write([]byte(http.BuildErrorResponse(405).HeadString()))
+		return
+	}
+
+	var export tracing.ExportRequest
+	if err := json.Unmarshal(req.Body, &export); err != nil {
+		slog.Warn("Invalid export", "err", err)
+		conn.Write([]byte(http.BuildErrorResponse(400).HeadString()))
+		return
+	}
+	printSpans(export)
+
+	resp := http.BuildResponse(200, "application/json", []byte("{}"))
+	conn.Write([]byte(resp.HeadString()))
+	conn.Write(resp.Body)
+}
+
+// printSpans prints the exported spans in start order:
+// <start> <service> <trace id> <span id> <parent id|-> <name> <duration> [key=value...]
+func printSpans(export tracing.ExportRequest) {
+	type line struct {
+		start int64
+		text  string
+	}
+	var lines []line
+
+	for _, rs := range export.ResourceSpans {
+		service := "-"
+		for _, kv := range rs.Resource.Attributes {
+			if kv.Key == "service.name" {
+				service = kv.Value.String()
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, s := range ss.Spans {
+				start, _ := strconv.ParseInt(s.StartTimeUnixNano, 10, 64)
+				end, _ := strconv.ParseInt(s.EndTimeUnixNano, 10, 64)
+				parent := s.ParentSpanID
+				if parent == "" {
+					parent = "-"
+				}
+
+				var b strings.Builder
+				fmt.Fprintf(&b, "%s %-10s %s %s %-16s %-18s %9s",
+					time.Unix(0, start).Format("15:04:05.000000"), service, s.TraceID, s.SpanID, parent,
+					s.Name, time.Duration(end-start).Round(time.Microsecond))
+				for _, kv := range s.Attributes {
+					fmt.Fprintf(&b, " %s=%s", kv.Key, kv.Value.String())
+				}
+				if s.Status.Code == 2 {
+					fmt.Fprintf(&b, " error=%q", s.Status.Message)
+				}
+				lines = append(lines, line{start, b.String()})
+			}
+		}
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].start < lines[j].start })
+
+	out.Lock()
+	defer out.Unlock()
+	for _, l := range lines {
+		fmt.Println(l.text)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/tracing"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+const export = `{"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"edge"}}]},` +
+	`"scopeSpans":[{"scope":{"name":"cdn-edge-server"},"spans":[` +
+	`{"traceId":"4bf92f3577b34da6a3ce929d0e0e4736","spanId":"1111111111111111","parentSpanId":"00f067aa0ba902b7","name":"GET","kind":2,` +
+	`"startTimeUnixNano":"1700000000000000000","endTimeUnixNano":"1700000000002500000",` +
+	`"attributes":[{"key":"url.path","value":{"stringValue":"/a.txt"}},{"key":"http.response.status_code","value":{"intValue":"502"}}],` +
+	`"status":{"code":2,"message":"status 502"}},` +
+	`{"traceId":"4bf92f3577b34da6a3ce929d0e0e4736","spanId":"2222222222222222","parentSpanId":"1111111111111111","name":"parse","kind":1,` +
+	`"startTimeUnixNano":"1699999999999000000","endTimeUnixNano":"1700000000000000000","status":{}}]}]}]}`
+
+// post sends a request to the collector over an in-memory connection and returns the
+// response status and what the collector printed.
+func post(t *testing.T, method, path, body string) (int, string) {
+	t.Helper()
+
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go handle(server)
+	go fmt.Fprintf(client, "%s %s HTTP/1.0\r\nContent-Length: %d\r\n\r\n%s", method, path, len(body), body)
+
+	resp, err := http.ParseResp(bufio.NewReader(client))
+	if resp == nil {
+		t.Fatalf("%s %s: no response: %v", method, path, err)
+	}
+	w.Close()
+	printed, _ := io.ReadAll(r)
+	return resp.Status, string(printed)
+}
+
+func TestHandle(t *testing.T) {
+	status, printed := post(t, "POST", tracing.CollectorPath, export)
+	if status != 200 {
+		t.Fatalf("export: got %d, want 200", status)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(printed, "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %d lines, want 2:\n%s", len(lines), printed)
+	}
+	// Spans are printed in start order, the child first here
+	want := [][]string{
+		{"edge", "4bf92f3577b34da6a3ce929d0e0e4736", "2222222222222222", "1111111111111111", "parse", "1ms"},
+		{"edge", "4bf92f3577b34da6a3ce929d0e0e4736", "1111111111111111", "00f067aa0ba902b7", "GET", "2.5ms",
+			"url.path=/a.txt", "http.response.status_code=502", `error="status`, `502"`},
+	}
+	for i, line := range lines {
+		if fields := strings.Fields(line)[1:]; strings.Join(fields, " ") != strings.Join(want[i], " ") {
+			t.Errorf("line %d: got %q, want fields %q", i+1, line, want[i])
+		}
+	}
+
+	for _, tt := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", "/v1/metrics", export, 404},
+		{"GET", tracing.CollectorPath, "", 405},
+		{"POST", tracing.CollectorPath, "{not json", 400},
+	} {
+		if status, printed := post(t, tt.method, tt.path, tt.body); status != tt.status || printed != "" {
+			t.Errorf("%s %s: got %d printing %q, want %d printing nothing", tt.method, tt.path, status, printed, tt.status)
+		}
+	}
+}
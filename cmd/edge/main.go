@@ -2,16 +2,222 @@
 package main
 
 import (
-	"cdn-edge-server/internal/cache"
+	"cdn-edge-server/internal/acl"
 	"cdn-edge-server/internal/config"
 	"cdn-edge-server/internal/edge"
+	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/logging"
+	"cdn-edge-server/internal/rewrite"
+	"cdn-edge-server/internal/tlsconf"
+	"cdn-edge-server/internal/tracing"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
-	// Initialize edge server's cache (load existing files if any)
-	cache.Init()
+	if err := logging.Setup(config.LogLevel); err != nil {
+		slog.Error("Logging error", "err", err)
+		os.Exit(1)
+	}
+
+	// Write the access log to stdout, or to a file rotated by size
+	format, err := logging.ParseFormat(config.AccessLogFormat)
+	if err != nil {
+		slog.Error("Access log error", "err", err)
+		os.Exit(1)
+	}
+	var accessLog io.Writer = os.Stdout
+	if config.AccessLogFile != "" {
+		file, err := logging.OpenRotatingFile(config.AccessLogFile, config.AccessLogMaxSize, config.AccessLogMaxBackups)
+		if err != nil {
+			slog.Error("Access log error", "err", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		accessLog = file
+	}
+	edge.AccessLog = logging.NewAccessLog(accessLog, format)
+
+	// Record the traffic for replay if enabled
+	if config.CaptureFile != "" {
+		file, err := logging.OpenRotatingFile(config.CaptureFile, config.CaptureMaxSize, config.CaptureMaxBackups)
+		if err != nil {
+			slog.Error("Traffic capture error", "err", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		edge.Capture = logging.NewCapture(file, config.CaptureBodies)
+	}
+
+	// Set up the virtual hosts and initialize their caches (load existing files if any)
+	if err := edge.LoadVirtualHosts(); err != nil {
+		slog.Error("Virtual host error", "err", err)
+		os.Exit(1)
+	}
+
+	// Talk to the origin over (mutual) TLS if enabled
+	if config.OriginTLS {
+		tlsConfig, err := tlsconf.MutualClientConfig(config.EdgeTLSClientCert, config.EdgeTLSClientKey,
+			config.OriginTLSCA, config.OriginTLSServerName, config.EdgeAllowedOrigins)
+		if err != nil {
+			slog.Error("Origin TLS error", "err", err)
+			os.Exit(1)
+		}
+		edge.OriginTLS = tlsConfig
+	}
+
+	// Restrict access by client IP if rules are configured
+	if config.ACLFile != "" {
+		rules, err := acl.Load(config.ACLFile, config.ACLDefaultAllow)
+		if err != nil {
+			slog.Error("ACL error", "err", err)
+			os.Exit(1)
+		}
+		edge.AccessRules = rules
+		go rules.Watch(config.ACLReloadInterval)
+	}
+
+	// Rewrite and redirect paths if rules are configured
+	if config.RewriteFile != "" {
+		rules, err := rewrite.Load(config.RewriteFile)
+		if err != nil {
+			slog.Error("Rewrite rules error", "err", err)
+			os.Exit(1)
+		}
+		edge.RewriteRules = rules
+	}
+
+	// Purge files that change on the origin (including writes made through other edges)
+	if config.PurgeEvents {
+		edge.SubscribePurges()
+	}
+
+	// Record request spans if an exporter is configured
+	tracer, err := tracing.Open("cdn-edge", config.TraceFile, config.TraceCollector, config.TraceSampleRatio)
+	if err != nil {
+		slog.Error("Tracing error", "err", err)
+		os.Exit(1)
+	}
+	defer tracer.Close()
 
 	// Start TCP server and serve clients
-	srv := edge.NewTCPServer(config.EdgeHost, config.EdgePort, edge.HandleClient)
-	srv.ListenAndServe()
+	srv := edge.NewTCPServer(config.EdgeHost, config.EdgePort, edge.NewHandler())
+	srv.Tracer = tracer
+	srv.MaxConns = config.MaxConns
+	srv.HeaderReadTimeout = config.HeaderReadTimeout
+	srv.BodyReadTimeout = config.BodyReadTimeout
+	srv.WriteTimeout = config.WriteTimeout
+	srv.Limits = http.Limits{
+		MaxHeaderBytes: config.MaxHeaderBytes,
+		MaxHeaderCount: config.MaxHeaderCount,
+		MaxBodyBytes:   config.MaxBodyBytes,
+	}
+
+	// Serve HTTPS if certificates are configured
+	if config.TLSCertDir != "" {
+		store, err := tlsconf.LoadCertStore(config.TLSCertDir)
+		if err != nil {
+			slog.Error("TLS error", "err", err)
+			os.Exit(1)
+		}
+		srv.TLSConfig, err = tlsconf.ServerConfig(store, config.TLSMinVersion, config.TLSCipherPolicy)
+		if err != nil {
+			slog.Error("TLS error", "err", err)
+			os.Exit(1)
+		}
+		go store.Watch(config.TLSReloadInterval)
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	// Warm the caches from the manifest and last run's hot keys, without delaying startup
+	if err := edge.WarmOnStartup(); err != nil {
+		slog.Error("Cache warm-up error", "err", err)
+	}
+
+	// Serve metrics on the admin port if enabled. After an upgrade, the previous process
+	// releases the port when it starts draining.
+	var admin *edge.TCPServer
+	if config.AdminPort != "" {
+		edge.RegisterServerMetrics(srv)
+		admin = edge.NewTCPServer(config.AdminHost, config.AdminPort, edge.NewAdminHandler())
+		admin.HeaderReadTimeout = config.HeaderReadTimeout
+		admin.WriteTimeout = config.WriteTimeout
+		go func() {
+			err := admin.ListenAndServeExclusive(config.ShutdownTimeout + 10*time.Second)
+			if err != nil && !errors.Is(err, edge.ErrServerClosed) {
+				slog.Error("Admin server error", "err", err)
+			}
+		}()
+	}
+
+	// Run until interrupted, upgraded, or until the server fails
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	if edge.UpgradeSignal != nil {
+		signal.Notify(sig, edge.UpgradeSignal)
+	}
+
+	upgraded := false
+wait:
+	for {
+		select {
+		case err := <-serveErr:
+			slog.Error("Edge server error", "err", err)
+			os.Exit(1)
+		case s := <-sig:
+			if s != edge.UpgradeSignal {
+				slog.Info("Shutting down, draining in-flight requests", "signal", s, "timeout", config.ShutdownTimeout)
+				break wait
+			}
+
+			// Hand the listening socket to a new edge process, then drain like a normal shutdown.
+			// Flush first so the new process restores the current cache order.
+			slog.Info("Received upgrade signal, starting new edge process")
+			edge.FlushCaches()
+			if err := edge.SaveHotKeys(); err != nil {
+				slog.Error("Failed to save hot keys", "err", err)
+			}
+			if _, err := srv.Upgrade(); err != nil {
+				slog.Error("Upgrade failed, still serving", "err", err)
+				continue
+			}
+			upgraded = true
+			slog.Info("Draining in-flight requests", "timeout", config.ShutdownTimeout)
+			break wait
+		}
+	}
+
+	// Drain in-flight requests, then persist the cache index for the next start
+	// (unless a new edge process has taken over the cache)
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if admin != nil {
+		admin.Shutdown(ctx)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Shutdown deadline exceeded, closed remaining connections", "err", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, edge.ErrServerClosed) {
+		slog.Error("Edge server error", "err", err)
+	}
+	if !upgraded {
+		if err := edge.FlushCaches(); err != nil {
+			slog.Error("Failed to flush cache index", "err", err)
+		}
+		if err := edge.SaveHotKeys(); err != nil {
+			slog.Error("Failed to save hot keys", "err", err)
+		}
+	}
+
+	slog.Info("Edge server stopped")
 }
@@ -1,8 +1,73 @@
 package main
 
-import "cdn-edge-server/internal/origin"
+import (
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/logging"
+	"cdn-edge-server/internal/origin"
+	"cdn-edge-server/internal/tlsconf"
+	"cdn-edge-server/internal/tracing"
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
 
 func main() {
+	if err := logging.Setup(config.LogLevel); err != nil {
+		slog.Error("Logging error", "err", err)
+		os.Exit(1)
+	}
+
+	// Record request spans if an exporter is configured
+	tracer, err := tracing.Open("cdn-origin", config.TraceFile, config.TraceCollector, config.TraceSampleRatio)
+	if err != nil {
+		slog.Error("Tracing error", "err", err)
+		os.Exit(1)
+	}
+	defer tracer.Close()
+
 	// Start origin server
-	origin.StartOrigin()
+	srv := origin.NewServer(config.OriginHost, config.OriginPort)
+	srv.Tracer = tracer
+
+	// Serve edges over (mutual) TLS if enabled
+	if config.OriginTLS {
+		tlsConfig, err := tlsconf.MutualServerConfig(config.OriginTLSCert, config.OriginTLSKey,
+			config.OriginTLSCA, config.OriginRequireClientCert, config.OriginAllowedEdges)
+		if err != nil {
+			slog.Error("TLS error", "err", err)
+			os.Exit(1)
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	// Run until interrupted, or until the server fails
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serveErr:
+		slog.Error("Origin server error", "err", err)
+		os.Exit(1)
+	case s := <-sig:
+		slog.Info("Shutting down, draining in-flight requests", "signal", s, "timeout", config.ShutdownTimeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Shutdown deadline exceeded, closed remaining connections", "err", err)
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, origin.ErrServerClosed) {
+		slog.Error("Origin server error", "err", err)
+	}
+
+	slog.Info("Origin server stopped")
 }
@@ -0,0 +1,218 @@
+// Package acl decides whether a client IP may access a file, from an ordered list of
+// allow/deny rules over CIDR ranges.
+package acl
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Rule allows or denies the clients in a set of CIDR ranges, optionally only for the files
+// whose content path (see http.ContentPath) starts with a prefix and for some methods.
+type Rule struct {
+	Allow      bool
+	Prefixes   []netip.Prefix
+	PathPrefix string   // "" matches every file, otherwise "/" and the start of a file name
+	Methods    []string // nil matches every method
+	Line       int      // line in the rules file, for logging
+}
+
+func (r *Rule) String() string {
+	action := "deny"
+	if r.Allow {
+		action = "allow"
+	}
+	return fmt.Sprintf("%s rule on line %d", action, r.Line)
+}
+
+// Rules is an ordered rule list: the first rule matching a request decides. Requests that
+// match no rule get the default decision.
+type Rules struct {
+	rules        []Rule
+	v4, v6       trie // CIDR ranges → indexes into rules
+	defaultAllow bool
+}
+
+// Decision is the outcome of evaluating Rules for a request.
+type Decision struct {
+	Allow bool
+	Rule  *Rule // nil if no rule matched (default decision)
+}
+
+// Parse reads rules, one per line ('#' starts a comment):
+//
+//	<allow|deny> <cidr>[,<cidr>...] [<path-prefix>|*] [<method>[,<method>...]|*]
+//
+// Path prefixes are matched against content paths, "/<file name>", as files are stored by name;
+// prefixes with directories can't be enforced and are rejected. For example:
+//
+//	deny   203.0.113.0/24,2001:db8::/32
+//	allow  10.0.0.0/8,192.168.0.0/16  /internal-  GET,HEAD
+//	deny   0.0.0.0/0,::/0             /internal-
+func Parse(r io.Reader, defaultAllow bool) (*Rules, error) {
+	rs := &Rules{defaultAllow: defaultAllow}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("line %d: expected <allow|deny> <cidrs> [<path-prefix>] [<methods>]", n)
+		}
+
+		rule := Rule{Line: n}
+		switch fields[0] {
+		case "allow":
+			rule.Allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("line %d: unknown action %q", n, fields[0])
+		}
+
+		for _, c := range strings.Split(fields[1], ",") {
+			p, err := ParsePrefix(c)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			rule.Prefixes = append(rule.Prefixes, p)
+		}
+		if len(fields) > 2 && fields[2] != "*" {
+			if err := http.CheckContentPrefix(fields[2]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			rule.PathPrefix = fields[2]
+		}
+		if len(fields) > 3 && fields[3] != "*" {
+			rule.Methods = strings.Split(strings.ToUpper(fields[3]), ",")
+		}
+
+		idx := len(rs.rules)
+		rs.rules = append(rs.rules, rule)
+		for _, p := range rule.Prefixes {
+			if p.Addr().Is4() {
+				rs.v4.insert(p, idx)
+			} else {
+				rs.v6.insert(p, idx)
+			}
+		}
+	}
+	return rs, scanner.Err()
+}
+
+// Decide evaluates the rules for a request from the given client for the file at the given
+// content path (see http.ContentPath).
+func (rs *Rules) Decide(ip netip.Addr, method, path string) Decision {
+	ip = ip.Unmap()
+	t := &rs.v6
+	if ip.Is4() {
+		t = &rs.v4
+	}
+
+	// The first rule (lowest index) among those covering the IP that also matches the request
+	first := -1
+	t.match(ip, func(rules []int) {
+		for _, i := range rules {
+			if (first == -1 || i < first) && rs.rules[i].matches(method, path) {
+				first = i
+			}
+		}
+	})
+
+	if first == -1 {
+		return Decision{Allow: rs.defaultAllow}
+	}
+	return Decision{Allow: rs.rules[first].Allow, Rule: &rs.rules[first]}
+}
+
+func (r *Rule) matches(method, path string) bool {
+	return strings.HasPrefix(path, r.PathPrefix) && (r.Methods == nil || slices.Contains(r.Methods, method))
+}
+
+// ParsePrefix parses an IP address or CIDR range (e.g. "10.0.0.1", "10.0.0.0/8", "::1").
+// IPv4-mapped IPv6 addresses are converted to IPv4, as client addresses are.
+func ParsePrefix(s string) (netip.Prefix, error) {
+	if p, err := netip.ParsePrefix(s); err == nil {
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address or CIDR range %q", s)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Store holds the rules loaded from a file and reloads them when the file changes.
+type Store struct {
+	file         string
+	defaultAllow bool
+	current      atomic.Pointer[Rules]
+	modTime      time.Time
+}
+
+// Load reads the rules in the given file.
+func Load(file string, defaultAllow bool) (*Store, error) {
+	s := &Store{file: file, defaultAllow: defaultAllow}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Decide evaluates the current rules for a request from the given client for the file at the
+// given content path.
+func (s *Store) Decide(ip netip.Addr, method, path string) Decision {
+	return s.current.Load().Decide(ip, method, path)
+}
+
+// Watch checks the rules file every interval and reloads it when it was modified. If the new
+// rules fail to parse, the previous ones stay in effect. Watch never returns.
+func (s *Store) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		info, err := os.Stat(s.file)
+		if err != nil || info.ModTime().Equal(s.modTime) {
+			continue
+		}
+		if err := s.reload(); err != nil {
+			slog.Error("ACL: keeping previous rules, reload failed", "err", err)
+			continue
+		}
+		slog.Info("ACL: reloaded rules", "file", s.file)
+	}
+}
+
+func (s *Store) reload() error {
+	f, err := os.Open(s.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	rules, err := Parse(f, s.defaultAllow)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.file, err)
+	}
+
+	s.current.Store(rules)
+	s.modTime = info.ModTime()
+	return nil
+}
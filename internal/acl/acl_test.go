@@ -0,0 +1,63 @@
+package acl
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+const testRules = `# comment
+deny   203.0.113.0/24,2001:db8::/32
+allow  10.0.0.0/8,192.168.0.0/16  /internal-  GET,HEAD
+deny   0.0.0.0/0,::/0             /internal-
+allow  198.51.100.7               *           post
+`
+
+func TestDecide(t *testing.T) {
+	rs, err := Parse(strings.NewReader(testRules), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip, method, path string
+		allow            bool
+		line             int // 0 = default decision
+	}{
+		{"203.0.113.9", "GET", "/index.html", false, 2},
+		{"2001:db8::1", "GET", "/internal-a.txt", false, 2},
+		{"10.1.2.3", "GET", "/internal-a.txt", true, 3},
+		{"10.1.2.3", "POST", "/internal-a.txt", false, 4},
+		{"::ffff:192.168.1.1", "HEAD", "/internal-a.txt", true, 3},
+		{"8.8.8.8", "GET", "/internal-a.txt", false, 4},
+		{"2001:4860::8888", "GET", "/internal-", false, 4},
+		{"8.8.8.8", "GET", "/index.html", false, 0},
+		{"198.51.100.7", "POST", "/index.html", true, 5},
+		{"198.51.100.7", "GET", "/index.html", false, 0},
+	}
+	for _, tt := range tests {
+		d := rs.Decide(netip.MustParseAddr(tt.ip), tt.method, tt.path)
+		line := 0
+		if d.Rule != nil {
+			line = d.Rule.Line
+		}
+		if d.Allow != tt.allow || line != tt.line {
+			t.Errorf("Decide(%s, %s, %s) = allow %v by line %d, want allow %v by line %d", tt.ip, tt.method, tt.path, d.Allow, line, tt.allow, tt.line)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, rules := range []string{
+		"allow",
+		"permit 10.0.0.0/8",
+		"allow 10.0.0.300",
+		"allow 10.0.0.0/8 /internal/", // files are stored by name
+		"allow 10.0.0.0/8 internal-",
+		"allow 10.0.0.0/8 / GET extra",
+	} {
+		if _, err := Parse(strings.NewReader("# first\n"+rules+"\n"), true); err == nil || !strings.HasPrefix(err.Error(), "line 2:") {
+			t.Errorf("Parse(%q) = %v, want an error on line 2", rules, err)
+		}
+	}
+}
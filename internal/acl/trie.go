@@ -0,0 +1,50 @@
+package acl
+
+import "net/netip"
+
+// trie is a binary prefix trie over IP address bits. Each node holds the indexes of the
+// rules that list the prefix ending at that node, so finding every rule whose CIDRs contain
+// an address takes one walk of at most 32 (IPv4) or 128 (IPv6) steps, however many rules
+// and ranges there are.
+type trie struct {
+	root trieNode
+}
+
+type trieNode struct {
+	child [2]*trieNode
+	rules []int
+}
+
+// insert records that the given rule applies to the given prefix.
+func (t *trie) insert(p netip.Prefix, rule int) {
+	addr := p.Addr().AsSlice()
+	node := &t.root
+	for i := 0; i < p.Bits(); i++ {
+		b := bit(addr, i)
+		if node.child[b] == nil {
+			node.child[b] = &trieNode{}
+		}
+		node = node.child[b]
+	}
+	node.rules = append(node.rules, rule)
+}
+
+// match calls visit with the rules of every prefix that contains the given address.
+func (t *trie) match(ip netip.Addr, visit func(rules []int)) {
+	addr := ip.AsSlice()
+	node := &t.root
+	for i := 0; node != nil; i++ {
+		if len(node.rules) > 0 {
+			visit(node.rules)
+		}
+		if i == len(addr)*8 {
+			break
+		}
+		node = node.child[bit(addr, i)]
+	}
+}
+
+// bit returns the i-th most significant bit of the given address.
+func bit(addr []byte, i int) int {
+	return int(addr[i/8]>>(7-i%8)) & 1
+}
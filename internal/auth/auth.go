@@ -0,0 +1,125 @@
+// Package auth authenticates and authorizes write requests (POST/PUT) at the edge.
+package auth
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials for this scheme")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Authenticator verifies one kind of credentials carried by a request.
+type Authenticator interface {
+	// Authenticate returns the user identified by the request's credentials. It returns
+	// ErrNoCredentials if the request carries none of this authenticator's kind, so the next
+	// one can be tried, and ErrInvalidCredentials (or a more specific error) if they don't check out.
+	Authenticate(req *http.Request) (string, error)
+
+	// Challenge returns the WWW-Authenticate value sent with 401 responses.
+	Challenge() string
+}
+
+// Policy decides whether a write request may be forwarded to the origin.
+type Policy struct {
+	Authenticators []Authenticator
+	Permissions    Permissions // nil allows every authenticated user to write anywhere
+}
+
+// Check authenticates the given request and checks that its user may write the file at its
+// path (see http.ContentPath). Credentials are checked against origPath, the path the client
+// sent (before rewrites), as signatures cover it.
+// It returns the authenticated user, or the status code to reject the request with:
+// 401 for missing or invalid credentials, 403 if the user may not write to the path.
+func (p *Policy) Check(req *http.Request, origPath string) (string, int) {
+	sent := *req
+	sent.Path = origPath
+	for _, a := range p.Authenticators {
+		user, err := a.Authenticate(&sent)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		if err != nil {
+			return "", 401
+		}
+		if !p.Permissions.Allows(user, http.ContentPath(req.Path)) {
+			return user, 403
+		}
+		return user, 0
+	}
+	return "", 401
+}
+
+// Challenges returns the WWW-Authenticate values of all the policy's authenticators,
+// comma-separated as a single header value.
+func (p *Policy) Challenges() string {
+	var challenges []string
+	for _, a := range p.Authenticators {
+		challenges = append(challenges, a.Challenge())
+	}
+	return strings.Join(challenges, ", ")
+}
+
+// Permissions maps content path prefixes ("/" and the start of a file name) to the users
+// allowed to write the files they match. The longest matching prefix applies; files that match
+// no prefix can't be written by anyone.
+type Permissions map[string][]string
+
+// Allows reports whether the given user may write to the given path. A nil Permissions
+// allows everything; "*" in a prefix's user list allows every authenticated user.
+func (p Permissions) Allows(user, path string) bool {
+	if p == nil {
+		return true
+	}
+
+	best, found := "", false
+	for prefix := range p {
+		if strings.HasPrefix(path, prefix) && (!found || len(prefix) > len(best)) {
+			best, found = prefix, true
+		}
+	}
+	if !found {
+		return false
+	}
+	return slices.Contains(p[best], "*") || slices.Contains(p[best], user)
+}
+
+// LoadPermissions reads write permissions from the given file, one content path prefix per
+// line followed by the comma-separated users allowed to write the files it matches ('#' starts
+// a comment). Prefixes with directories can't be enforced, as files are stored by name, and are
+// rejected:
+//
+//	/public-   *
+//	/docs-     alice,bob
+func LoadPermissions(file string) (Permissions, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	perms := make(Permissions)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected \"<path-prefix> <user>[,<user>...]\"", file, n)
+		}
+		if err := http.CheckContentPrefix(fields[0]); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", file, n, err)
+		}
+		perms[fields[0]] = append(perms[fields[0]], strings.Split(fields[1], ",")...)
+	}
+	return perms, scanner.Err()
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"cdn-edge-server/internal/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	tokens, err := ParseBearerTokens([]string{"alice:a-token", "bob:b-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &Policy{
+		Authenticators: []Authenticator{tokens},
+		Permissions: Permissions{
+			"/":        {"alice"},
+			"/public-": {"*"},
+		},
+	}
+
+	tests := []struct {
+		token, path string
+		status      int
+	}{
+		{"a-token", "/index.html", 0},
+		{"b-token", "/public-notes.txt", 0},
+		{"", "/public-notes.txt", 401},
+		{"wrong", "/public-notes.txt", 401},
+		{"b-token", "/index.html", 403},
+
+		// Files are stored by name: directories don't change which file is written
+		{"b-token", "/public/index.html", 403},
+		{"b-token", "/public-notes.txt/index.html", 403},
+		{"b-token", "/private/public-notes.txt", 0},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Method: "PUT", Path: tt.path, Headers: map[string]string{}}
+		if tt.token != "" {
+			req.Headers["Authorization"] = "Bearer " + tt.token
+		}
+		if _, status := policy.Check(req, tt.path); status != tt.status {
+			t.Errorf("PUT %s with token %q: got status %d, want %d", tt.path, tt.token, status, tt.status)
+		}
+	}
+}
+
+func TestLoadPermissions(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		file := filepath.Join(dir, "permissions")
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	perms, err := LoadPermissions(write("# comment\n/  admin\n/public-  *  # anyone\n/docs-  alice,bob\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !perms.Allows("bob", "/docs-guide.md") || perms.Allows("bob", "/guide.md") || !perms.Allows("eve", "/public-x") {
+		t.Errorf("unexpected permissions %v", perms)
+	}
+
+	for _, content := range []string{"/public/ *\n", "public- *\n", "/docs- alice bob\n"} {
+		if _, err := LoadPermissions(write(content)); err == nil || !strings.Contains(err.Error(), ":1:") {
+			t.Errorf("LoadPermissions(%q) = %v, want an error on line 1", content, err)
+		}
+	}
+}
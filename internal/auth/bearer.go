@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"cdn-edge-server/internal/http"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+)
+
+// BearerTokens authenticates "Authorization: Bearer <token>" against a static token table.
+type BearerTokens map[string]string // token → user
+
+// ParseBearerTokens builds a token table from "<user>:<token>" entries.
+func ParseBearerTokens(entries []string) (BearerTokens, error) {
+	tokens := make(BearerTokens)
+	for _, e := range entries {
+		user, token, ok := strings.Cut(e, ":")
+		if !ok || user == "" || token == "" {
+			return nil, fmt.Errorf("invalid bearer token entry %q (expected <user>:<token>)", e)
+		}
+		tokens[token] = user
+	}
+	return tokens, nil
+}
+
+func (t BearerTokens) Authenticate(req *http.Request) (string, error) {
+	token, ok := strings.CutPrefix(req.Header("Authorization"), "Bearer ")
+	if !ok {
+		return "", ErrNoCredentials
+	}
+
+	// Compare against every token in constant time, so timing doesn't reveal near-misses
+	user := ""
+	for known, u := range t {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			user = u
+		}
+	}
+	if user == "" {
+		return "", ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func (t BearerTokens) Challenge() string {
+	return `Bearer realm="cdn-edge"`
+}
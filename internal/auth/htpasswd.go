@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Htpasswd authenticates "Authorization: Basic ..." against users from an htpasswd file.
+// Supported password formats are those of `htpasswd -m` (APR1-MD5, the default) and `-s`
+// (SHA-1), and plain text marked as such ("{PLAIN}<password>"). Entries in any other format
+// (bcrypt, crypt, SHA-256/512 crypt, unmarked plain text) are rejected when the file is loaded,
+// so an unsupported hash is never mistaken for a plain text password.
+type Htpasswd map[string]string // user → stored password hash
+
+const (
+	htpasswdSHA   = "{SHA}"
+	htpasswdAPR1  = "$apr1$"
+	htpasswdPlain = "{PLAIN}"
+)
+
+// LoadHtpasswd reads "<user>:<hash>" lines from the given file.
+func LoadHtpasswd(file string) (Htpasswd, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(Htpasswd)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected <user>:<hash>", file, n)
+		}
+		switch {
+		case strings.HasPrefix(hash, "$2"):
+			return nil, fmt.Errorf("%s:%d: bcrypt hashes are not supported, use htpasswd -m or -s", file, n)
+		case !strings.HasPrefix(hash, htpasswdSHA) && !strings.HasPrefix(hash, htpasswdAPR1) && !strings.HasPrefix(hash, htpasswdPlain):
+			return nil, fmt.Errorf("%s:%d: unsupported password format, use htpasswd -m or -s (or %s<password>)", file, n, htpasswdPlain)
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+func (h Htpasswd) Authenticate(req *http.Request) (string, error) {
+	encoded, ok := strings.CutPrefix(req.Header("Authorization"), "Basic ")
+	if !ok {
+		return "", ErrNoCredentials
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidCredentials
+	}
+	user, password, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", ErrInvalidCredentials
+	}
+
+	hash, known := h[user]
+	if !known || !checkPassword(password, hash) {
+		return "", ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+func (h Htpasswd) Challenge() string {
+	return `Basic realm="cdn-edge"`
+}
+
+// checkPassword reports whether the given password matches the given htpasswd hash.
+func checkPassword(password, hash string) bool {
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, htpasswdSHA):
+		sum := sha1.Sum([]byte(password))
+		computed = htpasswdSHA + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, htpasswdAPR1):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hash, htpasswdAPR1), "$")
+		computed = apr1(password, salt)
+	case strings.HasPrefix(hash, htpasswdPlain):
+		computed = htpasswdPlain + password
+	default:
+		return false // unsupported format (rejected by LoadHtpasswd)
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// apr1 computes Apache's MD5-based password hash ("$apr1$<salt>$<hash>").
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+
+	d := md5.New()
+	d.Write([]byte(password + magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		d.Write(alt[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	final := d.Sum(nil)
+
+	// 1000 rounds to slow down brute force
+	for i := 0; i < 1000; i++ {
+		r := md5.New()
+		if i&1 == 1 {
+			r.Write(pw)
+		} else {
+			r.Write(final)
+		}
+		if i%3 != 0 {
+			r.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			r.Write(pw)
+		}
+		if i&1 == 1 {
+			r.Write(final)
+		} else {
+			r.Write(pw)
+		}
+		final = r.Sum(nil)
+	}
+
+	// Custom base64 encoding of the digest bytes, in crypt's order
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	var out []byte
+	encode := func(a, b, c byte, n int) {
+		v := uint(a)<<16 | uint(b)<<8 | uint(c)
+		for ; n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	encode(final[0], final[6], final[12], 4)
+	encode(final[1], final[7], final[13], 4)
+	encode(final[2], final[8], final[14], 4)
+	encode(final[3], final[9], final[15], 4)
+	encode(final[4], final[10], final[5], 4)
+	encode(0, 0, final[11], 2)
+
+	return magic + salt + "$" + string(out)
+}
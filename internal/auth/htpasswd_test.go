@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"cdn-edge-server/internal/http"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHtpasswd(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# users\n" +
+		"alice:$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/\n" + // openssl passwd -apr1 -salt abcdefgh secret
+		"bob:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n" +
+		"carol:{PLAIN}secret\n"
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	users, err := LoadHtpasswd(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user, password string
+		ok             bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"alice", "$apr1$abcdefgh$h9FWgUz3n9YxylKLlR5SQ/", false},
+		{"bob", "secret", true},
+		{"bob", "{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=", false},
+		{"carol", "secret", true},
+		{"carol", "{PLAIN}secret", false},
+		{"dave", "secret", false},
+	}
+	for _, tt := range tests {
+		credentials := base64.StdEncoding.EncodeToString([]byte(tt.user + ":" + tt.password))
+		req := &http.Request{Headers: map[string]string{"Authorization": "Basic " + credentials}}
+		user, err := users.Authenticate(req)
+		if ok := err == nil && user == tt.user; ok != tt.ok {
+			t.Errorf("%s:%s: got %q, %v, want ok = %t", tt.user, tt.password, user, err, tt.ok)
+		}
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+	if user, err := users.Authenticate(&http.Request{Headers: map[string]string{"authorization": "Basic " + credentials}}); user != "alice" {
+		t.Errorf("lowercase authorization header: got %q, %v, want alice", user, err)
+	}
+	if _, err := users.Authenticate(&http.Request{Headers: map[string]string{}}); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no Authorization header: got %v, want %v", err, ErrNoCredentials)
+	}
+}
+
+func TestLoadHtpasswdErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	for _, line := range []string{
+		"alice",
+		"alice:$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC", // bcrypt
+		"alice:rqXexS6ZhobKA", // crypt (DES)
+		"alice:$5$rounds=5000$salt$hash",
+		"alice:$6$salt$hash",
+		"alice:secret", // unmarked plain text
+	} {
+		if err := os.WriteFile(file, []byte(line+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadHtpasswd(file); err == nil || !strings.Contains(err.Error(), ":1:") {
+			t.Errorf("LoadHtpasswd(%q) = %v, want an error on line 1", line, err)
+		}
+	}
+}
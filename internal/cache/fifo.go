@@ -1,127 +1,612 @@
 package cache
 
 import (
-	"cdn-edge-server/internal/config"
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 )
 
+// ErrStale is returned by AddIfCurrent for a file purged while it was being fetched.
+var ErrStale = errors.New("purged while fetched")
+
+const (
+	indexFile   = ".index"    // persisted FIFO order, written on shutdown
+	hotKeysFile = ".hotkeys"  // the edge's most requested files, for warm-up on the next start
+	variantsDir = ".variants" // encoded copies of cached files, in a directory per encoding
+)
+
+// Cache is a FIFO cache of files stored in one directory. Each virtual host has its own.
+// Metadata-only caches (NewMetadataOnly) keep no contents and may use another Policy, to
+// simulate the cache offline.
+type Cache struct {
+	name     string // namespace, for logging ("" for the default cache)
+	dir      string // "" = metadata only
+	capacity int
+	policy   Policy
+
+	mu        sync.Mutex             // guards the fields below (handlers and purge subscriber run concurrently)
+	queue     []string               // eviction queue, next to be evicted first
+	present   map[string]bool        // filename → bool (is present?)
+	sizes     map[string]int64       // filename → size in bytes
+	access    map[string]*accessInfo // filename → when cached and how it's been used since
+	variants  map[string][]string    // filename → encodings of its cached variants
+	purges    map[string]uint64      // filename → times purged or invalidated (see Generation)
+	bytes     int64                  // total size of cached files (without variants)
+	hits      uint64
+	misses    uint64
+	evictions map[EvictReason]uint64
+}
+
+type accessInfo struct {
+	cachedAt   time.Time
+	hits       uint64
+	lastAccess time.Time // zero = no hit since cached (or since the cache was loaded)
+	referenced bool      // hit since the clock policy last passed it
+}
+
+// Policy decides which file is evicted when the cache is full.
+type Policy string
+
 const (
-	MaxCacheFiles = 5
+	PolicyFIFO  Policy = "fifo"  // first cached, first evicted (the edge's policy)
+	PolicyLRU   Policy = "lru"   // least recently requested first
+	PolicyClock Policy = "clock" // FIFO, but files hit since they were queued get a second chance
 )
 
-var queue []string                  // FIFO queue
-var present = make(map[string]bool) // filename → bool (is present?)
+// Policies lists every Policy.
+var Policies = []Policy{PolicyFIFO, PolicyLRU, PolicyClock}
+
+// ParsePolicy returns the policy with the given name.
+func ParsePolicy(name string) (Policy, error) {
+	for _, p := range Policies {
+		if strings.EqualFold(name, string(p)) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown cache policy %q (expected fifo, lru or clock)", name)
+}
+
+// EvictReason is why a file left the cache.
+type EvictReason string
+
+const (
+	EvictCapacity     EvictReason = "capacity"     // oldest file removed to make room
+	EvictTTL          EvictReason = "ttl"          // expired copy replaced, or gone from the origin
+	EvictInvalidation EvictReason = "invalidation" // written through the edge or changed on the origin
+	EvictCorruption   EvictReason = "corruption"   // cached file missing or unreadable
+)
+
+// EvictReasons lists every EvictReason, in the order reports show them.
+var EvictReasons = []EvictReason{EvictCapacity, EvictTTL, EvictInvalidation, EvictCorruption}
+
+// Stats is a snapshot of a cache's fill level and usage since it was created.
+type Stats struct {
+	Entries   int
+	Bytes     int64
+	Capacity  int // max entries
+	Hits      uint64
+	Misses    uint64
+	Evictions map[EvictReason]uint64
+}
+
+// EntryInfo describes a cached file.
+type EntryInfo struct {
+	Name       string
+	Size       int64
+	Position   int       // in the eviction queue, 1 = next to be evicted
+	CachedAt   time.Time // when the file was stored (its modification time, for files loaded by Init)
+	Hits       uint64
+	LastAccess time.Time // last hit (zero = none since cached, or since the cache was loaded)
+}
+
+// New returns an empty cache storing up to capacity files in the given directory (created if
+// needed). Call Init to load files already there.
+func New(name, dir string, capacity int) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := NewMetadataOnly(capacity, PolicyFIFO)
+	c.name, c.dir = name, dir
+	return c, nil
+}
+
+// NewMetadataOnly returns an empty cache of up to capacity files that only tracks their names
+// and sizes (see AddSize), evicting them by the given policy. It never touches the disk.
+func NewMetadataOnly(capacity int, policy Policy) *Cache {
+	return &Cache{
+		capacity:  capacity,
+		policy:    policy,
+		present:   make(map[string]bool),
+		sizes:     make(map[string]int64),
+		access:    make(map[string]*accessInfo),
+		variants:  make(map[string][]string),
+		purges:    make(map[string]uint64),
+		evictions: make(map[EvictReason]uint64),
+	}
+}
+
+// log logs a cache event at the given level, tagged with the cache's namespace.
+func (c *Cache) log(level slog.Level, msg string, args ...any) {
+	if c.name != "" {
+		args = append([]any{"cache", c.name}, args...)
+	}
+	slog.Log(context.Background(), level, msg, args...)
+}
+
+// Init loads existing files into FIFO, in the order saved by the last Flush if any.
+// Files missing from the saved index are queued after the indexed ones, in alphabetical order.
+func (c *Cache) Init() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		return
+	}
+
+	// Variants aren't indexed, they are encoded again when requested
+	os.RemoveAll(filepath.Join(c.dir, variantsDir))
+
+	// Restore order from the index (skipping entries whose file has since disappeared)
+	if index, err := os.ReadFile(filepath.Join(c.dir, indexFile)); err == nil {
+		for _, name := range strings.Split(string(index), "\n") {
+			if name == "" || c.present[name] {
+				continue
+			}
+			info, err := os.Stat(filepath.Join(c.dir, name))
+			if err != nil {
+				continue
+			}
+			c.queue = append(c.queue, name)
+			c.present[name] = true
+			c.setSize(name, info.Size())
+			c.access[name] = &accessInfo{cachedAt: info.ModTime()}
+		}
+	}
 
-// Init loads existing files into FIFO based on alphabetical order.
-func Init() {
-	files, _ := os.ReadDir(config.CacheDir)
+	files, _ := os.ReadDir(c.dir)
 	for _, f := range files {
 		name := f.Name()
 
-		if name == ".gitkeep" {
-			continue // ignore git file (not part of edge server cache)
+		if isReserved(name) || f.IsDir() || c.present[name] {
+			continue // ignore git/index files and other caches' directories
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
 		}
 
-		queue = append(queue, name)
-		present[name] = true
+		c.queue = append(c.queue, name)
+		c.present[name] = true
+		c.setSize(name, info.Size())
+		c.access[name] = &accessInfo{cachedAt: info.ModTime()}
+	}
+
+	// Capacity may have been lowered since these files were cached
+	for len(c.queue) > c.capacity {
+		c.evict()
+	}
+	c.log(slog.LevelInfo, "Cache initialized", "files", len(c.queue))
+}
+
+// Flush persists the cache's FIFO order to disk so the next Init restores it.
+func (c *Cache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dir == "" {
+		return nil
+	}
+
+	index := strings.Join(c.queue, "\n")
+	if err := os.WriteFile(filepath.Join(c.dir, indexFile), []byte(index), 0644); err != nil {
+		return err
 	}
-	fmt.Printf("[Cache] Initialized with %d files\n", len(queue))
+	c.log(slog.LevelInfo, "Cache index flushed", "files", len(c.queue))
+	return nil
 }
 
 // Has checks if the file with the given name is present in the cache.
-func Has(name string) bool {
-	return present[name]
+func (c *Cache) Has(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.present[name]
 }
 
 // Get reads and returns the given filename from the cache (only called in case of cache hit).
-func Get(name string) ([]byte, error) {
-	return os.ReadFile(filepath.Join(config.CacheDir, name))
+func (c *Cache) Get(name string) ([]byte, error) {
+	if c.dir == "" {
+		return nil, os.ErrNotExist
+	}
+	return os.ReadFile(filepath.Join(c.dir, name))
+}
+
+// Stat returns the file info of the given cached file, without reading it.
+func (c *Cache) Stat(name string) (os.FileInfo, error) {
+	if !c.Has(name) || c.dir == "" {
+		return nil, os.ErrNotExist
+	}
+	return os.Stat(filepath.Join(c.dir, name))
 }
 
 // Add adds the file with the given name to the cache.
-func Add(name string, data []byte) error {
-	// Cannot write git files to cache or server storage
-	if name == ".gitkeep" {
-		return fmt.Errorf(".gitkeep cannot be added to server storage")
+func (c *Cache) Add(name string, data []byte) error {
+	return c.add(name, int64(len(data)), data)
+}
+
+// AddSize adds a file with the given name and size to a metadata-only cache, as Add would.
+func (c *Cache) AddSize(name string, size int64) error {
+	if c.dir != "" {
+		return fmt.Errorf("%s: cache stores contents, use Add", name)
+	}
+	return c.add(name, size, nil)
+}
+
+// Generation returns the number of times the given file was purged or invalidated. Read it
+// before fetching the file from the origin, and store the fetched copy with AddIfCurrent.
+func (c *Cache) Generation(name string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.purges[name]
+}
+
+// AddIfCurrent adds the file with the given name to the cache like Add, unless it was purged or
+// invalidated since Generation returned gen: the contents, fetched before that, may be outdated,
+// and the purge would be undone. It returns ErrStale in that case.
+func (c *Cache) AddIfCurrent(name string, data []byte, gen uint64) error {
+	if isReserved(name) {
+		return fmt.Errorf("%s cannot be added to server storage", name)
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.purges[name] != gen {
+		c.log(slog.LevelDebug, "Cache dropped copy fetched before a purge", "file", name)
+		return ErrStale
+	}
+	return c.insert(name, int64(len(data)), data)
+}
+
+// add adds the file with the given name and size to the cache, writing data unless the cache
+// is metadata only.
+func (c *Cache) add(name string, size int64, data []byte) error {
+	// Cannot write git/index files to cache or server storage
+	if isReserved(name) {
+		return fmt.Errorf("%s cannot be added to server storage", name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.insert(name, size, data)
+}
+
+// insert adds or overwrites the file with the given name and size. Callers must hold mu.
+func (c *Cache) insert(name string, size int64, data []byte) error {
 	// If file is already in cache, overwrite
-	if present[name] {
+	if c.present[name] {
 		// Update existing file in local cache storage
-		err := os.WriteFile(filepath.Join(config.CacheDir, name), data, 0644)
-		if err != nil {
+		if err := c.write(name, data); err != nil {
 			return err
 		}
 
 		// Update cached file's position in queue (remove from current position then enque)
-		for i, f := range queue {
+		for i, f := range c.queue {
 			if f == name {
-				queue = append(queue[:i], queue[i+1:]...)
+				c.queue = append(c.queue[:i], c.queue[i+1:]...)
 				break
 			}
 		}
-		queue = append(queue, name)
+		c.queue = append(c.queue, name)
+		c.setSize(name, size)
+		c.dropVariants(name) // encoded from the previous contents
+		c.access[name].cachedAt = time.Now()
+		c.access[name].referenced = false
 
-		fmt.Printf("[Cache] Updated existing: %s\n", name)
+		c.log(slog.LevelDebug, "Cache updated existing file", "file", name)
 		return nil
 	}
 
 	// Eviction check (to ensure queue size remains within the max cache size)
-	if len(queue) >= MaxCacheFiles {
-		evict()
+	if len(c.queue) >= c.capacity {
+		c.evict()
 	}
 
 	// Write file
-	err := os.WriteFile(filepath.Join(config.CacheDir, name), data, 0644)
-	if err != nil {
+	if err := c.write(name, data); err != nil {
 		return err
 	}
 
 	// Register in metadata
-	queue = append(queue, name)
-	present[name] = true
+	c.queue = append(c.queue, name)
+	c.present[name] = true
+	c.setSize(name, size)
+	c.access[name] = &accessInfo{cachedAt: time.Now()}
 
-	fmt.Printf("[Cache] Added: %s (queue size: %d/%d)\n", name, len(queue), MaxCacheFiles)
+	c.log(slog.LevelDebug, "Cache added file", "file", name, "queue", len(c.queue), "capacity", c.capacity)
 
 	return nil
 }
 
-// evict removes the last file in the cache's internal FIFO structure from the cache.
-func evict() {
-	oldest := queue[0]
-	queue = queue[1:]       // pop front of queue
-	delete(present, oldest) // mark popped file as unpresent in queue
-	fmt.Printf("[Cache] Evicted: %s (oldest file in cache)\n", oldest)
-	os.Remove(filepath.Join(config.CacheDir, oldest))
+// write stores the given file's contents, unless the cache is metadata only. Callers must hold mu.
+func (c *Cache) write(name string, data []byte) error {
+	if c.dir == "" {
+		return nil
+	}
+	return os.WriteFile(filepath.Join(c.dir, name), data, 0644)
+}
+
+// evict removes the file at the front of the cache's eviction queue from the cache (with the
+// clock policy, the first one not hit since it was queued, requeuing the others).
+func (c *Cache) evict() {
+	if c.policy == PolicyClock {
+		for a := c.access[c.queue[0]]; a.referenced; a = c.access[c.queue[0]] {
+			a.referenced = false
+			c.queue = append(c.queue[1:], c.queue[0])
+		}
+	}
+
+	oldest := c.queue[0]
+	c.queue = c.queue[1:]     // pop front of queue
+	delete(c.present, oldest) // mark popped file as unpresent in queue
+	c.setSize(oldest, 0)
+	delete(c.access, oldest)
+	c.evictions[EvictCapacity]++
+	c.log(slog.LevelInfo, "Cache evicted oldest file", "file", oldest)
+	if c.dir != "" {
+		os.Remove(filepath.Join(c.dir, oldest))
+	}
+	c.dropVariants(oldest)
 }
 
 // Remove removes the file with the given name from the cache, if present.
-func Remove(filename string) {
-	if !present[filename] {
-		return
+func (c *Cache) Remove(filename string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purges[filename]++ // even if not cached, it may be being fetched
+	if c.drop(filename) {
+		c.evictions[EvictInvalidation]++
+		c.log(slog.LevelInfo, "Cache invalidated file after write", "file", filename)
+	}
+}
+
+// Purge removes the file with the given name from the cache because the origin reported
+// that its content changed (e.g. a write that went through another edge), if present.
+func (c *Cache) Purge(filename string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purges[filename]++ // even if not cached, it may be being fetched
+	if c.drop(filename) {
+		c.evictions[EvictInvalidation]++
+		c.log(slog.LevelInfo, "Cache purged file changed on origin", "file", filename)
+	}
+}
+
+// Evict removes the file with the given name from the cache for the given reason, returning
+// false if it was not cached.
+func (c *Cache) Evict(filename string, reason EvictReason) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if reason == EvictInvalidation {
+		c.purges[filename]++
+	}
+	if !c.drop(filename) {
+		return false
+	}
+	c.evictions[reason]++
+	c.log(slog.LevelInfo, "Cache evicted file", "file", filename, "reason", reason)
+	return true
+}
+
+// RecordHit counts a request served from the cached file with the given name.
+func (c *Cache) RecordHit(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hits++
+	if a := c.access[name]; a != nil {
+		a.hits++
+		a.lastAccess = time.Now()
+		switch c.policy {
+		case PolicyLRU:
+			c.requeue(name)
+		case PolicyClock:
+			a.referenced = true
+		}
+	}
+}
+
+// requeue moves the given cached file to the back of the eviction queue. Callers must hold mu.
+func (c *Cache) requeue(name string) {
+	for i, f := range c.queue {
+		if f == name {
+			c.queue = append(append(c.queue[:i], c.queue[i+1:]...), name)
+			return
+		}
+	}
+}
+
+// RecordMiss counts a request the cache couldn't serve.
+func (c *Cache) RecordMiss() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.misses++
+}
+
+// isReserved reports whether the given name is a file in the cache directory that isn't a cached file.
+func isReserved(name string) bool {
+	return name == ".gitkeep" || name == indexFile || name == hotKeysFile
+}
+
+// drop removes the given file from the cache's metadata and disk, returning false if it
+// was not cached. Callers must hold mu.
+func (c *Cache) drop(filename string) bool {
+	if !c.present[filename] {
+		return false
 	}
 
 	// Remove from queue
-	for i, f := range queue {
+	for i, f := range c.queue {
 		if f == filename {
-			queue = append(queue[:i], queue[i+1:]...)
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
 			break
 		}
 	}
 
 	// Remove from present map
-	delete(present, filename)
+	delete(c.present, filename)
+	c.setSize(filename, 0)
+	delete(c.access, filename)
 
 	// Delete file from disk
-	os.Remove(filepath.Join(config.CacheDir, filename))
-	fmt.Printf("[Cache] Invalidated: %s (due to write operation)\n", filename)
+	if c.dir != "" {
+		os.Remove(filepath.Join(c.dir, filename))
+	}
+	c.dropVariants(filename)
+	return true
+}
+
+// GetVariant reads the given cached file's variant in the given encoding (e.g. "gzip"),
+// stored by AddVariant.
+func (c *Cache) GetVariant(name, encoding string) ([]byte, error) {
+	c.mu.Lock()
+	stored := slices.Contains(c.variants[name], encoding)
+	c.mu.Unlock()
+	if !stored || c.dir == "" {
+		return nil, os.ErrNotExist
+	}
+	return os.ReadFile(filepath.Join(c.dir, variantsDir, encoding, name))
+}
+
+// AddVariant stores a variant of the given cached file in the given encoding. Variants don't
+// count towards the capacity: they are dropped with the file, or when it is overwritten.
+func (c *Cache) AddVariant(name, encoding string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.present[name] {
+		return fmt.Errorf("%s: not cached", name)
+	}
+	if c.dir == "" {
+		return nil
+	}
+	dir := filepath.Join(c.dir, variantsDir, encoding)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+		return err
+	}
+	if !slices.Contains(c.variants[name], encoding) {
+		c.variants[name] = append(c.variants[name], encoding)
+	}
+	return nil
+}
+
+// dropVariants removes the given file's variants. Callers must hold mu.
+func (c *Cache) dropVariants(name string) {
+	for _, encoding := range c.variants[name] {
+		os.Remove(filepath.Join(c.dir, variantsDir, encoding, name))
+	}
+	delete(c.variants, name)
+}
+
+// setSize records the size of the given file (0 = no longer cached). Callers must hold mu.
+func (c *Cache) setSize(name string, size int64) {
+	c.bytes += size - c.sizes[name]
+	if size == 0 {
+		delete(c.sizes, name)
+	} else {
+		c.sizes[name] = size
+	}
+}
+
+// Name returns the cache's namespace ("" for the default cache).
+func (c *Cache) Name() string {
+	return c.name
+}
+
+// Stats returns the cache's current fill level.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	evictions := make(map[EvictReason]uint64, len(EvictReasons))
+	for _, reason := range EvictReasons {
+		evictions[reason] = c.evictions[reason]
+	}
+	return Stats{
+		Entries:   len(c.queue),
+		Bytes:     c.bytes,
+		Capacity:  c.capacity,
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: evictions,
+	}
+}
+
+// Entries describes every cached file, in eviction order (next to be evicted first).
+func (c *Cache) Entries() []EntryInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]EntryInfo, len(c.queue))
+	for i, name := range c.queue {
+		entries[i] = c.entryInfo(name, i)
+	}
+	return entries
+}
+
+// Entry describes the cached file with the given name, or returns false if it isn't cached.
+func (c *Cache) Entry(name string) (EntryInfo, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, f := range c.queue {
+		if f == name {
+			return c.entryInfo(name, i), true
+		}
+	}
+	return EntryInfo{}, false
+}
+
+// entryInfo describes the cached file at the given queue index. Callers must hold mu.
+func (c *Cache) entryInfo(name string, index int) EntryInfo {
+	info := EntryInfo{Name: name, Size: c.sizes[name], Position: index + 1}
+	if a := c.access[name]; a != nil {
+		info.CachedAt, info.Hits, info.LastAccess = a.cachedAt, a.hits, a.lastAccess
+	}
+	return info
+}
+
+// Position returns the given file's position in the eviction queue (1 = next to be evicted) and the
+// queue's length, or 0 if the file isn't cached.
+func (c *Cache) Position(name string) (pos, total int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i, f := range c.queue {
+		if f == name {
+			return i + 1, len(c.queue)
+		}
+	}
+	return 0, len(c.queue)
 }
 
 // CacheContent returns a copy of the cache queue (list of cached filenames in order)
-func CacheContent() []string {
-	result := make([]string, len(queue))
-	copy(result, queue)
+func (c *Cache) CacheContent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := make([]string, len(c.queue))
+	copy(result, c.queue)
 	return result
 }
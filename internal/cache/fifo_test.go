@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddIfCurrent(t *testing.T) {
+	c, err := New("", t.TempDir(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing happened during the fetch
+	gen := c.Generation("a.txt")
+	if err := c.AddIfCurrent("a.txt", []byte("a"), gen); err != nil || !c.Has("a.txt") {
+		t.Fatalf("AddIfCurrent() = %v, cached = %t, want cached", err, c.Has("a.txt"))
+	}
+
+	// Purged (even while not cached) or invalidated during the fetch: the copy is dropped
+	adminPurge := func(name string) { c.Evict(name, EvictInvalidation) }
+	for name, invalidate := range map[string]func(string){"purge": c.Purge, "write": c.Remove, "admin purge": adminPurge} {
+		gen := c.Generation("b.txt")
+		invalidate("b.txt")
+		if err := c.AddIfCurrent("b.txt", []byte("old"), gen); !errors.Is(err, ErrStale) || c.Has("b.txt") {
+			t.Errorf("%s during fetch: AddIfCurrent() = %v, cached = %t, want %v", name, err, c.Has("b.txt"), ErrStale)
+		}
+
+		// A fetch started after it stores its copy
+		if err := c.AddIfCurrent("b.txt", []byte("new"), c.Generation("b.txt")); err != nil {
+			t.Errorf("%s before fetch: AddIfCurrent() = %v", name, err)
+		}
+		c.Remove("b.txt")
+	}
+
+	// Evictions aren't purges
+	gen = c.Generation("a.txt")
+	c.Evict("a.txt", EvictTTL)
+	if err := c.AddIfCurrent("a.txt", []byte("a2"), gen); err != nil {
+		t.Errorf("AddIfCurrent() after TTL eviction = %v", err)
+	}
+}
+
+func TestVariants(t *testing.T) {
+	dir := t.TempDir()
+	c, err := New("", dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	variant := func(name, encoding string) string {
+		data, err := c.GetVariant(name, encoding)
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	stored := func(name, encoding string) bool {
+		_, err := os.Stat(filepath.Join(dir, variantsDir, encoding, name))
+		return err == nil
+	}
+
+	if err := c.AddVariant("a.txt", "gzip", []byte("gz")); err == nil {
+		t.Error("AddVariant() of a file not cached: no error")
+	}
+	c.Add("a.txt", []byte("a"))
+	c.AddVariant("a.txt", "gzip", []byte("gz"))
+	c.AddVariant("a.txt", "deflate", []byte("df"))
+	if variant("a.txt", "gzip") != "gz" || variant("a.txt", "deflate") != "df" || variant("a.txt", "br") != "" {
+		t.Errorf("variants = %q, %q, %q, want gz, df and none", variant("a.txt", "gzip"), variant("a.txt", "deflate"), variant("a.txt", "br"))
+	}
+
+	// Overwriting the file drops its variants
+	c.Add("a.txt", []byte("a2"))
+	if variant("a.txt", "gzip") != "" || stored("a.txt", "gzip") || stored("a.txt", "deflate") {
+		t.Error("variants kept after the file was overwritten")
+	}
+
+	// So do evicting, purging and removing it
+	c.AddVariant("a.txt", "gzip", []byte("gz2"))
+	c.Add("b.txt", []byte("b"))
+	c.AddVariant("b.txt", "gzip", []byte("gz"))
+	c.Add("c.txt", []byte("c")) // evicts a.txt
+	if c.Has("a.txt") || variant("a.txt", "gzip") != "" || stored("a.txt", "gzip") {
+		t.Error("variant kept after the file was evicted")
+	}
+	c.Purge("b.txt")
+	if variant("b.txt", "gzip") != "" || stored("b.txt", "gzip") {
+		t.Error("variant kept after the file was purged")
+	}
+	c.AddVariant("c.txt", "gzip", []byte("gz"))
+	c.Remove("c.txt")
+	if variant("c.txt", "gzip") != "" || stored("c.txt", "gzip") {
+		t.Error("variant kept after the file was removed")
+	}
+
+	// Variants aren't counted as cached files, or kept across restarts
+	c.Add("d.txt", []byte("d"))
+	c.AddVariant("d.txt", "gzip", []byte("gz"))
+	if entries := c.CacheContent(); len(entries) != 1 || entries[0] != "d.txt" {
+		t.Errorf("CacheContent() = %v, want [d.txt]", entries)
+	}
+	reloaded, err := New("", dir, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.Init()
+	if _, err := reloaded.GetVariant("d.txt", "gzip"); err == nil || stored("d.txt", "gzip") {
+		t.Error("variant kept after reloading the cache")
+	}
+}
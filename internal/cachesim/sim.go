@@ -0,0 +1,237 @@
+// Package cachesim replays request traces through metadata-only edge caches (the production
+// cache code, without contents) to compare eviction policies and capacities offline.
+package cachesim
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/cache"
+	"cdn-edge-server/internal/logging"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request is one request of a trace.
+type Request struct {
+	Time  time.Time // zero if the trace has no timestamps
+	Host  string    // virtual host ("" = the default host), each has its own cache
+	Key   string    // cached file name
+	Size  int64     // response body size
+	Write bool      // POST/PUT, which invalidates the cached file instead of reading it
+}
+
+// Result is how a cache of the given policy and capacity did on a trace.
+type Result struct {
+	Policy    cache.Policy
+	Capacity  int // per host, as CACHE_MAX_FILES
+	Requests  uint64
+	Hits      uint64
+	Bytes     int64 // requested
+	HitBytes  int64 // served from the cache
+	Evictions uint64
+}
+
+func (r Result) HitRatio() float64 {
+	return ratio(float64(r.Hits), float64(r.Requests))
+}
+
+func (r Result) ByteHitRatio() float64 {
+	return ratio(float64(r.HitBytes), float64(r.Bytes))
+}
+
+func ratio(a, b float64) float64 {
+	if b == 0 {
+		return 0
+	}
+	return a / b
+}
+
+// traceLine is a JSONL trace line: {"key", "size"[, "time"][, "host"]}, or an edge capture
+// record (see logging.Record).
+type traceLine struct {
+	Key  string          `json:"key"`
+	Size int64           `json:"size"`
+	Time json.RawMessage `json:"time"` // RFC 3339, or Unix seconds
+	Host string          `json:"host"`
+
+	// Capture records only
+	Method       string            `json:"method"`
+	Target       string            `json:"target"`
+	Headers      map[string]string `json:"headers"`
+	Status       int               `json:"status"`
+	ResponseSize int64             `json:"response_size"`
+}
+
+// ReadTrace reads the requests of a trace, line by line: edge access log lines (any format),
+// edge capture records, or JSON objects with a "key", "size" and optional "time" and "host".
+// Of log and capture lines, only GETs answered with 200 (the responses the edge caches) and
+// successful POST/PUTs are kept; skipped counts the others.
+func ReadTrace(r io.Reader) (requests []Request, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var req Request
+		var method, target string
+		var status int
+		var t traceLine
+		if strings.HasPrefix(line, "{") {
+			if err := json.Unmarshal([]byte(line), &t); err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", n, err)
+			}
+		}
+		switch {
+		case t.Key != "": // trace
+			if req.Time, err = parseTime(t.Time); err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", n, err)
+			}
+			req.Host, req.Key, req.Size = t.Host, t.Key, t.Size
+			requests = append(requests, req)
+			continue
+		case t.Headers != nil: // capture record
+			if req.Time, err = parseTime(t.Time); err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", n, err)
+			}
+			method, target, status = t.Method, t.Target, t.Status
+			req.Host, req.Size = t.Headers["Host"], t.ResponseSize
+		default: // access log
+			e, err := logging.ParseLine(line)
+			if err != nil {
+				return nil, 0, fmt.Errorf("line %d: %w", n, err)
+			}
+			method, target, status = e.Method, e.Target, e.Status
+			req.Time, req.Host, req.Size = e.Time, e.Host, e.Bytes
+		}
+
+		// The edge caches files by base name, per virtual host
+		p, _, _ := strings.Cut(target, "?")
+		req.Key = path.Base(p)
+		switch {
+		case method == "GET" && status == 200:
+		case (method == "POST" || method == "PUT") && status/100 == 2:
+			req.Write = true
+		default:
+			skipped++
+			continue
+		}
+		requests = append(requests, req)
+	}
+	return requests, skipped, scanner.Err()
+}
+
+func parseTime(raw json.RawMessage) (time.Time, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return time.Time{}, nil
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		return time.Parse(time.RFC3339Nano, s)
+	}
+	secs, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %s", raw)
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))), nil
+}
+
+// SortByTime orders the requests by time, keeping the order of requests without one (or with
+// the same one), so traces spread over several files can be given in any order.
+func SortByTime(requests []Request) {
+	sort.SliceStable(requests, func(i, j int) bool { return requests[i].Time.Before(requests[j].Time) })
+}
+
+// Run simulates the requests against one cache per virtual host, of the given policy and
+// capacity: a request for a cached file with the same size is a hit, anything else a miss
+// after which the file is cached, as the edge does; writes invalidate the file.
+func Run(requests []Request, policy cache.Policy, capacity int) Result {
+	res := Result{Policy: policy, Capacity: capacity}
+	caches := make(map[string]*cache.Cache)
+
+	for _, req := range requests {
+		c := caches[req.Host]
+		if c == nil {
+			c = cache.NewMetadataOnly(capacity, policy)
+			caches[req.Host] = c
+		}
+
+		if req.Write {
+			c.Remove(req.Key)
+			continue
+		}
+		res.Requests++
+		res.Bytes += req.Size
+
+		// A different size means the file changed on the origin, which would have purged it
+		if c.Has(req.Key) {
+			if entry, _ := c.Entry(req.Key); entry.Size != req.Size {
+				c.Purge(req.Key)
+			}
+		}
+		if c.Has(req.Key) {
+			c.RecordHit(req.Key)
+			res.Hits++
+			res.HitBytes += req.Size
+			continue
+		}
+		c.RecordMiss()
+		c.AddSize(req.Key, req.Size)
+	}
+
+	for _, c := range caches {
+		res.Evictions += c.Stats().Evictions[cache.EvictCapacity]
+	}
+	return res
+}
+
+// RunAll runs the simulation for every policy and capacity, in parallel, and returns the
+// results ordered by policy, then capacity.
+func RunAll(requests []Request, policies []cache.Policy, capacities []int) []Result {
+	results := make([]Result, len(policies)*len(capacities))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, policy := range policies {
+		for j, capacity := range capacities {
+			sem <- struct{}{}
+			wg.Add(1)
+			go func() {
+				defer func() { <-sem; wg.Done() }()
+				results[i*len(capacities)+j] = Run(requests, policy, capacity)
+			}()
+		}
+	}
+	wg.Wait()
+	return results
+}
+
+// WriteCSV writes the results as CSV, with a header row.
+func WriteCSV(w io.Writer, results []Result) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"policy", "capacity", "requests", "hits", "hit_ratio", "bytes", "hit_bytes", "byte_hit_ratio", "evictions"})
+	for _, r := range results {
+		cw.Write([]string{
+			string(r.Policy),
+			strconv.Itoa(r.Capacity),
+			strconv.FormatUint(r.Requests, 10),
+			strconv.FormatUint(r.Hits, 10),
+			strconv.FormatFloat(r.HitRatio(), 'f', 4, 64),
+			strconv.FormatInt(r.Bytes, 10),
+			strconv.FormatInt(r.HitBytes, 10),
+			strconv.FormatFloat(r.ByteHitRatio(), 'f', 4, 64),
+			strconv.FormatUint(r.Evictions, 10),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
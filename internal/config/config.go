@@ -4,6 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -17,6 +21,125 @@ var (
 	EdgePort   string
 	OriginHost string
 	OriginPort string
+
+	// Edge virtual hosts (unset = every Host served from ORIGIN_HOST:ORIGIN_PORT into CACHE_DIR)
+	VHostsFile    string // "<host> <origin host:port> [max cached files]" lines
+	VHostDefault  string // host used for requests without a Host header (default: first in file)
+	CacheMaxFiles int    // default cache capacity, per host
+
+	// Edge cache freshness and debugging
+	CacheTTL          time.Duration // how long a cached file is served before it's fetched again (0 = forever)
+	CacheDebugHeaders bool          // "X-Cache-Debug: 1" requests get the cache key, TTL and eviction position
+
+	// Edge admin port (metrics), unset = disabled
+	AdminHost string
+	AdminPort string
+
+	// Logging
+	LogLevel            string // diagnostic log level: debug, info, warn or error
+	AccessLogFile       string // edge access log (unset = stdout)
+	AccessLogFormat     string // "common", "combined" or "json"
+	AccessLogMaxSize    int64  // bytes before the access log file is rotated (0 = never)
+	AccessLogMaxBackups int    // rotated access log files kept
+
+	// Traffic capture (requests and response hashes as JSONL, for cli replay), unset = disabled
+	CaptureFile       string
+	CaptureBodies     bool  // also capture request bodies (needed to replay writes)
+	CaptureMaxSize    int64 // bytes before the capture file is rotated (0 = never)
+	CaptureMaxBackups int   // rotated capture files kept
+
+	// Edge cache warm-up (files fetched into free cache slots at startup or via the admin API)
+	WarmManifest    string  // "[<host> ]<file>" lines warmed at startup, most important first
+	WarmHotKeys     int     // most requested files per host saved on shutdown and warmed on the next start (0 = off)
+	WarmHotKeysFile string  // where those are saved
+	WarmConcurrency int     // concurrent origin fetches per host
+	WarmRate        float64 // origin fetches per second per host (0 = unlimited)
+
+	// Edge prefetch of the assets HTML pages link to, fetched into the cache after the page's cache miss
+	PrefetchLinks       bool
+	PrefetchMaxPerPage  int // assets prefetched per page, at most
+	PrefetchConcurrency int // concurrent prefetches, for all pages
+
+	// Edge compression of text responses (gzip or deflate, per Accept-Encoding), cached next to the files
+	Compression     bool
+	CompressMinSize int // smaller files are sent as is
+	CompressLevel   int // 1 (fastest) to 9 (smallest)
+
+	// Tracing (spans are only propagated unless a file or collector is set)
+	TraceFile        string  // OTLP JSON export file, one batch per line
+	TraceCollector   string  // OTLP/HTTP collector host:port (JSON over plain HTTP)
+	TraceSampleRatio float64 // share of new traces recorded (traces continued from a client follow its flag)
+
+	PurgeEvents     bool          // edge subscribes to the origin's change events
+	ShutdownTimeout time.Duration // how long in-flight requests may take to drain on shutdown
+
+	// Edge connection limits (slowloris/memory protection)
+	MaxConns          int           // concurrent client connections, extra ones get 503
+	HeaderReadTimeout time.Duration // time allowed to send the request line and headers
+	BodyReadTimeout   time.Duration // time allowed to send the request body
+	WriteTimeout      time.Duration // time allowed to fetch and write the response
+	MaxHeaderBytes    int           // request line + headers, larger gets 431
+	MaxHeaderCount    int           // number of headers, more gets 431
+	MaxBodyBytes      int64         // request body, larger gets 413
+
+	// Edge to origin limits (a stalled or misbehaving origin can't tie up edge resources)
+	OriginConnectTimeout time.Duration // time allowed to connect to an origin
+	OriginTimeout        time.Duration // time allowed for a whole exchange with an origin
+	OriginMaxBodyBytes   int64         // origin response body, larger gets 502
+
+	// Edge per-client rate limiting (token buckets, 0 rate = unlimited)
+	TrustedProxies      []string // IPs/CIDRs whose X-Forwarded-For is trusted for the client IP
+	RateLimitRPS        float64  // GET/HEAD requests per second per client
+	RateLimitBurst      int
+	RateLimitWriteRPS   float64 // POST/PUT requests per second per client
+	RateLimitWriteBurst int
+	RateLimitPrefixes   []string // path prefixes limited separately from the rest of the site
+	RateLimitMaxClients int      // buckets kept in memory (least recently used are dropped)
+	RateLimitIPv6Prefix int      // IPv6 clients in the same prefix of this length share buckets
+
+	// Edge IP access control
+	ACLFile           string        // allow/deny rules (unset = no access control)
+	ACLDefaultAllow   bool          // decision for requests no rule matches
+	ACLReloadInterval time.Duration // how often the rules file is checked for changes
+
+	// Edge URL rewrites, redirects and response header rules (unset = none)
+	RewriteFile string
+
+	// Edge TLS termination (HTTPS on EDGE_PORT when a cert directory is set)
+	TLSCertDir        string        // <name>.crt + <name>.key pairs, selected by SNI
+	TLSMinVersion     string        // "1.0" to "1.3"
+	TLSCipherPolicy   string        // "default", "modern" or "compatible"
+	TLSReloadInterval time.Duration // how often the cert directory is checked for changes
+
+	// Edge ↔ origin (mutual) TLS
+	OriginTLS               bool     // origin serves TLS, edges dial it over TLS
+	OriginTLSCA             string   // CA bundle that signs the origin's and edges' certificates
+	OriginTLSCert           string   // origin's server certificate
+	OriginTLSKey            string   // origin's server private key
+	OriginTLSServerName     string   // name edges expect in the origin's certificate
+	OriginRequireClientCert bool     // origin only accepts edges with a certificate signed by the CA
+	OriginAllowedEdges      []string // edge certificate subjects the origin accepts (empty = any)
+	EdgeTLSClientCert       string   // edge's client certificate
+	EdgeTLSClientKey        string   // edge's client private key
+	EdgeAllowedOrigins      []string // origin certificate subjects edges accept (empty = any)
+
+	// Edge authentication for write methods (enabled when any credential source is set)
+	AuthBearerTokens    []string // "<user>:<token>" entries
+	AuthHtpasswdFile    string   // htpasswd file for HTTP Basic
+	AuthHMACKeys        []string // "<key id>:<secret>" entries for signed requests
+	AuthPermissionsFile string   // "<path-prefix> <user>,..." lines (unset = any user, any path)
+
+	// Signed, expiring URLs (edge verifies, CLI generates)
+	SignedURLKeys     []string // "<key id>:<secret>" entries, first one signs
+	SignedURLPrefixes []string // paths that can only be fetched through a signed URL
+
+	CLIHost string // Host header the CLI sends (unset = edge's default virtual host)
+
+	// Credentials the CLI sends with POST/PUT requests
+	CLIAuthToken    string
+	CLIAuthUser     string
+	CLIAuthPassword string
+	CLIHMACKey      string // "<key id>:<secret>"
 )
 
 func init() {
@@ -24,8 +147,11 @@ func init() {
 	wd, _ := os.Getwd()
 	ProjectRoot = findProjectRoot(wd)
 
-	// Load .env
+	// Load .env (tests fall back to the template's defaults, so they run on a fresh checkout)
 	envErr := godotenv.Load(filepath.Join(ProjectRoot, ".env"))
+	if envErr != nil && testing.Testing() {
+		envErr = godotenv.Load(filepath.Join(ProjectRoot, ".env.template"))
+	}
 	if envErr != nil {
 		panic("missing .env file, please create one from .env.template.")
 	}
@@ -43,6 +169,111 @@ func init() {
 	StorageDir = getOptEnvVar("STORAGE_DIR",
 		filepath.Join(ProjectRoot, "internal/storage/files"),
 	)
+	VHostsFile = getOptEnvVar("VHOSTS_FILE", "")
+	VHostDefault = getOptEnvVar("VHOST_DEFAULT", "")
+	CacheMaxFiles = getOptIntEnvVar("CACHE_MAX_FILES", 5)
+	if CacheMaxFiles < 1 {
+		panic(fmt.Sprintf("Invalid value for environment variable CACHE_MAX_FILES: %d (expected at least 1)", CacheMaxFiles))
+	}
+	CacheTTL = getOptDurationEnvVar("CACHE_TTL", 0)
+	CacheDebugHeaders = getOptBoolEnvVar("CACHE_DEBUG_HEADERS", true)
+	AdminHost = getOptEnvVar("ADMIN_HOST", "127.0.0.1")
+	AdminPort = getOptEnvVar("ADMIN_PORT", "")
+	LogLevel = getOptEnvVar("LOG_LEVEL", "info")
+	AccessLogFile = getOptEnvVar("ACCESS_LOG_FILE", "")
+	AccessLogFormat = getOptEnvVar("ACCESS_LOG_FORMAT", "combined")
+	AccessLogMaxSize = int64(getOptIntEnvVar("ACCESS_LOG_MAX_SIZE", 100<<20))
+	AccessLogMaxBackups = getOptIntEnvVar("ACCESS_LOG_MAX_BACKUPS", 5)
+	CaptureFile = getOptEnvVar("CAPTURE_FILE", "")
+	CaptureBodies = getOptBoolEnvVar("CAPTURE_BODIES", false)
+	CaptureMaxSize = int64(getOptIntEnvVar("CAPTURE_MAX_SIZE", 100<<20))
+	CaptureMaxBackups = getOptIntEnvVar("CAPTURE_MAX_BACKUPS", 5)
+	WarmManifest = getOptEnvVar("WARM_MANIFEST", "")
+	WarmHotKeys = getOptIntEnvVar("WARM_HOT_KEYS", 0)
+	WarmHotKeysFile = getOptEnvVar("WARM_HOT_KEYS_FILE", filepath.Join(CacheDir, ".hotkeys"))
+	WarmConcurrency = getOptIntEnvVar("WARM_CONCURRENCY", 4)
+	WarmRate = getOptFloatEnvVar("WARM_RATE", 10)
+	PrefetchLinks = getOptBoolEnvVar("PREFETCH_LINKS", false)
+	PrefetchMaxPerPage = getOptIntEnvVar("PREFETCH_MAX_PER_PAGE", 20)
+	PrefetchConcurrency = getOptIntEnvVar("PREFETCH_CONCURRENCY", 4)
+	Compression = getOptBoolEnvVar("COMPRESSION", true)
+	CompressMinSize = getOptIntEnvVar("COMPRESS_MIN_SIZE", 1024)
+	CompressLevel = getOptIntEnvVar("COMPRESS_LEVEL", 6)
+	if CompressLevel < 1 || CompressLevel > 9 {
+		panic(fmt.Sprintf("Invalid value for environment variable COMPRESS_LEVEL: %d (expected 1 to 9)", CompressLevel))
+	}
+	TraceFile = getOptEnvVar("TRACE_FILE", "")
+	TraceCollector = getOptEnvVar("TRACE_COLLECTOR", "")
+	TraceSampleRatio = getOptFloatEnvVar("TRACE_SAMPLE_RATIO", 1)
+	PurgeEvents = getOptBoolEnvVar("PURGE_EVENTS", true)
+	ShutdownTimeout = getOptDurationEnvVar("SHUTDOWN_TIMEOUT", 10*time.Second)
+
+	MaxConns = getOptIntEnvVar("MAX_CONNS", 1024)
+	HeaderReadTimeout = getOptDurationEnvVar("HEADER_READ_TIMEOUT", 10*time.Second)
+	BodyReadTimeout = getOptDurationEnvVar("BODY_READ_TIMEOUT", 30*time.Second)
+	WriteTimeout = getOptDurationEnvVar("WRITE_TIMEOUT", 60*time.Second)
+	MaxHeaderBytes = getOptIntEnvVar("MAX_HEADER_BYTES", 16<<10)
+	MaxHeaderCount = getOptIntEnvVar("MAX_HEADER_COUNT", 100)
+	MaxBodyBytes = int64(getOptIntEnvVar("MAX_BODY_BYTES", 10<<20))
+	OriginConnectTimeout = getOptDurationEnvVar("ORIGIN_CONNECT_TIMEOUT", 5*time.Second)
+	OriginTimeout = getOptDurationEnvVar("ORIGIN_TIMEOUT", 60*time.Second)
+	OriginMaxBodyBytes = int64(getOptIntEnvVar("ORIGIN_MAX_BODY_BYTES", 100<<20))
+
+	TrustedProxies = getOptListEnvVar("TRUSTED_PROXIES")
+	RateLimitRPS = getOptFloatEnvVar("RATE_LIMIT_RPS", 0)
+	RateLimitBurst = getOptIntEnvVar("RATE_LIMIT_BURST", 20)
+	RateLimitWriteRPS = getOptFloatEnvVar("RATE_LIMIT_WRITE_RPS", 0)
+	RateLimitWriteBurst = getOptIntEnvVar("RATE_LIMIT_WRITE_BURST", 5)
+	RateLimitPrefixes = getOptListEnvVar("RATE_LIMIT_PATH_PREFIXES")
+	RateLimitMaxClients = getOptIntEnvVar("RATE_LIMIT_MAX_CLIENTS", 10000)
+	RateLimitIPv6Prefix = getOptIntEnvVar("RATE_LIMIT_IPV6_PREFIX", 64)
+	if RateLimitIPv6Prefix < 1 || RateLimitIPv6Prefix > 128 {
+		panic(fmt.Sprintf("Invalid value for environment variable RATE_LIMIT_IPV6_PREFIX: %d (expected 1 to 128)", RateLimitIPv6Prefix))
+	}
+
+	ACLFile = getOptEnvVar("ACL_FILE", "")
+	switch aclDefault := getOptEnvVar("ACL_DEFAULT", "allow"); aclDefault {
+	case "allow", "deny":
+		ACLDefaultAllow = aclDefault == "allow"
+	default:
+		panic(fmt.Sprintf("Invalid value for environment variable ACL_DEFAULT: %q (expected allow or deny)", aclDefault))
+	}
+	ACLReloadInterval = getOptDurationEnvVar("ACL_RELOAD_INTERVAL", 5*time.Second)
+
+	RewriteFile = getOptEnvVar("REWRITE_FILE", "")
+
+	TLSCertDir = getOptEnvVar("TLS_CERT_DIR", "")
+	TLSMinVersion = getOptEnvVar("TLS_MIN_VERSION", "1.2")
+	TLSCipherPolicy = getOptEnvVar("TLS_CIPHER_POLICY", "default")
+	TLSReloadInterval = getOptDurationEnvVar("TLS_RELOAD_INTERVAL", 10*time.Second)
+
+	OriginTLS = getOptBoolEnvVar("ORIGIN_TLS", false)
+	OriginTLSCA = getOptEnvVar("ORIGIN_TLS_CA", "")
+	OriginTLSCert = getOptEnvVar("ORIGIN_TLS_CERT", "")
+	OriginTLSKey = getOptEnvVar("ORIGIN_TLS_KEY", "")
+	OriginTLSServerName = getOptEnvVar("ORIGIN_TLS_SERVER_NAME", OriginHost)
+	OriginRequireClientCert = getOptBoolEnvVar("ORIGIN_TLS_REQUIRE_CLIENT_CERT", true)
+	OriginAllowedEdges = getOptListEnvVar("ORIGIN_ALLOWED_EDGES")
+	if len(OriginAllowedEdges) > 0 && !OriginRequireClientCert {
+		panic("Invalid value for environment variable ORIGIN_ALLOWED_EDGES: set while ORIGIN_TLS_REQUIRE_CLIENT_CERT=false (the allowlist checks client certificates)")
+	}
+	EdgeTLSClientCert = getOptEnvVar("EDGE_TLS_CLIENT_CERT", "")
+	EdgeTLSClientKey = getOptEnvVar("EDGE_TLS_CLIENT_KEY", "")
+	EdgeAllowedOrigins = getOptListEnvVar("EDGE_ALLOWED_ORIGINS")
+
+	AuthBearerTokens = getOptListEnvVar("AUTH_BEARER_TOKENS")
+	AuthHtpasswdFile = getOptEnvVar("AUTH_HTPASSWD_FILE", "")
+	AuthHMACKeys = getOptListEnvVar("AUTH_HMAC_KEYS")
+	AuthPermissionsFile = getOptEnvVar("AUTH_PERMISSIONS_FILE", "")
+
+	SignedURLKeys = getOptListEnvVar("SIGNED_URL_KEYS")
+	SignedURLPrefixes = getOptListEnvVar("SIGNED_URL_PREFIXES")
+
+	CLIHost = getOptEnvVar("CLI_HOST", "")
+	CLIAuthToken = getOptEnvVar("CLI_AUTH_TOKEN", "")
+	CLIAuthUser = getOptEnvVar("CLI_AUTH_USER", "")
+	CLIAuthPassword = getOptEnvVar("CLI_AUTH_PASSWORD", "")
+	CLIHMACKey = getOptEnvVar("CLI_HMAC_KEY", "")
 }
 
 func findProjectRoot(start string) string {
@@ -75,3 +306,62 @@ func getOptEnvVar(key, fallback string) string {
 	}
 	return fallback
 }
+
+func getOptBoolEnvVar(key string, fallback bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid boolean for environment variable %s: %q", key, v))
+	}
+	return b
+}
+
+func getOptIntEnvVar(key string, fallback int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid integer for environment variable %s: %q", key, v))
+	}
+	return n
+}
+
+func getOptFloatEnvVar(key string, fallback float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid number for environment variable %s: %q", key, v))
+	}
+	return f
+}
+
+// getOptListEnvVar returns the comma-separated values of the given env variable (nil if unset).
+func getOptListEnvVar(key string) []string {
+	var list []string
+	for _, v := range strings.Split(os.Getenv(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func getOptDurationEnvVar(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		panic(fmt.Sprintf("Invalid duration for environment variable %s: %q", key, v))
+	}
+	return d
+}
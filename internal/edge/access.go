@@ -0,0 +1,42 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/acl"
+	"cdn-edge-server/internal/http"
+	"context"
+	"log/slog"
+)
+
+// AccessRules, if set, decides which client IPs may access which files.
+var AccessRules *acl.Store
+
+// checkAccess evaluates the access rules for each request, logs the decision, and answers
+// denied clients with 403. It runs after the rewrites, as rules scoped to paths apply to the
+// file served.
+func checkAccess(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		if AccessRules == nil {
+			next.ServeEdge(w, req)
+			return
+		}
+
+		ip := clientIP(req.Conn, req.Request)
+		decision := AccessRules.Decide(ip, req.Method, http.ContentPath(req.Path))
+
+		reason := "default"
+		if decision.Rule != nil {
+			reason = decision.Rule.String()
+		}
+		level := slog.LevelDebug
+		if !decision.Allow {
+			level = slog.LevelInfo
+		}
+		slog.Log(context.Background(), level, "ACL decision", "allow", decision.Allow, "ip", ip, "method", req.Method, "path", req.Path, "reason", reason)
+
+		if !decision.Allow {
+			writeError(w, 403)
+			return
+		}
+		next.ServeEdge(w, req)
+	})
+}
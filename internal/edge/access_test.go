@@ -0,0 +1,49 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/acl"
+	"cdn-edge-server/internal/rewrite"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckAccess(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "rules.acl")
+	rules := "allow 10.0.0.0/8 /internal-\ndeny 0.0.0.0/0 /internal-\n"
+	if err := os.WriteFile(file, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store, err := acl.Load(file, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rewrites, err := rewrite.Parse(strings.NewReader("rewrite ^/docs/(.*)$ /internal-$1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(s *acl.Store, r rewrite.Rules) { AccessRules, RewriteRules = s, r }(AccessRules, RewriteRules)
+	AccessRules, RewriteRules = store, rewrites
+
+	tests := []struct {
+		ip, path string
+		status   int
+	}{
+		{"10.0.0.1", "/internal-a.txt", 200},
+		{"8.8.8.8", "/internal-a.txt", 403},
+		{"8.8.8.8", "/index.html", 200},
+
+		// Rules apply to the file served, whatever the path it's requested through
+		{"8.8.8.8", "/other/internal-a.txt", 403},
+		{"8.8.8.8", "/internal-a.txt/", 403},
+		{"8.8.8.8", "/docs/a.txt", 403}, // rewritten to /internal-a.txt
+		{"10.0.0.1", "/docs/a.txt", 200},
+	}
+	for _, tt := range tests {
+		status, _ := serveThrough(t, tt.ip, "GET", tt.path, nil, applyRewrites, checkAccess)
+		if status != tt.status {
+			t.Errorf("GET %s from %s: got %d, want %d", tt.path, tt.ip, status, tt.status)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package edge
+
+import (
+	"bytes"
+	"cdn-edge-server/internal/cache"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/metrics"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// NewAdminHandler returns the handler for the admin port, which serves the edge's metrics
+// at /metrics in the Prometheus text format, and the cache's contents and statistics as JSON:
+//
+//	/cache                             statistics per virtual host, and totals
+//	/cache/entries[?host=<host>]       cached files (of every host by default)
+//	/cache/entry?[host=<host>&]file=<file>  one cached file (of the default host by default)
+//
+// drops cached files on POST /cache/purge?[host=<host>&][file=<file>] (every cached file
+// of the host without a file; of the default host by default), and warms the caches with the
+// manifest posted to /cache/warm[?host=<host>] (see parseManifest; files without a host are
+// for the given one, or the default host), whose progress GET /cache/warm reports.
+func NewAdminHandler() Handler {
+	return HandlerFunc(serveAdmin)
+}
+
+func serveAdmin(w ResponseWriter, req *Request) {
+	var serve func(w ResponseWriter, req *Request)
+	write := false // POST instead of GET/HEAD
+	switch req.Path {
+	case "/metrics":
+		serve = serveMetrics
+	case "/cache":
+		serve = serveCacheStats
+	case "/cache/entries":
+		serve = serveCacheEntries
+	case "/cache/entry":
+		serve = serveCacheEntry
+	case "/cache/purge":
+		serve, write = serveCachePurge, true
+	case "/cache/warm":
+		serve, write = serveCacheWarm, req.Method == "POST" // GET reports progress
+	default:
+		writeError(w, 404)
+		return
+	}
+	if write && req.Method != "POST" || !write && req.Method != "GET" && req.Method != "HEAD" {
+		writeError(w, 405)
+		return
+	}
+	serve(w, req)
+}
+
+func serveMetrics(w ResponseWriter, req *Request) {
+	var body strings.Builder
+	Metrics.WriteText(&body)
+	w.WriteResponse(http.BuildResponse(200, metrics.ContentType, []byte(body.String())))
+}
+
+// cacheStatsJSON is a cache's statistics (or the totals of every cache, without Host and Capacity).
+type cacheStatsJSON struct {
+	Host      string                       `json:"host,omitempty"`
+	Entries   int                          `json:"entries"`
+	Bytes     int64                        `json:"bytes"`
+	Capacity  int                          `json:"capacity,omitempty"`
+	Hits      uint64                       `json:"hits"`
+	Misses    uint64                       `json:"misses"`
+	HitRatio  float64                      `json:"hit_ratio"` // hits / (hits + misses), 0 before any request
+	Evictions map[cache.EvictReason]uint64 `json:"evictions"`
+}
+
+// cacheEntryJSON is a cached file's metadata.
+type cacheEntryJSON struct {
+	Host       string     `json:"host"`
+	File       string     `json:"file"`
+	Size       int64      `json:"size"`
+	Position   int        `json:"position"` // in the FIFO queue, 1 = next to be evicted
+	CachedAt   time.Time  `json:"cached_at"`
+	AgeSeconds float64    `json:"age_seconds"`
+	TTLSeconds *float64   `json:"ttl_seconds"` // time left before the copy expires (null = no TTL)
+	Hits       uint64     `json:"hits"`
+	LastAccess *time.Time `json:"last_access"` // null = no hit since cached
+}
+
+func serveCacheStats(w ResponseWriter, req *Request) {
+	var resp struct {
+		TTLSeconds float64          `json:"ttl_seconds"` // 0 = cached files don't expire
+		Hosts      []cacheStatsJSON `json:"hosts"`
+		Total      cacheStatsJSON   `json:"total"`
+	}
+	resp.TTLSeconds = config.CacheTTL.Seconds()
+	resp.Hosts = []cacheStatsJSON{}
+	resp.Total.Evictions = make(map[cache.EvictReason]uint64)
+
+	for _, vh := range virtualHosts() {
+		stats := vh.Cache.Stats()
+		resp.Hosts = append(resp.Hosts, cacheStatsJSON{
+			Host:      vh.Name,
+			Entries:   stats.Entries,
+			Bytes:     stats.Bytes,
+			Capacity:  stats.Capacity,
+			Hits:      stats.Hits,
+			Misses:    stats.Misses,
+			HitRatio:  hitRatio(stats.Hits, stats.Misses),
+			Evictions: stats.Evictions,
+		})
+
+		resp.Total.Entries += stats.Entries
+		resp.Total.Bytes += stats.Bytes
+		resp.Total.Hits += stats.Hits
+		resp.Total.Misses += stats.Misses
+		for reason, n := range stats.Evictions {
+			resp.Total.Evictions[reason] += n
+		}
+	}
+	resp.Total.HitRatio = hitRatio(resp.Total.Hits, resp.Total.Misses)
+
+	writeJSON(w, resp)
+}
+
+func serveCacheEntries(w ResponseWriter, req *Request) {
+	hosts := virtualHosts()
+	if name := req.Query.Get("host"); name != "" {
+		vh := vhosts.lookup(name)
+		if vh == nil {
+			writeError(w, 404)
+			return
+		}
+		hosts = []*VirtualHost{vh}
+	}
+
+	entries := []cacheEntryJSON{}
+	now := time.Now()
+	for _, vh := range hosts {
+		for _, e := range vh.Cache.Entries() {
+			entries = append(entries, entryJSON(vh, e, now))
+		}
+	}
+	writeJSON(w, map[string]any{"entries": entries})
+}
+
+func serveCacheEntry(w ResponseWriter, req *Request) {
+	file := req.Query.Get("file")
+	if file == "" {
+		writeError(w, 400)
+		return
+	}
+	vh := vhosts.lookup(req.Query.Get("host"))
+	if vh == nil {
+		writeError(w, 404)
+		return
+	}
+	e, ok := vh.Cache.Entry(file)
+	if !ok {
+		writeError(w, 404)
+		return
+	}
+	writeJSON(w, entryJSON(vh, e, time.Now()))
+}
+
+func serveCachePurge(w ResponseWriter, req *Request) {
+	vh := vhosts.lookup(req.Query.Get("host"))
+	if vh == nil {
+		writeError(w, 404)
+		return
+	}
+
+	files := []string{req.Query.Get("file")}
+	if files[0] == "" {
+		files = files[:0]
+		for _, e := range vh.Cache.Entries() {
+			files = append(files, e.Name)
+		}
+	}
+	purged := []string{}
+	for _, file := range files {
+		if vh.Cache.Evict(file, cache.EvictInvalidation) {
+			purged = append(purged, file)
+		}
+	}
+	slog.Info("Admin: purged cached files", "host", vh.Name, "files", len(purged))
+	writeJSON(w, map[string]any{"host": vh.Name, "purged": purged})
+}
+
+func serveCacheWarm(w ResponseWriter, req *Request) {
+	if req.Method != "POST" {
+		writeJSON(w, map[string]any{"jobs": warmJobsStatus()})
+		return
+	}
+
+	vh := vhosts.lookup(req.Query.Get("host"))
+	if vh == nil {
+		writeError(w, 404)
+		return
+	}
+	files, err := parseManifest(bytes.NewReader(req.Body), vh)
+	if err != nil || len(files) == 0 {
+		slog.Warn("Admin: invalid warm-up manifest", "err", err)
+		writeError(w, 400)
+		return
+	}
+
+	// Start nothing if a host is already warming, so the manifest can be posted again once it's done
+	for vh := range files {
+		if warmRunning(vh) {
+			slog.Warn("Admin: cache warm-up already running", "host", vh.Name)
+			writeError(w, 409)
+			return
+		}
+	}
+	jobs := []warmJob{}
+	for _, vh := range virtualHosts() {
+		if len(files[vh]) == 0 {
+			continue
+		}
+		if j, started := startWarm(vh, files[vh], "admin"); started {
+			warmMu.Lock()
+			jobs = append(jobs, *j)
+			warmMu.Unlock()
+		}
+	}
+	writeJSON(w, map[string]any{"jobs": jobs})
+}
+
+func entryJSON(vh *VirtualHost, e cache.EntryInfo, now time.Time) cacheEntryJSON {
+	j := cacheEntryJSON{
+		Host:       vh.Name,
+		File:       e.Name,
+		Size:       e.Size,
+		Position:   e.Position,
+		CachedAt:   e.CachedAt,
+		AgeSeconds: roundSeconds(now.Sub(e.CachedAt)),
+		Hits:       e.Hits,
+	}
+	if config.CacheTTL > 0 {
+		ttl := roundSeconds(max(0, config.CacheTTL-now.Sub(e.CachedAt)))
+		j.TTLSeconds = &ttl
+	}
+	if !e.LastAccess.IsZero() {
+		j.LastAccess = &e.LastAccess
+	}
+	return j
+}
+
+func hitRatio(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
+}
+
+// roundSeconds returns d in seconds, to the millisecond.
+func roundSeconds(d time.Duration) float64 {
+	return d.Round(time.Millisecond).Seconds()
+}
+
+func writeJSON(w ResponseWriter, v any) {
+	body, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		writeError(w, 500)
+		return
+	}
+	w.WriteResponse(http.BuildResponse(200, "application/json", append(body, '\n')))
+}
@@ -0,0 +1,133 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/auth"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// writePolicy authenticates and authorizes POST/PUT requests before they are forwarded to
+// the origin. It is nil (writes are open) when no credential source is configured.
+var writePolicy = loadWritePolicy()
+
+// urlKeys verifies signed URLs for the paths under config.SignedURLPrefixes.
+var urlKeys = loadURLKeys()
+
+// verifySignedURL answers requests for protected content without a valid signed URL with 403.
+// It runs after the rewrites, as whether content is protected depends on the file served, while
+// the signature is checked against the path the client requested (the link's). It removes the
+// signature parameters from every request: they differ per link, so they must never be part of
+// the cache key.
+func verifySignedURL(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		// Protected content is only served (from the cache or the origin) through a valid signed URL
+		if (req.Method == "GET" || req.Method == "HEAD") && requiresSignedURL(http.ContentPath(req.Path)) {
+			if err := urlKeys.Verify(req.OrigPath, req.Query, time.Now()); err != nil {
+				slog.Info("Auth: rejected signed URL", "method", req.Method, "path", req.OrigPath, "file", req.Path, "err", err)
+				writeError(w, 403)
+				return
+			}
+		}
+		req.StripQuery(auth.ParamExpires, auth.ParamKeyID, auth.ParamSignature)
+		next.ServeEdge(w, req)
+	})
+}
+
+// authorizeWrites authenticates and authorizes POST/PUT requests before anything reaches the
+// origin, answering with 401 (with the accepted schemes) or 403. It runs after the rewrites, as
+// permissions depend on the file written, while credentials are checked against the path the
+// client requested (the one it signed).
+func authorizeWrites(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		if writePolicy == nil || (req.Method != "POST" && req.Method != "PUT") {
+			next.ServeEdge(w, req)
+			return
+		}
+
+		user, status := writePolicy.Check(req.Request, req.OrigPath)
+		if status != 0 {
+			slog.Info("Auth: rejected write", "method", req.Method, "path", req.Path, "user", user, "status", status)
+			resp := http.BuildErrorResponse(status)
+			if status == 401 {
+				resp.WithHeader("WWW-Authenticate", writePolicy.Challenges())
+			}
+			w.WriteResponse(resp)
+			return
+		}
+		next.ServeEdge(w, req)
+	})
+}
+
+// loadWritePolicy builds the write policy from the configured credential sources.
+// It panics on invalid configuration, like config does.
+func loadWritePolicy() *auth.Policy {
+	policy := &auth.Policy{}
+
+	if len(config.AuthBearerTokens) > 0 {
+		tokens, err := auth.ParseBearerTokens(config.AuthBearerTokens)
+		if err != nil {
+			panic(err)
+		}
+		policy.Authenticators = append(policy.Authenticators, tokens)
+	}
+	if config.AuthHtpasswdFile != "" {
+		users, err := auth.LoadHtpasswd(config.AuthHtpasswdFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to load htpasswd file: %v", err))
+		}
+		policy.Authenticators = append(policy.Authenticators, users)
+	}
+	if len(config.AuthHMACKeys) > 0 {
+		keys, err := auth.ParseHMACKeys(config.AuthHMACKeys)
+		if err != nil {
+			panic(err)
+		}
+		policy.Authenticators = append(policy.Authenticators, keys)
+	}
+
+	if len(policy.Authenticators) == 0 {
+		return nil
+	}
+
+	if config.AuthPermissionsFile != "" {
+		perms, err := auth.LoadPermissions(config.AuthPermissionsFile)
+		if err != nil {
+			panic(fmt.Sprintf("failed to load write permissions: %v", err))
+		}
+		policy.Permissions = perms
+	}
+	return policy
+}
+
+// loadURLKeys builds the signed URL keys, or returns nil if no protected paths are configured.
+// It panics on invalid configuration, like config does.
+func loadURLKeys() *auth.URLKeys {
+	if len(config.SignedURLPrefixes) == 0 {
+		return nil
+	}
+	for _, prefix := range config.SignedURLPrefixes {
+		if err := http.CheckContentPrefix(prefix); err != nil {
+			panic(fmt.Sprintf("SIGNED_URL_PREFIXES: %v", err))
+		}
+	}
+	keys, err := auth.ParseURLKeys(config.SignedURLKeys)
+	if err != nil {
+		panic(fmt.Sprintf("SIGNED_URL_PREFIXES requires SIGNED_URL_KEYS: %v", err))
+	}
+	return keys
+}
+
+// requiresSignedURL reports whether the file at the given content path can only be fetched
+// through a signed URL.
+func requiresSignedURL(path string) bool {
+	for _, prefix := range config.SignedURLPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/auth"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/rewrite"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifySignedURL(t *testing.T) {
+	keys, err := auth.ParseURLKeys([]string{"k1:secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := rewrite.Parse(strings.NewReader("rewrite ^/free/(.*)$ /secret-$1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(prefixes []string, k *auth.URLKeys, r rewrite.Rules) {
+		config.SignedURLPrefixes, urlKeys, RewriteRules = prefixes, k, r
+	}(config.SignedURLPrefixes, urlKeys, RewriteRules)
+	config.SignedURLPrefixes, urlKeys, RewriteRules = []string{"/secret-"}, keys, rules
+
+	signed := func(path string) string {
+		return path + "?" + keys.Sign(path, time.Now().Add(time.Hour))
+	}
+	expired := "/secret-a.txt?" + keys.Sign("/secret-a.txt", time.Now().Add(-time.Minute))
+
+	tests := []struct {
+		method, target string
+		status         int
+		served         string
+	}{
+		{"GET", "/secret-a.txt", 403, ""},
+		{"GET", signed("/secret-a.txt"), 200, "/secret-a.txt"},
+		{"HEAD", "/secret-a.txt", 403, ""},
+		{"GET", expired, 403, ""},
+		{"GET", "/secret-b.txt?" + keys.Sign("/secret-a.txt", time.Now().Add(time.Hour)), 403, ""},
+		{"GET", "/public.txt", 200, "/public.txt"},
+		{"POST", "/secret-a.txt", 200, "/secret-a.txt"}, // writes are up to authorizeWrites
+
+		// Files are cached by name: any directory serves the same protected file
+		{"GET", "/other/secret-a.txt", 403, ""},
+		{"GET", "/a/b/secret-a.txt", 403, ""},
+		{"GET", signed("/other/secret-a.txt"), 200, "/other/secret-a.txt"},
+		{"GET", "/secret-a.txt/", 403, ""},
+		{"GET", "/SECRET-a.txt", 200, "/SECRET-a.txt"}, // another file
+
+		// A rewrite into protected content needs a URL signed for the path requested
+		{"GET", "/free/a.txt", 403, ""},
+		{"GET", signed("/free/a.txt"), 200, "/secret-a.txt"},
+		{"GET", signed("/secret-a.txt") + "&x=1", 200, "/secret-a.txt"},
+	}
+	for _, tt := range tests {
+		status, served := serveThrough(t, "127.0.0.1", tt.method, tt.target, nil, applyRewrites, verifySignedURL)
+		if status != tt.status || served != tt.served {
+			t.Errorf("%s %s: got %d serving %q, want %d serving %q", tt.method, tt.target, status, served, tt.status, tt.served)
+		}
+	}
+}
+
+func TestAuthorizeWrites(t *testing.T) {
+	tokens, err := auth.ParseBearerTokens([]string{"bob:b-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.ParseHMACKeys([]string{"ci:secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := rewrite.Parse(strings.NewReader("rewrite ^/upload/(.*)$ /site-$1\nrewrite ^/drop/(.*)$ /public-$1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(p *auth.Policy, r rewrite.Rules) { writePolicy, RewriteRules = p, r }(writePolicy, RewriteRules)
+	writePolicy = &auth.Policy{
+		Authenticators: []auth.Authenticator{tokens, keys},
+		Permissions:    auth.Permissions{"/public-": {"*"}},
+	}
+	RewriteRules = rules
+
+	bob := map[string]string{"Authorization": "Bearer b-token"}
+	signed := func(method, path string) map[string]string {
+		date, authorization := auth.SignHMAC("ci", "secret", method, path, nil, time.Now())
+		return map[string]string{"X-Auth-Date": date, "Authorization": authorization}
+	}
+	tests := []struct {
+		method, path string
+		headers      map[string]string
+		status       int
+	}{
+		{"PUT", "/public-notes.txt", bob, 200},
+		{"PUT", "/public-notes.txt", nil, 401},
+		{"POST", "/index.html", bob, 403},
+		{"GET", "/index.html", nil, 200},
+
+		// Writes are authorized for the file written, whatever the path it's written through
+		{"PUT", "/public/index.html", bob, 403},
+		{"PUT", "/upload/public-notes.txt", bob, 403}, // rewritten to /site-public-notes.txt
+		{"PUT", "/other/public-notes.txt", bob, 200},
+
+		// Signatures cover the path the client sent, not the rewritten one
+		{"PUT", "/public-notes.txt", signed("PUT", "/public-notes.txt"), 200},
+		{"PUT", "/drop/notes.txt", signed("PUT", "/drop/notes.txt"), 200}, // rewritten to /public-notes.txt
+		{"PUT", "/drop/notes.txt", signed("PUT", "/public-notes.txt"), 401},
+		{"POST", "/drop/notes.txt", signed("PUT", "/drop/notes.txt"), 401},
+
+		// Header names are case-insensitive
+		{"PUT", "/public-notes.txt", map[string]string{"authorization": "Bearer b-token"}, 200},
+		{"PUT", "/public-notes.txt", lower(signed("PUT", "/public-notes.txt")), 200},
+	}
+	for _, tt := range tests {
+		status, _ := serveThrough(t, "127.0.0.1", tt.method, tt.path, tt.headers, applyRewrites, authorizeWrites)
+		if status != tt.status {
+			t.Errorf("%s %s: got %d, want %d", tt.method, tt.path, status, tt.status)
+		}
+	}
+}
+
+// lower returns the given headers with lowercase names.
+func lower(headers map[string]string) map[string]string {
+	lowered := make(map[string]string, len(headers))
+	for name, value := range headers {
+		lowered[strings.ToLower(name)] = value
+	}
+	return lowered
+}
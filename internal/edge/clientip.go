@@ -0,0 +1,58 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/acl"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// trustedProxies are the proxies (config.TrustedProxies) allowed to report the client's IP
+// through X-Forwarded-For.
+var trustedProxies = parsePrefixes(config.TrustedProxies)
+
+// clientIP returns the IP address of the client that sent the given request. That is the
+// connection's remote address, unless it is a trusted proxy, in which case X-Forwarded-For is
+// walked from the right (nearest hop) to the first address that isn't a trusted proxy.
+func clientIP(conn net.Conn, req *http.Request) netip.Addr {
+	addrPort, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+	if err != nil {
+		return netip.Addr{}
+	}
+	ip := addrPort.Addr().Unmap()
+
+	hops := strings.Split(req.Header("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(ip); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break // malformed entry, can't trust anything further left
+		}
+		ip = hop.Unmap()
+	}
+	return ip
+}
+
+func isTrustedProxy(ip netip.Addr) bool {
+	for _, p := range trustedProxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrefixes parses IP addresses and CIDR ranges (e.g. "10.0.0.1", "10.0.0.0/8", "::1").
+// It panics on malformed entries, as they come from configuration.
+func parsePrefixes(entries []string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, e := range entries {
+		p, err := acl.ParsePrefix(e)
+		if err != nil {
+			panic(err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	return prefixes
+}
@@ -0,0 +1,34 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/http"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(prev []netip.Prefix) { trustedProxies = prev }(trustedProxies)
+	trustedProxies = parsePrefixes([]string{"10.0.0.0/8"})
+
+	tests := []struct {
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{"203.0.113.7", nil, "203.0.113.7"},
+		{"203.0.113.7", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7"}, // untrusted
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"10.0.0.1", map[string]string{"x-forwarded-for": "198.51.100.1"}, "198.51.100.1"}, // header names are case-insensitive
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "192.0.2.9, 198.51.100.1, 10.0.0.2"}, "198.51.100.1"},
+		{"10.0.0.1", map[string]string{"X-Forwarded-For": "198.51.100.1, garbage"}, "10.0.0.1"},
+		{"::ffff:10.0.0.1", map[string]string{"X-Forwarded-For": "2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		conn := &addrConn{remote: &net.TCPAddr{IP: net.ParseIP(tt.remote), Port: 40000}}
+		if got := clientIP(conn, &http.Request{Headers: tt.headers}).String(); got != tt.want {
+			t.Errorf("from %s with %v: got %s, want %s", tt.remote, tt.headers, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package edge
+
+import (
+	"bytes"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// encodings are the content codings the edge compresses with, preferred first.
+var encodings = []string{"gzip", "deflate"}
+
+// compressibleTypes are the MIME types worth compressing besides text/* and the +json and
+// +xml structured syntaxes (images other than SVG, audio, video and archives are compressed
+// already).
+var compressibleTypes = map[string]bool{
+	"application/javascript": true,
+	"application/json":       true,
+	"application/xml":        true,
+	"image/svg+xml":          true,
+}
+
+// compressible reports whether content of the given MIME type shrinks when compressed.
+func compressible(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return strings.HasPrefix(mediaType, "text/") || compressibleTypes[mediaType] ||
+		strings.HasSuffix(mediaType, "+json") || strings.HasSuffix(mediaType, "+xml")
+}
+
+// negotiateEncoding returns the coding the given Accept-Encoding value prefers among encodings
+// (by q-value, then in the edge's order), or "" if it accepts none of them.
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(part, ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		q := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			var err error
+			if q, err = strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+				q = 0
+			}
+		}
+		qualities[coding] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range encodings {
+		q, ok := qualities[coding]
+		if !ok {
+			q = qualities["*"] // 0 if absent
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// encode compresses data with the given coding, at config.CompressLevel.
+func encode(data []byte, coding string) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	switch coding {
+	case "gzip":
+		w, err = gzip.NewWriterLevel(&buf, config.CompressLevel)
+	case "deflate":
+		w, err = zlib.NewWriterLevel(&buf, config.CompressLevel) // HTTP's deflate is the zlib format
+	default:
+		err = fmt.Errorf("unsupported coding %q", coding)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// compressResponse compresses the 200 response to a GET or HEAD of the given cached file, whose
+// contents are identity, with the coding the client prefers, using the variant cached next to the
+// file (encoded and cached first if needed). Files of compressible types get Vary: Accept-Encoding
+// whether or not they are compressed; files under config.CompressMinSize are sent as is.
+func compressResponse(resp *http.Response, req *Request, filename string, identity []byte) {
+	if !config.Compression || !compressible(getMimeType(filename)) {
+		return
+	}
+	addVary(resp, "Accept-Encoding")
+	if resp.Headers["Content-Encoding"] != "" || len(identity) < config.CompressMinSize {
+		return
+	}
+	coding := negotiateEncoding(req.Header("Accept-Encoding"))
+	if coding == "" {
+		return
+	}
+
+	vh := req.VHost
+	body, err := vh.Cache.GetVariant(filename, coding)
+	if err != nil {
+		if body, err = encode(identity, coding); err != nil {
+			slog.Debug("Compression failed", "file", filename, "encoding", coding, "err", err)
+			return
+		}
+		if len(body) >= len(identity) {
+			return // incompressible after all
+		}
+		if err := vh.Cache.AddVariant(filename, coding, body); err != nil {
+			slog.Debug("Caching compressed variant failed", "host", vh.Name, "file", filename, "encoding", coding, "err", err)
+		}
+	}
+
+	resp.Body = body
+	resp.Headers["Content-Length"] = fmt.Sprint(len(body))
+	resp.Headers["Content-Encoding"] = coding
+	if etag := resp.Headers["ETag"]; strings.HasSuffix(etag, `"`) {
+		// A different representation needs its own entity tag
+		resp.Headers["ETag"] = strings.TrimSuffix(etag, `"`) + "-" + coding + `"`
+	}
+	compressedTotal.Inc(coding)
+}
+
+// addVary adds the given request header to the response's Vary header, if not listed already.
+func addVary(resp *http.Response, header string) {
+	vary := resp.Headers["Vary"]
+	for _, h := range strings.Split(vary, ",") {
+		if strings.EqualFold(strings.TrimSpace(h), header) {
+			return
+		}
+	}
+	if vary != "" {
+		vary += ", "
+	}
+	resp.Headers["Vary"] = vary + header
+}
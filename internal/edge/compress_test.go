@@ -0,0 +1,119 @@
+package edge
+
+import (
+	"bytes"
+	"cdn-edge-server/internal/cache"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"compress/gzip"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct{ accept, want string }{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"br", ""},
+		{"deflate, gzip", "gzip"}, // equal q-values: the edge's order
+		{"GZIP", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"gzip; q=0.8, deflate;q=0.9", "deflate"},
+		{"gzip;q=0, deflate;q=0", ""},
+		{"gzip;q=abc", ""}, // invalid q-value: not acceptable
+		{"*", "gzip"},
+		{"*;q=0.5, gzip;q=0.2", "deflate"},
+		{"*;q=0, deflate", "deflate"},
+		{"identity;q=0", ""}, // refuses identity, but accepts nothing the edge has either
+		{"identity;q=0, deflate;q=0.1", "deflate"},
+		{" , gzip ,", "gzip"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.accept); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	defer func(minSize int) { config.CompressMinSize = minSize }(config.CompressMinSize)
+	config.CompressMinSize = 100
+
+	c, err := cache.New("", t.TempDir(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vh := &VirtualHost{Name: "www.test", Cache: c}
+	text := []byte(strings.Repeat("compressible text ", 100))
+	random := make([]byte, 2000)
+	rand.Read(random)
+	for name, data := range map[string][]byte{"a.txt": text, "small.txt": text[:50], "random.txt": random, "a.png": text} {
+		if err := c.Add(name, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compress := func(filename, acceptEncoding string) *http.Response {
+		data, err := c.Get(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp := http.BuildResponse(200, getMimeType(filename), data)
+		resp.Headers["ETag"] = `"v1"`
+		req := &Request{Request: &http.Request{Method: "GET", Path: "/" + filename, Headers: map[string]string{"Accept-Encoding": acceptEncoding}}, VHost: vh}
+		compressResponse(resp, req, filename, data)
+		return resp
+	}
+
+	tests := []struct {
+		file, accept   string
+		encoding, vary string
+		cached         bool // gzip variant
+	}{
+		{"a.txt", "gzip", "gzip", "Accept-Encoding", true},
+		{"a.txt", "identity", "", "Accept-Encoding", true},   // variant cached above
+		{"small.txt", "gzip", "", "Accept-Encoding", false},  // under the minimum size
+		{"random.txt", "gzip", "", "Accept-Encoding", false}, // no smaller once compressed
+		{"a.png", "gzip", "", "", false},                     // not a compressible type
+	}
+	for _, tt := range tests {
+		resp := compress(tt.file, tt.accept)
+		if got := resp.Headers["Content-Encoding"]; got != tt.encoding {
+			t.Errorf("%s, Accept-Encoding %q: Content-Encoding = %q, want %q", tt.file, tt.accept, got, tt.encoding)
+		}
+		if got := resp.Headers["Vary"]; got != tt.vary {
+			t.Errorf("%s, Accept-Encoding %q: Vary = %q, want %q", tt.file, tt.accept, got, tt.vary)
+		}
+		if _, err := c.GetVariant(tt.file, "gzip"); (err == nil) != tt.cached {
+			t.Errorf("%s, Accept-Encoding %q: variant cached = %t, want %t", tt.file, tt.accept, err == nil, tt.cached)
+		}
+	}
+
+	// The compressed response decodes to the file, under its own entity tag
+	resp := compress("a.txt", "gzip")
+	if etag := resp.Headers["ETag"]; etag != `"v1-gzip"` {
+		t.Errorf("ETag = %s, want \"v1-gzip\"", etag)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(resp.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, err := io.ReadAll(r); err != nil || !bytes.Equal(body, text) {
+		t.Errorf("decoded body = %d bytes, %v, want the %d bytes of a.txt", len(body), err, len(text))
+	}
+	if got, want := resp.Headers["Content-Length"], fmt.Sprint(len(resp.Body)); got != want {
+		t.Errorf("Content-Length = %s, want %s", got, want)
+	}
+
+	// Cached variants are served as they are
+	if err := c.AddVariant("a.txt", "deflate", []byte("cached")); err != nil {
+		t.Fatal(err)
+	}
+	if body := compress("a.txt", "deflate").Body; string(body) != "cached" {
+		t.Errorf("deflate body = %q, want the cached variant", body)
+	}
+}
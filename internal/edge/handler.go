@@ -6,179 +6,290 @@ import (
 	"cdn-edge-server/internal/cache"
 	"cdn-edge-server/internal/config"
 	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/logging"
+	"cdn-edge-server/internal/tracing"
+	"crypto/tls"
 	"fmt"
 	"mime"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-func HandleClient(conn net.Conn) {
-	defer conn.Close()
-
-	reader := bufio.NewReader(conn)
-
-	// Parse client request
-	req, err := http.ParseReq(reader)
-
-	if err != nil || req == nil {
-		if err != nil && err.Error() == "EOF" {
-			// Health check, silently ignore
-			return
-		}
-		resp := http.BuildErrorResponse(400)
-		conn.Write([]byte(resp.HeadString()))
-		conn.Write(resp.Body)
-		return
-	}
-
-	handleRequest(conn, req)
-}
-
-func handleRequest(conn net.Conn, req *http.Request) {
-	switch req.Method {
-	case "GET":
-		handleGET(conn, req.Path)
-	case "HEAD":
-		handleHEAD(conn, req.Path)
-	case "POST", "PUT":
-		handleWriteReq(conn, req)
-	default:
-		// Unsupported method
-		resp := http.BuildErrorResponse(405)
-		conn.Write([]byte(resp.HeadString()))
-		conn.Write(resp.Body)
-	}
+// NewHandler returns the edge's request handler: requests go through each middleware in
+// order, then are served from the cache or, failing that, the origin.
+func NewHandler() Handler {
+	return Chain(HandlerFunc(serveFromOrigin),
+		identifyRequest, // X-Request-ID and Via
+		logAccess,
+		captureTraffic, // request/response records for replay
+		recordMetrics,
+		rateLimit,        // 429 for clients over their rate limit
+		routeVirtualHost, // 404 for unknown hosts
+		applyRewrites,    // path rewrites (before the cache lookup), redirects and header rules
+		checkAccess,      // 403 for clients the access rules deny (for the file served)
+		verifySignedURL,  // 403 for protected content without a valid signed URL (for the file served)
+		cacheHeaders,     // X-Cache, Age, Server-Timing and debug headers
+		authorizeWrites,  // 401/403 for POST/PUT without write permission
+		serveFromCache,   // GET/HEAD cache hits, caching and invalidation
+	)
 }
 
-// handleGet serves an HTTP GET request for the given path using the specified connection.
-func handleGET(conn net.Conn, path string) {
-	filename := filepath.Base(path)
-
-	// Determine MIME (content-type header value)
-	mimeType := getMimeType(filename)
-
-	if cache.Has(filename) {
-		// Cache hit
-		dat, err := cache.Get(filename)
-		if err != nil {
-			// Edge server error (failed to load cache file)
-			resp := http.BuildErrorResponse(500)
-			conn.Write([]byte(resp.HeadString()))
-			conn.Write(resp.Body)
-			return
-		}
+// serveFromCache serves GET and HEAD requests for cached files, caches files fetched for
+// GET cache misses, and invalidates files written through POST/PUT. Files cached longer than
+// config.CacheTTL are fetched again, but still served if the origin fails.
+func serveFromCache(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		vh := req.VHost
+		filename := filepath.Base(req.Path) // filename w/o path for local cache storage/lookup
 
-		resp := http.BuildResponse(200, mimeType, dat)
-		conn.Write([]byte(resp.HeadString()))
-		conn.Write(resp.Body)
-		return
-	}
+		req.CacheStatus = logging.CacheMiss
+		switch req.Method {
+		case "GET":
+			lookupStart := time.Now()
+			lookup := req.Span.StartChild("cache lookup", tracing.Internal)
+			info, cached := statCached(vh, filename)
+			var dat []byte
+			hit := false
+			if cached && isFresh(info) {
+				var err error
+				if dat, err = vh.Cache.Get(filename); err == nil {
+					hit = true
+				} else {
+					// Unreadable copy, fetch the file again
+					vh.Cache.Evict(filename, cache.EvictCorruption)
+					cached = false
+				}
+			}
+			lookup.SetAttr("edge.cache_hit", hit)
+			lookup.End()
+			req.CacheTime = time.Since(lookupStart)
 
-	// Cache miss, fetch from origin
-	originResp, err := fetchFromOrigin("GET", filename, nil)
-	if err != nil {
-		resp := http.BuildErrorResponse(502)
-		conn.Write([]byte(resp.HeadString()))
-		conn.Write(resp.Body)
-		return
-	}
+			if hit {
+				// Cache hit
+				req.CacheStatus = logging.CacheHit
+				req.CachedAt = info.ModTime()
+				vh.Cache.RecordHit(filename)
+				vh.hot.record(filename)
+				resp := http.BuildResponse(200, getMimeType(filename), dat)
+				compressResponse(resp, req, filename, dat)
+				w.WriteResponse(resp)
+				return
+			}
+			if cached {
+				req.CacheStatus = logging.CacheExpired
+			}
 
-	// Cache file
-	if originResp.Status == 200 {
-		cache.Add(filename, originResp.Body)
-	}
-
-	// Forward origin server response to client
-	conn.Write([]byte(originResp.HeadString()))
-	conn.Write(originResp.Body)
-}
+			// Cache miss (or expired copy), fetch from origin and cache the file before forwarding it,
+			// unless it was purged meanwhile (the copy may predate the change)
+			gen := vh.Cache.Generation(filename)
+			next.ServeEdge(&hookWriter{ResponseWriter: w, hook: func(resp *http.Response) {
+				switch {
+				case resp.Status == 200:
+					vh.hot.record(filename)
+					store := req.Span.StartChild("cache store", tracing.Internal)
+					if cached {
+						vh.Cache.Evict(filename, cache.EvictTTL) // replaced by the fresh copy
+					}
+					store.SetError(vh.Cache.AddIfCurrent(filename, resp.Body, gen))
+					store.End()
+					if config.PrefetchLinks && strings.HasPrefix(getMimeType(filename), "text/html") {
+						host := req.Header("Host")
+						if host == "" {
+							host = vh.Name
+						}
+						go prefetchLinks(vh, host, req.Path, resp.Body)
+					}
+					compressResponse(resp, req, filename, resp.Body)
+				case cached && resp.Status == 404:
+					// Gone from the origin since it was cached
+					vh.Cache.Evict(filename, cache.EvictTTL)
+				case cached && resp.Status >= 500:
+					// Serve the expired copy rather than the origin's error
+					if dat, err := vh.Cache.Get(filename); err == nil {
+						*resp = *http.BuildResponse(200, getMimeType(filename), dat)
+						compressResponse(resp, req, filename, dat)
+						req.CacheStatus = logging.CacheStale
+						req.CachedAt = info.ModTime()
+					}
+				}
+			}}, req)
 
-// handleHead processes an HTTP HEAD request for the given path using the given connection.
-func handleHEAD(conn net.Conn, path string) {
-	filename := filepath.Base(path) // filename w/o path for local cache storage/lookup
-	cachePath := filepath.Join(config.CacheDir, filename)
+			if req.CacheStatus == logging.CacheStale {
+				vh.Cache.RecordHit(filename)
+				vh.hot.record(filename)
+			} else {
+				vh.Cache.RecordMiss()
+			}
 
-	// Determine MIME (content-type header value)
-	mimeType := getMimeType(filename)
+		case "HEAD":
+			// Cache hit (HEAD only checks file existence, does not read body)
+			lookupStart := time.Now()
+			lookup := req.Span.StartChild("cache lookup", tracing.Internal)
+			info, cached := statCached(vh, filename)
+			hit := cached && isFresh(info)
+			lookup.SetAttr("edge.cache_hit", hit)
+			lookup.End()
+			req.CacheTime = time.Since(lookupStart)
+			if hit {
+				req.CacheStatus = logging.CacheHit
+				req.CachedAt = info.ModTime()
+				vh.Cache.RecordHit(filename)
+				resp := http.BuildResponse(200, getMimeType(filename), nil).WithHeader("Content-Length", fmt.Sprint(info.Size()))
+				if config.Compression && compressible(getMimeType(filename)) {
+					// Same headers as a GET, which needs the contents
+					if dat, err := vh.Cache.Get(filename); err == nil {
+						compressResponse(resp, req, filename, dat)
+					}
+				}
+				w.WriteResponse(resp)
+				return
+			}
+			if cached {
+				req.CacheStatus = logging.CacheExpired
+			}
+			vh.Cache.RecordMiss()
+			next.ServeEdge(w, req)
 
-	// Cache hit (HEAD only checks file existence, does not read body)
-	info, err := os.Stat(cachePath)
-	if err == nil {
-		// Build and send HEAD resp
-		resp := http.BuildResponse(200, mimeType, nil).WithHeader("Content-Length", fmt.Sprint(info.Size()))
+		case "POST", "PUT":
+			// Remove file from cache if write to origin succeeded
+			req.CacheStatus = logging.CacheBypass
+			next.ServeEdge(&hookWriter{ResponseWriter: w, hook: func(resp *http.Response) {
+				if resp.Status == 200 {
+					vh.Cache.Remove(filename)
+				}
+			}}, req)
 
-		conn.Write([]byte(resp.HeadString()))
-		return
-	}
+		default:
+			req.CacheStatus = logging.CacheBypass
+			next.ServeEdge(w, req)
+		}
+	})
+}
 
-	// Cache miss, forward HEAD request to origin
-	originResp, err := fetchFromOrigin("HEAD", filename, nil)
+// statCached returns the file info of the given cached file, or false if it isn't cached.
+// A cached file whose file can no longer be read is evicted.
+func statCached(vh *VirtualHost, filename string) (os.FileInfo, bool) {
+	info, err := vh.Cache.Stat(filename)
 	if err != nil {
-		resp := http.BuildErrorResponse(502)
-		conn.Write([]byte(resp.HeadString()))
-		conn.Write(resp.Body)
-		return
+		if vh.Cache.Has(filename) {
+			vh.Cache.Evict(filename, cache.EvictCorruption)
+		}
+		return nil, false
 	}
-
-	// Forward origin server response to client (headers only)
-	conn.Write([]byte(originResp.HeadString()))
+	return info, true
 }
 
-// handleWriteReq proccesses a POST or PUT request for the given file path using the given connection
-// by forwarding them to the origin server.
-func handleWriteReq(conn net.Conn, req *http.Request) {
-	filename := filepath.Base(req.Path) // filename w/o path for local cache storage/lookup
+// isFresh reports whether the cached file with the given info is within config.CacheTTL.
+// Cached files are written when fetched, so their modification time is when they were cached.
+func isFresh(info os.FileInfo) bool {
+	return config.CacheTTL == 0 || time.Since(info.ModTime()) < config.CacheTTL
+}
 
-	// For POST/PUT requests, forward request to origin server
-	originResp, err := fetchFromOrigin(req.Method, filename, req.Body)
-	if err != nil {
-		resp := http.BuildErrorResponse(502)
-		conn.Write([]byte(resp.HeadString()))
-		conn.Write(resp.Body)
+// serveFromOrigin forwards the request to the virtual host's origin server and the origin's
+// response to the client.
+func serveFromOrigin(w ResponseWriter, req *Request) {
+	var body []byte
+	switch req.Method {
+	case "GET", "HEAD":
+	case "POST", "PUT":
+		body = req.Body
+	default:
+		// Unsupported method
+		writeError(w, 405)
 		return
 	}
 
-	// Remove file from cache (for PUT requests) if write to origin succeeded
-	if originResp.Status == 200 {
-		cache.Remove(filename)
+	start := time.Now()
+	originResp, err := fetchFromOrigin(req.Span, req.VHost, req.Method, filepath.Base(req.Path), body)
+	req.UpstreamTime = time.Since(start)
+	observeOriginFetch(req.Method, req.UpstreamTime, err)
+	if err != nil {
+		writeError(w, 502)
+		return
 	}
-
-	// Forward origin response to client
-	conn.Write([]byte(originResp.HeadString()))
-	conn.Write(originResp.Body)
+	w.WriteResponse(originResp)
 }
 
-// fetchFromOrigin forwards the client's HTTP request with the given method and filename to the origin server,
-// and fetches and returns the origin server's response.
-func fetchFromOrigin(method, filename string, body []byte) (*http.Response, error) {
-	connOrigin, err := net.Dial("tcp", config.OriginHost+":"+config.OriginPort)
+// fetchFromOrigin forwards the client's HTTP request with the given method and filename to the virtual host's
+// origin server, and fetches and returns the origin server's response. The connection and the exchange are
+// recorded as children of the given span (nil = not part of a trace), and the origin continues the trace.
+func fetchFromOrigin(span *tracing.Span, vh *VirtualHost, method, filename string, body []byte) (*http.Response, error) {
+	connect := span.StartChild("upstream connect", tracing.Internal)
+	connect.SetAttr("server.address", vh.Origin)
+	connOrigin, err := dialOrigin(vh.Origin)
+	connect.SetError(err)
+	connect.End()
 	if err != nil {
 		return nil, err
 	}
 	defer connOrigin.Close()
+	connOrigin.SetDeadline(deadline(config.OriginTimeout))
+
+	exchange := span.StartChild("upstream response", tracing.Client)
+	defer exchange.End()
+	exchange.SetAttr("http.request.method", method)
+	exchange.SetAttr("server.address", vh.Origin)
 
 	reqStr := fmt.Sprintf(
-		"%s /%s HTTP/1.0\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n",
-		method, filename, len(body),
+		"%s /%s HTTP/1.0\r\nHost: %s\r\nContent-Length: %d\r\n",
+		method, filename, vh.Name, len(body),
 	)
-	connOrigin.Write([]byte(reqStr))
+	if tp := exchange.Context().Traceparent(); tp != "" {
+		reqStr += "traceparent: " + tp + "\r\n"
+	}
+	connOrigin.Write([]byte(reqStr + "\r\n"))
 
 	if len(body) > 0 {
 		connOrigin.Write(body)
 	}
 
 	reader := bufio.NewReader(connOrigin)
-	resp, err := http.ParseResp(reader)
+	resp, err := http.ParseRespLimits(reader, originLimits)
 	if resp == nil || (err != nil && method != "HEAD" && err.Error() != "EOF") {
 		// EOF errors are ignored for HEAD requests (unless the origin server returns a nil resp)
+		exchange.SetError(err)
 		return nil, err
 	}
+	exchange.SetAttr("http.response.status_code", resp.Status)
 
 	return resp, nil
 }
 
+// originLimits bounds the origin responses the edge reads.
+var originLimits = http.Limits{
+	MaxHeaderBytes: http.DefaultLimits.MaxHeaderBytes,
+	MaxHeaderCount: http.DefaultLimits.MaxHeaderCount,
+	MaxBodyBytes:   config.OriginMaxBodyBytes,
+}
+
+// OriginTLS, if set, is used to dial the origin server over (mutual) TLS.
+var OriginTLS *tls.Config
+
+// dialOrigin opens a connection to the origin server at the given address, over TLS if OriginTLS is set,
+// giving up after config.OriginConnectTimeout (including the TLS handshake).
+// Origins other than config's are expected to present a certificate for their own hostname.
+func dialOrigin(addr string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: config.OriginConnectTimeout}
+	if OriginTLS == nil {
+		return dialer.Dial("tcp", addr)
+	}
+
+	tlsConfig := OriginTLS
+	if host, port, _ := net.SplitHostPort(addr); host != config.OriginHost || port != config.OriginPort {
+		tlsConfig = OriginTLS.Clone()
+		tlsConfig.ServerName = host
+	}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("origin TLS: %w", err)
+	}
+	return conn, nil
+}
+
 // getMimeType returns the MIME tyope of the given file's name via its extension.
 // (Default: arbitrary binary data)
 func getMimeType(filename string) string {
@@ -0,0 +1,86 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeOrigin accepts connections and answers each with respond, returning its address.
+func fakeOrigin(t *testing.T, respond func(net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				respond(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestFetchFromOriginLimits(t *testing.T) {
+	defer func(timeout time.Duration, limits http.Limits) {
+		config.OriginTimeout, originLimits = timeout, limits
+	}(config.OriginTimeout, originLimits)
+	config.OriginTimeout = 200 * time.Millisecond
+	originLimits.MaxBodyBytes = 10
+
+	stalled := fakeOrigin(t, func(conn net.Conn) {
+		time.Sleep(2 * time.Second) // never answers in time
+	})
+	dribbling := fakeOrigin(t, func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Length: 10\r\n\r\n"))
+		for range 20 {
+			time.Sleep(50 * time.Millisecond)
+			conn.Write([]byte("a"))
+		}
+	})
+	huge := fakeOrigin(t, func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Length: 11\r\n\r\n0123456789a"))
+	})
+	small := fakeOrigin(t, func(conn net.Conn) {
+		conn.Write([]byte("HTTP/1.0 200 OK\r\nContent-Length: 10\r\n\r\n0123456789"))
+	})
+
+	tests := []struct {
+		name, origin, method string
+		timeout              bool
+		err                  error
+	}{
+		{"stalled origin", stalled, "GET", true, nil},
+		{"dribbling origin", dribbling, "GET", true, nil},
+		{"response over the limit", huge, "GET", false, http.ErrResponseTooLarge},
+		{"HEAD of a file over the limit", huge, "HEAD", false, nil},
+		{"response within the limit", small, "GET", false, nil},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		resp, err := fetchFromOrigin(nil, &VirtualHost{Name: "www.test", Origin: tt.origin}, tt.method, "a.txt", nil)
+		elapsed := time.Since(start)
+		switch {
+		case tt.timeout:
+			if !errors.Is(err, os.ErrDeadlineExceeded) || elapsed > time.Second {
+				t.Errorf("%s: got %v after %v, want a timeout after %v", tt.name, err, elapsed, config.OriginTimeout)
+			}
+		case !errors.Is(err, tt.err):
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+		case err == nil && resp.Status != 200:
+			t.Errorf("%s: got status %d, want 200", tt.name, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/logging"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// viaName is how the edge names itself in Via headers.
+const viaName = "cdn-edge"
+
+// identifyRequest gives each request an ID (the client's X-Request-ID if it sent a usable one)
+// and adds X-Request-ID and Via headers to every response.
+func identifyRequest(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		req.ID = req.Header("X-Request-ID")
+		if !validRequestID(req.ID) {
+			req.ID = newRequestID()
+		}
+		req.Span.SetAttr("edge.request_id", req.ID)
+
+		next.ServeEdge(&hookWriter{ResponseWriter: w, hook: func(resp *http.Response) {
+			resp.Headers["X-Request-ID"] = req.ID
+			via := "1.0 " + viaName
+			if prev := resp.Headers["Via"]; prev != "" {
+				via = prev + ", " + via
+			}
+			resp.Headers["Via"] = via
+		}}, req)
+	})
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to echo in headers
+// and logs: 1 to 128 letters, digits and "-_.:".
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_.:", r)) {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	var id [16]byte
+	for i := range id {
+		id[i] = byte(rand.Uint32())
+	}
+	return hex.EncodeToString(id[:])
+}
+
+// cacheHeaders adds the cache status (X-Cache), the age of cached copies (Age) and a timing
+// breakdown (Server-Timing) to responses, and with "X-Cache-Debug: 1" (if enabled) the cache
+// key, remaining TTL and eviction position of the requested file.
+func cacheHeaders(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		start := time.Now()
+		debug := config.CacheDebugHeaders && (req.Header("X-Cache-Debug") == "1" || strings.EqualFold(req.Header("X-Cache-Debug"), "true"))
+
+		next.ServeEdge(&hookWriter{ResponseWriter: w, hook: func(resp *http.Response) {
+			if req.CacheStatus != "" {
+				resp.Headers["X-Cache"] = string(req.CacheStatus)
+			}
+			if !req.CachedAt.IsZero() {
+				resp.Headers["Age"] = fmt.Sprint(max(0, int64(time.Since(req.CachedAt).Seconds())))
+			}
+			resp.Headers["Server-Timing"] = serverTiming(req, time.Since(start))
+			if debug {
+				addDebugHeaders(resp, req)
+			}
+		}}, req)
+	})
+}
+
+// serverTiming returns a Server-Timing header value with the time spent in the cache lookup,
+// waiting for the origin, and in total (in milliseconds).
+func serverTiming(req *Request, total time.Duration) string {
+	var parts []string
+	if req.CacheStatus != "" && req.CacheStatus != logging.CacheBypass {
+		parts = append(parts, "cache;dur="+durationMS(req.CacheTime))
+	}
+	if req.UpstreamTime > 0 {
+		parts = append(parts, "origin;dur="+durationMS(req.UpstreamTime))
+	}
+	parts = append(parts, "total;dur="+durationMS(total))
+	return strings.Join(parts, ", ")
+}
+
+func durationMS(d time.Duration) string {
+	return fmt.Sprintf("%.3f", float64(d.Microseconds())/1000)
+}
+
+// addDebugHeaders sets X-Cache-Key (virtual host and file), X-Cache-TTL (seconds until the
+// cached copy expires, "none" without a TTL) and X-Cache-Position ("<position>/<cached files>",
+// 1 = next to be evicted), or "-" for the last two if the file isn't cached.
+func addDebugHeaders(resp *http.Response, req *Request) {
+	filename := filepath.Base(req.Path)
+	resp.Headers["X-Cache-Key"] = req.VHost.Name + "/" + filename
+
+	pos, total := req.VHost.Cache.Position(filename)
+	if pos == 0 {
+		resp.Headers["X-Cache-TTL"] = "-"
+		resp.Headers["X-Cache-Position"] = "-"
+		return
+	}
+	resp.Headers["X-Cache-Position"] = fmt.Sprintf("%d/%d", pos, total)
+
+	switch info, err := req.VHost.Cache.Stat(filename); {
+	case config.CacheTTL == 0:
+		resp.Headers["X-Cache-TTL"] = "none"
+	case err != nil:
+		resp.Headers["X-Cache-TTL"] = "-"
+	default:
+		remaining := config.CacheTTL - time.Since(info.ModTime())
+		resp.Headers["X-Cache-TTL"] = fmt.Sprint(max(0, int64(math.Ceil(remaining.Seconds()))))
+	}
+}
@@ -0,0 +1,94 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/logging"
+	"cdn-edge-server/internal/metrics"
+	"fmt"
+	"time"
+)
+
+// Metrics holds the edge's metrics, served by the admin handler at /metrics.
+var Metrics = metrics.NewRegistry()
+
+var (
+	requestsTotal = Metrics.NewCounter("edge_requests_total",
+		"Requests answered, by method and status code.", "method", "status")
+	cacheRequestsTotal = Metrics.NewCounter("edge_cache_requests_total",
+		"Requests that reached the cache, by cache status (HIT, MISS, EXPIRED, STALE, BYPASS).", "status")
+	responseBytesTotal = Metrics.NewCounter("edge_response_bytes_total",
+		"Response body bytes sent to clients, by source (cache, origin, or edge for its own responses).", "source")
+	originFetchSeconds = Metrics.NewHistogram("edge_origin_fetch_duration_seconds",
+		"Time spent fetching from the origin, including the connection, by method.", metrics.DefaultBuckets, "method")
+	originErrorsTotal = Metrics.NewCounter("edge_origin_errors_total",
+		"Origin fetches that failed (unreachable origin or malformed response).")
+	prefetchTotal = Metrics.NewCounter("edge_prefetch_total",
+		"Assets linked from HTML pages prefetched into the cache, by result (fetched, cached already, failed).", "result")
+	compressedTotal = Metrics.NewCounter("edge_compressed_responses_total",
+		"Responses sent compressed, by content coding (gzip, deflate).", "encoding")
+)
+
+func init() {
+	Metrics.NewGaugeFunc("edge_cache_entries", "Files in the cache, by virtual host.", []string{"host"},
+		func(emit func(float64, ...string)) {
+			for _, vh := range virtualHosts() {
+				emit(float64(vh.Cache.Stats().Entries), vh.Name)
+			}
+		})
+	Metrics.NewGaugeFunc("edge_cache_bytes", "Total size of the files in the cache, by virtual host.", []string{"host"},
+		func(emit func(float64, ...string)) {
+			for _, vh := range virtualHosts() {
+				emit(float64(vh.Cache.Stats().Bytes), vh.Name)
+			}
+		})
+	Metrics.NewGaugeFunc("edge_cache_capacity_entries", "Files the cache holds before evicting, by virtual host.", []string{"host"},
+		func(emit func(float64, ...string)) {
+			for _, vh := range virtualHosts() {
+				emit(float64(vh.Cache.Stats().Capacity), vh.Name)
+			}
+		})
+	Metrics.NewCounterFunc("edge_cache_evictions_total", "Files removed from the cache, by virtual host and reason (capacity, ttl, invalidation, corruption).", []string{"host", "reason"},
+		func(emit func(float64, ...string)) {
+			for _, vh := range virtualHosts() {
+				for reason, n := range vh.Cache.Stats().Evictions {
+					emit(float64(n), vh.Name, string(reason))
+				}
+			}
+		})
+}
+
+// RegisterServerMetrics adds the given server's connection metrics to Metrics.
+func RegisterServerMetrics(srv *TCPServer) {
+	Metrics.NewGaugeFunc("edge_active_connections", "Client connections being served.", nil,
+		func(emit func(float64, ...string)) {
+			emit(float64(srv.ActiveConns()))
+		})
+}
+
+// recordMetrics counts each request once it has been answered.
+func recordMetrics(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		next.ServeEdge(w, req)
+
+		requestsTotal.Inc(req.Method, fmt.Sprint(w.Status()))
+		if req.CacheStatus != "" {
+			cacheRequestsTotal.Inc(string(req.CacheStatus))
+		}
+
+		source := "origin"
+		switch req.CacheStatus {
+		case logging.CacheHit, logging.CacheStale:
+			source = "cache"
+		case "":
+			source = "edge" // answered before the cache, e.g. an error or a redirect
+		}
+		responseBytesTotal.Add(float64(w.BodyBytes()), source)
+	})
+}
+
+// observeOriginFetch records the duration and outcome of an origin fetch.
+func observeOriginFetch(method string, d time.Duration, err error) {
+	originFetchSeconds.Observe(d.Seconds(), method)
+	if err != nil {
+		originErrorsTotal.Inc()
+	}
+}
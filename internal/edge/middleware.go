@@ -0,0 +1,196 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/logging"
+	"cdn-edge-server/internal/tracing"
+	"maps"
+	"net"
+	"time"
+)
+
+// Request is a client request as seen by edge handlers.
+type Request struct {
+	*http.Request
+	Conn  net.Conn      // client connection
+	VHost *VirtualHost  // virtual host serving the request (set by routeVirtualHost)
+	Span  *tracing.Span // the request's span, parent of the spans of each step serving it
+
+	// Path as the client sent it (Path may be rewritten), which signed URLs are signed for
+	OrigPath string
+
+	ID string // request ID, echoed in the X-Request-ID response header and the access log
+
+	// Set while serving the request, for the access log and response headers
+	CacheStatus  logging.CacheStatus
+	CachedAt     time.Time     // when the cached copy served was stored (zero = not served from the cache)
+	CacheTime    time.Duration // spent looking up the cache
+	UpstreamTime time.Duration
+}
+
+// ResponseWriter sends the response to a request. Each request gets one response, later
+// writes are ignored.
+type ResponseWriter interface {
+	// WriteResponse sends the given response (only its head if the request is HEAD).
+	WriteResponse(resp *http.Response)
+	// Status returns the status of the response sent (0 if none yet).
+	Status() int
+	// BodyBytes returns the number of response body bytes sent.
+	BodyBytes() int64
+}
+
+// Handler serves edge requests.
+type Handler interface {
+	ServeEdge(w ResponseWriter, req *Request)
+}
+
+// HandlerFunc adapts a function to the Handler interface.
+type HandlerFunc func(w ResponseWriter, req *Request)
+
+func (f HandlerFunc) ServeEdge(w ResponseWriter, req *Request) {
+	f(w, req)
+}
+
+// Middleware wraps a handler to run code before or after it, or instead of it (e.g. to reject
+// a request).
+type Middleware func(next Handler) Handler
+
+// Chain wraps h in the given middlewares. The first middleware sees requests first.
+func Chain(h Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// connWriter is the ResponseWriter writing to the client connection.
+type connWriter struct {
+	conn      net.Conn
+	headOnly  bool
+	span      *tracing.Span // request span, parent of the write span
+	status    int
+	bodyBytes int64
+}
+
+func (w *connWriter) WriteResponse(resp *http.Response) {
+	if w.status != 0 {
+		return
+	}
+	w.status = resp.Status
+
+	span := w.span.StartChild("write", tracing.Internal)
+	defer span.End()
+	w.conn.Write([]byte(resp.HeadString()))
+	if !w.headOnly {
+		n, _ := w.conn.Write(resp.Body)
+		w.bodyBytes = int64(n)
+	}
+}
+
+func (w *connWriter) Status() int {
+	return w.status
+}
+
+func (w *connWriter) BodyBytes() int64 {
+	return w.bodyBytes
+}
+
+// hookWriter passes responses to a function (which may modify them) before writing them.
+type hookWriter struct {
+	ResponseWriter
+	hook func(resp *http.Response)
+}
+
+func (w *hookWriter) WriteResponse(resp *http.Response) {
+	if w.Status() == 0 {
+		w.hook(resp)
+	}
+	w.ResponseWriter.WriteResponse(resp)
+}
+
+// writeError writes an error response with the given status code.
+func writeError(w ResponseWriter, status int) {
+	w.WriteResponse(http.BuildErrorResponse(status))
+}
+
+// AccessLog receives an entry for every request the edge answers (nil = no access log).
+var AccessLog *logging.AccessLog
+
+// logAccess writes each request to the access log once it has been answered.
+func logAccess(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		// Record the request as the client sent it, before any rewrite or query stripping
+		entry := logging.Entry{
+			Time:      time.Now(),
+			RequestID: req.ID,
+			Host:      req.Header("Host"),
+			Method:    req.Method,
+			Target:    req.Path,
+			Proto:     req.Version,
+			Referer:   req.Header("Referer"),
+			UserAgent: req.Header("User-Agent"),
+		}
+		if req.RawQuery != "" {
+			entry.Target += "?" + req.RawQuery
+		}
+
+		next.ServeEdge(w, req)
+
+		if AccessLog == nil {
+			return
+		}
+		if ip := clientIP(req.Conn, req.Request); ip.IsValid() {
+			entry.ClientIP = ip.String()
+		}
+		entry.Status = w.Status()
+		entry.Bytes = w.BodyBytes()
+		entry.Duration = time.Since(entry.Time)
+		entry.Upstream = req.UpstreamTime
+		entry.Cache = req.CacheStatus
+		AccessLog.Log(entry)
+	})
+}
+
+// Capture receives a record of every request the edge answers (nil = no traffic capture).
+var Capture *logging.Capture
+
+// captureTraffic records each request, as the client sent it, with a hash of the response
+// body, so the traffic can be replayed against an edge and the responses compared.
+func captureTraffic(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		if Capture == nil {
+			next.ServeEdge(w, req)
+			return
+		}
+
+		rec := logging.Record{
+			Time:       time.Now(),
+			RequestID:  req.ID,
+			Method:     req.Method,
+			Target:     req.Path,
+			Headers:    maps.Clone(req.Headers),
+			BodySize:   len(req.Body),
+			BodySHA256: logging.HashBody(req.Body),
+			Body:       req.Body,
+		}
+		if req.RawQuery != "" {
+			rec.Target += "?" + req.RawQuery
+		}
+
+		next.ServeEdge(&hookWriter{ResponseWriter: w, hook: func(resp *http.Response) {
+			body := resp.Body
+			if req.Method == "HEAD" {
+				body = nil // only the head is sent
+			}
+			rec.ResponseSize = len(body)
+			rec.ResponseSHA256 = logging.HashBody(body)
+		}}, req)
+
+		if rec.Status = w.Status(); rec.Status == 0 {
+			return
+		}
+		rec.Cache = req.CacheStatus
+		rec.DurationMS = float64(time.Since(rec.Time).Microseconds()) / 1000
+		Capture.Record(rec)
+	})
+}
@@ -0,0 +1,67 @@
+package edge
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"net"
+	"strings"
+	"testing"
+)
+
+// recorder is a ResponseWriter keeping the response written.
+type recorder struct {
+	resp *http.Response
+}
+
+func (r *recorder) WriteResponse(resp *http.Response) {
+	if r.resp == nil {
+		r.resp = resp
+	}
+}
+
+func (r *recorder) Status() int {
+	if r.resp == nil {
+		return 0
+	}
+	return r.resp.Status
+}
+
+func (r *recorder) BodyBytes() int64 {
+	if r.resp == nil {
+		return 0
+	}
+	return int64(len(r.resp.Body))
+}
+
+// addrConn is a client connection from the given address (only RemoteAddr is implemented).
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c *addrConn) RemoteAddr() net.Addr { return c.remote }
+
+// serveThrough serves a request for target (a path with an optional query string) from the
+// given client IP through the middlewares, ending with a handler answering 200. It returns the
+// response status and the path the final handler saw ("" if it wasn't reached).
+func serveThrough(t *testing.T, ip, method, target string, headers map[string]string, middlewares ...Middleware) (int, string) {
+	t.Helper()
+	head := method + " " + target + " HTTP/1.0\r\n"
+	for name, value := range headers {
+		head += name + ": " + value + "\r\n"
+	}
+	req, err := http.ParseReqHead(bufio.NewReader(strings.NewReader(head+"\r\n")), http.DefaultLimits)
+	if err != nil || req == nil {
+		t.Fatalf("parsing request %s %s: %v", method, target, err)
+	}
+	conn := &addrConn{remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+
+	served := ""
+	final := HandlerFunc(func(w ResponseWriter, req *Request) {
+		served = req.Path
+		w.WriteResponse(http.BuildResponse(200, "text/plain", nil))
+	})
+	w := &recorder{}
+	Chain(final, middlewares...).ServeEdge(w, &Request{Request: req, Conn: conn, OrigPath: req.Path})
+	return w.Status(), served
+}
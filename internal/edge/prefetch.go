@@ -0,0 +1,121 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/config"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	// Tags whose linked assets are prefetched, and the attribute holding the link
+	linkTag = regexp.MustCompile(`(?is)<(link|script|img)\b[^>]*>`)
+	linkRef = regexp.MustCompile(`(?is)\s(href|src)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	// Comments and inline scripts, whose markup isn't the page's
+	htmlComment  = regexp.MustCompile(`(?s)<!--.*?-->`)
+	inlineScript = regexp.MustCompile(`(?is)(<script\b[^>]*>).*?</script>`)
+	// <link> tags that load a resource for the page (unlike rel="canonical", etc.)
+	linkRel = regexp.MustCompile(`(?is)\srel\s*=\s*["']?[^"'>]*\b(stylesheet|preload|modulepreload|icon)\b`)
+)
+
+var (
+	prefetchSem      chan struct{} // bounds concurrent prefetches (config.PrefetchConcurrency)
+	prefetchSemOnce  sync.Once
+	prefetchInFlight sync.Map // "<host>/<file>" of the prefetches running
+)
+
+// prefetchLinks fetches the same-origin assets (stylesheets, scripts, images) an HTML page
+// fetched from the origin links to into the cache in the background, as the client will request
+// them next. Only the page's own links are followed (prefetched pages aren't parsed), at most
+// config.PrefetchMaxPerPage, and fewer than the cache's capacity so they never evict the page.
+func prefetchLinks(vh *VirtualHost, host, pagePath string, html []byte) {
+	limit := min(config.PrefetchMaxPerPage, vh.Cache.Stats().Capacity-1)
+	files := linkedFiles(vh, host, pagePath, html, limit)
+	if len(files) == 0 {
+		return
+	}
+	slog.Debug("Prefetching linked assets", "host", vh.Name, "page", pagePath, "files", len(files))
+
+	prefetchSemOnce.Do(func() {
+		prefetchSem = make(chan struct{}, max(1, config.PrefetchConcurrency))
+	})
+	for _, name := range files {
+		key := vh.Name + "/" + name
+		if _, running := prefetchInFlight.LoadOrStore(key, true); running {
+			continue // another page links to it too
+		}
+		go func() {
+			defer prefetchInFlight.Delete(key)
+			prefetchSem <- struct{}{}
+			defer func() { <-prefetchSem }()
+			prefetchFile(vh, name)
+		}()
+	}
+}
+
+// prefetchFile fetches the file into the cache, unless a request cached it meanwhile.
+func prefetchFile(vh *VirtualHost, name string) {
+	if vh.Cache.Has(name) {
+		prefetchTotal.Inc("cached")
+		return
+	}
+	gen := vh.Cache.Generation(name)
+	resp, err := fetchFromOrigin(nil, vh, "GET", name, nil)
+	if err == nil && resp.Status != 200 {
+		err = fmt.Errorf("origin answered %d", resp.Status)
+	}
+	if err != nil {
+		slog.Debug("Prefetch failed", "host", vh.Name, "file", name, "err", err)
+		prefetchTotal.Inc("failed")
+		return
+	}
+	if err := vh.Cache.AddIfCurrent(name, resp.Body, gen); err != nil {
+		slog.Debug("Prefetch store failed", "host", vh.Name, "file", name, "err", err)
+		prefetchTotal.Inc("failed")
+		return
+	}
+	prefetchTotal.Inc("fetched")
+}
+
+// linkedFiles returns the cache keys (file names) of the first limit distinct uncached assets
+// the page, requested from host, links to on the same host, in document order.
+func linkedFiles(vh *VirtualHost, host, pagePath string, html []byte, limit int) []string {
+	base := &url.URL{Scheme: "http", Host: host, Path: pagePath}
+	seen := map[string]bool{path.Base(pagePath): true}
+
+	html = htmlComment.ReplaceAll(html, nil)
+	html = inlineScript.ReplaceAll(html, []byte("$1"))
+
+	var files []string
+	for _, tag := range linkTag.FindAllSubmatch(html, -1) {
+		if len(files) >= limit {
+			break
+		}
+		if strings.EqualFold(string(tag[1]), "link") && !linkRel.Match(tag[0]) {
+			continue
+		}
+		m := linkRef.FindSubmatch(tag[0])
+		if m == nil {
+			continue
+		}
+		ref := string(m[2]) + string(m[3]) + string(m[4]) // one of the three quotings
+
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || !strings.EqualFold(u.Hostname(), base.Hostname()) {
+			continue
+		}
+		name := path.Base(u.Path) // cached by file name, as requests are
+		if name == "/" || name == "." || seen[name] {
+			continue
+		}
+		seen[name] = true
+		if !vh.Cache.Has(name) {
+			files = append(files, name)
+		}
+	}
+	return files
+}
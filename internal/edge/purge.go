@@ -0,0 +1,145 @@
+package edge
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	eventsPath       = "/_events"       // origin's change event stream
+	eventReadTimeout = 45 * time.Second // origin pings every 15s, so this means the stream is dead
+	maxResubBackoff  = 30 * time.Second
+)
+
+// purgeSubscriber tracks the position in the origin's change event stream, so that events
+// published while disconnected are replayed (or a full resync is triggered) on reconnect.
+type purgeSubscriber struct {
+	vh      *VirtualHost
+	epoch   string
+	lastSeq uint64
+}
+
+// SubscribePurges keeps a persistent subscription to each virtual host's origin change events
+// and purges cached files that were changed on the origin (e.g. by a write through another edge).
+// Subscriptions run in the background and reconnect with backoff whenever their stream drops.
+func SubscribePurges() {
+	for _, vh := range vhosts.list {
+		go subscribePurges(vh)
+	}
+}
+
+// subscribePurges follows the given virtual host's origin change events. It never returns.
+func subscribePurges(vh *VirtualHost) {
+	sub := &purgeSubscriber{vh: vh}
+	backoff := time.Second
+	for {
+		start := time.Now()
+		err := sub.follow()
+		slog.Warn("Purge: change event stream lost", "host", vh.Name, "err", err)
+
+		if time.Since(start) > maxResubBackoff {
+			backoff = time.Second // stream was healthy for a while, reconnect promptly
+		}
+		time.Sleep(backoff)
+		backoff = min(backoff*2, maxResubBackoff)
+	}
+}
+
+// follow opens one subscription and applies events from it until the stream fails.
+func (s *purgeSubscriber) follow() error {
+	conn, err := dialOrigin(s.vh.Origin)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	reqStr := fmt.Sprintf(
+		"GET %s HTTP/1.0\r\nHost: %s\r\nX-Event-Epoch: %s\r\nLast-Event-Seq: %d\r\n\r\n",
+		eventsPath, s.vh.Name, s.epoch, s.lastSeq,
+	)
+	if _, err := conn.Write([]byte(reqStr)); err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(eventReadTimeout))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ParseResp(reader)
+	if err != nil {
+		return err
+	}
+	if resp.Status != 200 {
+		return fmt.Errorf("origin refused subscription with status %d", resp.Status)
+	}
+	s.epoch = resp.Headers["X-Event-Epoch"]
+	slog.Info("Purge: subscribed to origin change events", "host", s.vh.Name)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(eventReadTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "ping":
+			// keep-alive
+		case "resync":
+			if len(fields) != 2 {
+				return fmt.Errorf("malformed event: %q", line)
+			}
+			seq, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("malformed event: %q", line)
+			}
+			// Events may have been missed, so nothing in the cache can be trusted as is.
+			// Record the new position first: anything published from here on is still streamed to us.
+			s.lastSeq = seq
+			revalidateCache(s.vh)
+		case "purge":
+			if len(fields) != 4 {
+				return fmt.Errorf("malformed event: %q", line)
+			}
+			seq, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return fmt.Errorf("malformed event: %q", line)
+			}
+			s.vh.Cache.Purge(fields[2])
+			s.lastSeq = seq
+		default:
+			slog.Warn("Purge: ignoring unknown event", "host", s.vh.Name, "event", strings.TrimSpace(line))
+		}
+	}
+}
+
+// revalidateCache checks every file in the virtual host's cache against its origin and purges
+// the ones whose content no longer matches (different ETag) or that no longer exist.
+func revalidateCache(vh *VirtualHost) {
+	for _, name := range vh.Cache.CacheContent() {
+		originResp, err := fetchFromOrigin(nil, vh, "HEAD", name, nil)
+		if err != nil {
+			// Origin unreachable, the stream will drop as well and we resync again on reconnect
+			continue
+		}
+
+		if originResp.Status == 404 {
+			vh.Cache.Purge(name)
+			continue
+		}
+
+		dat, err := vh.Cache.Get(name)
+		if err != nil || http.ETag(dat) != originResp.Headers["ETag"] {
+			vh.Cache.Purge(name)
+		}
+	}
+	slog.Info("Purge: revalidated cache against origin", "host", vh.Name)
+}
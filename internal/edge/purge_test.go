@@ -0,0 +1,33 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/cache"
+	"testing"
+)
+
+func TestPurgeDuringFetch(t *testing.T) {
+	c, err := cache.New("", t.TempDir(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vh := &VirtualHost{Name: "www.test", Cache: c}
+	route := func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, req *Request) {
+			req.VHost = vh
+			next.ServeEdge(w, req)
+		})
+	}
+	purgeWhileFetching := func(next Handler) Handler {
+		return HandlerFunc(func(w ResponseWriter, req *Request) {
+			c.Purge("a.txt") // change event arriving while the origin answers
+			next.ServeEdge(w, req)
+		})
+	}
+
+	if status, _ := serveThrough(t, "127.0.0.1", "GET", "/a.txt", nil, route, serveFromCache, purgeWhileFetching); status != 200 || c.Has("a.txt") {
+		t.Errorf("purged during fetch: got %d, cached = %t, want 200 and not cached", status, c.Has("a.txt"))
+	}
+	if status, _ := serveThrough(t, "127.0.0.1", "GET", "/a.txt", nil, route, serveFromCache); status != 200 || !c.Has("a.txt") {
+		t.Errorf("fetched again: got %d, cached = %t, want 200 and cached", status, c.Has("a.txt"))
+	}
+}
@@ -0,0 +1,155 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"container/list"
+	"fmt"
+	"math"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limiter applies the configured per-client rate limits to every edge request.
+var limiter = newRateLimiter(
+	bucketPolicy{rate: config.RateLimitRPS, burst: float64(config.RateLimitBurst)},
+	bucketPolicy{rate: config.RateLimitWriteRPS, burst: float64(config.RateLimitWriteBurst)},
+	config.RateLimitPrefixes,
+	config.RateLimitMaxClients,
+	config.RateLimitIPv6Prefix,
+)
+
+// rateLimit answers requests over their client's rate limit with 429 and a Retry-After header,
+// before any work is done for them.
+func rateLimit(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		if ok, wait := limiter.allow(req.Conn, req.Request, time.Now()); !ok {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			w.WriteResponse(http.BuildErrorResponse(429).WithHeader("Retry-After", fmt.Sprint(retryAfter)))
+			return
+		}
+		next.ServeEdge(w, req)
+	})
+}
+
+// bucketPolicy is the refill rate (tokens/second) and capacity of a token bucket.
+// A rate of 0 disables limiting.
+type bucketPolicy struct {
+	rate  float64
+	burst float64
+}
+
+// tokenBucket holds a client's remaining request allowance; one token is spent per request.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // last refill
+}
+
+// take refills the bucket for the time elapsed since the last call and spends one token.
+// If none is available, it returns false and how long until one will be.
+func (b *tokenBucket) take(now time.Time, p bucketPolicy) (bool, time.Duration) {
+	b.tokens = math.Min(p.burst, b.tokens+now.Sub(b.last).Seconds()*p.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := (1 - b.tokens) / p.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// rateLimiter keeps one token bucket per client, request class (read or write), and
+// configured path prefix. A client is an IPv4 address, or an IPv6 network of ipv6Prefix bits,
+// as an IPv6 host usually has a whole /64 to pick addresses from. The number of buckets is
+// bounded: once maxKeys is reached, the least recently used bucket is dropped (that client
+// starts over with a full bucket).
+type rateLimiter struct {
+	read       bucketPolicy // GET, HEAD, ...
+	write      bucketPolicy // POST, PUT
+	prefixes   []string
+	maxKeys    int
+	ipv6Prefix int
+
+	mu      sync.Mutex
+	lru     *list.List               // of *limiterEntry, most recently used at the front
+	buckets map[string]*list.Element // key → element in lru
+}
+
+type limiterEntry struct {
+	key    string
+	bucket tokenBucket
+}
+
+func newRateLimiter(read, write bucketPolicy, prefixes []string, maxKeys, ipv6Prefix int) *rateLimiter {
+	// A bucket smaller than one token would reject everything
+	read.burst = max(read.burst, 1)
+	write.burst = max(write.burst, 1)
+
+	return &rateLimiter{
+		read:       read,
+		write:      write,
+		prefixes:   prefixes,
+		maxKeys:    maxKeys,
+		ipv6Prefix: ipv6Prefix,
+		lru:        list.New(),
+		buckets:    make(map[string]*list.Element),
+	}
+}
+
+// allow reports whether the given request, made at the given time, is within its client's rate
+// limit. If not, it also returns how long the client should wait before retrying.
+func (l *rateLimiter) allow(conn net.Conn, req *http.Request, now time.Time) (bool, time.Duration) {
+	class, policy := "r", l.read
+	if req.Method == "POST" || req.Method == "PUT" {
+		class, policy = "w", l.write
+	}
+	if policy.rate <= 0 {
+		return true, 0
+	}
+
+	// Requests under a configured prefix are counted separately from the rest of the site
+	scope := ""
+	for _, p := range l.prefixes {
+		if strings.HasPrefix(req.Path, p) {
+			scope = p
+			break
+		}
+	}
+	key := l.client(clientIP(conn, req)) + " " + class + " " + scope
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	elem, ok := l.buckets[key]
+	if ok {
+		l.lru.MoveToFront(elem)
+	} else {
+		if l.maxKeys > 0 && l.lru.Len() >= l.maxKeys {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*limiterEntry).key)
+		}
+		elem = l.lru.PushFront(&limiterEntry{
+			key:    key,
+			bucket: tokenBucket{tokens: policy.burst, last: now},
+		})
+		l.buckets[key] = elem
+	}
+
+	return elem.Value.(*limiterEntry).bucket.take(now, policy)
+}
+
+// client returns the rate limiting identity of the given client IP: the address itself, or its
+// network for IPv6.
+func (l *rateLimiter) client(ip netip.Addr) string {
+	if ip.Is6() && l.ipv6Prefix > 0 {
+		if network, err := ip.Prefix(l.ipv6Prefix); err == nil {
+			return network.String()
+		}
+	}
+	return ip.String()
+}
@@ -0,0 +1,136 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/http"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	policy := bucketPolicy{rate: 2, burst: 3}
+	start := time.Unix(1000, 0)
+	b := tokenBucket{tokens: policy.burst, last: start}
+
+	steps := []struct {
+		at   time.Duration // since start
+		ok   bool
+		wait time.Duration
+	}{
+		// The burst is spent at once, then a token comes every 500ms
+		{0, true, 0},
+		{0, true, 0},
+		{0, true, 0},
+		{0, false, 500 * time.Millisecond},
+		{100 * time.Millisecond, false, 400 * time.Millisecond},
+		{500 * time.Millisecond, true, 0},
+		{500 * time.Millisecond, false, 500 * time.Millisecond},
+
+		// Refills stop at the burst
+		{time.Hour, true, 0},
+		{time.Hour, true, 0},
+		{time.Hour, true, 0},
+		{time.Hour, false, 500 * time.Millisecond},
+	}
+	for i, s := range steps {
+		ok, wait := b.take(start.Add(s.at), policy)
+		if ok != s.ok || (wait-s.wait).Abs() > time.Millisecond {
+			t.Errorf("step %d at %v: got %t, wait %v, want %t, wait %v", i, s.at, ok, wait, s.ok, s.wait)
+		}
+	}
+}
+
+// from returns a connection from the given IP.
+func from(ip string) net.Conn {
+	return &addrConn{remote: &net.TCPAddr{IP: net.ParseIP(ip), Port: 40000}}
+}
+
+func TestRateLimiterKeys(t *testing.T) {
+	now := time.Unix(1000, 0)
+	get := &http.Request{Method: "GET", Path: "/a.txt"}
+
+	tests := []struct {
+		name       string
+		ipv6Prefix int
+		first      net.Conn
+		firstReq   *http.Request
+		second     net.Conn
+		secondReq  *http.Request
+		shared     bool
+	}{
+		{"same IPv4", 64, from("192.0.2.1"), get, from("192.0.2.1"), get, true},
+		{"other IPv4", 64, from("192.0.2.1"), get, from("192.0.2.2"), get, false},
+		{"same IPv6 /64", 64, from("2001:db8::1"), get, from("2001:db8::ffff:1"), get, true},
+		{"other IPv6 /64", 64, from("2001:db8::1"), get, from("2001:db8:0:1::1"), get, false},
+		{"same IPv6 /48", 48, from("2001:db8::1"), get, from("2001:db8:0:1::1"), get, true},
+		{"other IPv6 /128", 128, from("2001:db8::1"), get, from("2001:db8::2"), get, false},
+		{"IPv4-mapped IPv6", 64, from("::ffff:192.0.2.1"), get, from("192.0.2.1"), get, true},
+		{"writes", 64, from("192.0.2.1"), get, from("192.0.2.1"), &http.Request{Method: "PUT", Path: "/a.txt"}, false},
+		{"path prefix", 64, from("192.0.2.1"), get, from("192.0.2.1"), &http.Request{Method: "GET", Path: "/api/x"}, false},
+	}
+	for _, tt := range tests {
+		// One token per bucket, none refilled during the test
+		l := newRateLimiter(bucketPolicy{rate: 1e-9, burst: 1}, bucketPolicy{rate: 1e-9, burst: 1}, []string{"/api/"}, 0, tt.ipv6Prefix)
+		if ok, _ := l.allow(tt.first, tt.firstReq, now); !ok {
+			t.Fatalf("%s: first request limited", tt.name)
+		}
+		if ok, _ := l.allow(tt.second, tt.secondReq, now); ok == tt.shared {
+			t.Errorf("%s: second request allowed = %t, want shared bucket = %t", tt.name, ok, tt.shared)
+		}
+	}
+}
+
+func TestRateLimiterEviction(t *testing.T) {
+	now := time.Unix(1000, 0)
+	get := &http.Request{Method: "GET", Path: "/a.txt"}
+	l := newRateLimiter(bucketPolicy{rate: 1e-9, burst: 1}, bucketPolicy{}, nil, 2, 64)
+
+	steps := []struct {
+		ip string
+		ok bool
+	}{
+		{"192.0.2.1", true},
+		{"192.0.2.2", true},
+		{"192.0.2.1", false}, // spent, and now the most recently used
+		{"192.0.2.3", true},  // evicts 192.0.2.2
+		{"192.0.2.1", false}, // still tracked
+		{"192.0.2.2", true},  // starts over with a full bucket, evicts 192.0.2.3
+		{"192.0.2.3", true},
+
+		// Addresses in one IPv6 /64 are one client, so they can't flush the others
+		{"2001:db8::1", true}, // evicts 192.0.2.2
+		{"2001:db8::2", false},
+		{"2001:db8::3", false},
+		{"192.0.2.3", false}, // still tracked
+	}
+	for i, s := range steps {
+		if ok, _ := l.allow(from(s.ip), get, now); ok != s.ok {
+			t.Errorf("step %d from %s: allowed = %t, want %t", i, s.ip, ok, s.ok)
+		}
+		if n := l.lru.Len(); n > 2 {
+			t.Fatalf("step %d: %d buckets kept, want at most 2", i, n)
+		}
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	defer func(prev *rateLimiter) { limiter = prev }(limiter)
+	limiter = newRateLimiter(bucketPolicy{rate: 0.25, burst: 1}, bucketPolicy{}, nil, 0, 64)
+
+	statuses := []int{}
+	var retryAfter string
+	for range 2 {
+		w := &recorder{}
+		Chain(HandlerFunc(func(w ResponseWriter, req *Request) {
+			w.WriteResponse(http.BuildResponse(200, "text/plain", nil))
+		}), rateLimit).ServeEdge(w, &Request{Request: &http.Request{Method: "GET", Path: "/a.txt"}, Conn: from("192.0.2.1")})
+		statuses = append(statuses, w.Status())
+		retryAfter = w.resp.Headers["Retry-After"]
+	}
+	if statuses[0] != 200 || statuses[1] != 429 {
+		t.Errorf("got statuses %v, want [200 429]", statuses)
+	}
+	if retryAfter != "4" { // a token every 4s, rounded up
+		t.Errorf("Retry-After = %q, want 4", retryAfter)
+	}
+}
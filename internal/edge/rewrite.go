@@ -0,0 +1,61 @@
+package edge
+
+import (
+	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/rewrite"
+	"log/slog"
+	"strings"
+)
+
+// RewriteRules, if set, rewrite request paths, redirect requests and edit response headers.
+var RewriteRules rewrite.Rules
+
+// applyRewrites applies the rewrite rules to each request: it rewrites the path before the
+// cache lookup, applies the rules' header edits to the response, and answers with a redirect
+// (without touching the cache or origin) if a rule says so.
+func applyRewrites(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		if RewriteRules == nil {
+			next.ServeEdge(w, req)
+			return
+		}
+
+		res := RewriteRules.Apply(req.Method, req.Path, req.Headers)
+		if len(res.SetHeaders) > 0 || len(res.StripHeaders) > 0 {
+			w = &hookWriter{ResponseWriter: w, hook: func(resp *http.Response) {
+				editHeaders(resp, res.SetHeaders, res.StripHeaders)
+			}}
+		}
+
+		if res.Location != "" {
+			// Keep the query string, unless the rule's location sets its own
+			location := res.Location
+			if req.RawQuery != "" && !strings.Contains(location, "?") {
+				location += "?" + req.RawQuery
+			}
+			slog.Debug("Rewrite rule redirected request", "method", req.Method, "path", req.Path, "location", location, "status", res.Status)
+			w.WriteResponse(http.BuildRedirectResponse(res.Status, location))
+			return
+		}
+
+		if res.Path != req.Path {
+			slog.Debug("Rewrite rule rewrote path", "method", req.Method, "path", req.Path, "rewritten", res.Path)
+			req.Path = res.Path
+		}
+		next.ServeEdge(w, req)
+	})
+}
+
+// editHeaders removes the given headers (case-insensitively) from the response, then sets the given ones.
+func editHeaders(resp *http.Response, set map[string]string, strip []string) {
+	for _, name := range strip {
+		for key := range resp.Headers {
+			if strings.EqualFold(key, name) {
+				delete(resp.Headers, key)
+			}
+		}
+	}
+	for name, value := range set {
+		resp.Headers[name] = value
+	}
+}
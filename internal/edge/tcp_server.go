@@ -1,17 +1,48 @@
 package edge
 
 import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/tracing"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
+	"time"
 )
 
+// ErrServerClosed is returned by ListenAndServe and Serve after a call to Shutdown.
+var ErrServerClosed = errors.New("edge: server closed")
+
 type TCPServer struct {
-	Host    string
-	Port    string
-	Handler func(net.Conn)
+	Host     string
+	Port     string
+	Handler  Handler
+	MaxConns int // concurrent connections before new ones are answered with 503 (0 = unlimited)
+
+	// Time allowed to send the request line and headers, the body, and to write the response
+	// (0 = no limit)
+	HeaderReadTimeout time.Duration
+	BodyReadTimeout   time.Duration
+	WriteTimeout      time.Duration
+	Limits            http.Limits // request size limits
+
+	// TLSConfig, if set, makes the server terminate TLS on every accepted connection
+	TLSConfig *tls.Config
+
+	// Tracer, if set, records a span for each request (if nil, spans only carry the client's
+	// trace context on to the origin)
+	Tracer *tracing.Tracer
+
+	// conns holds the listener being served (the raw TCP one, which Upgrade hands to the new
+	// process) and the in-flight client connections, for graceful shutdown
+	conns http.ConnTracker
 }
 
-func NewTCPServer(host, port string, handler func(net.Conn)) *TCPServer {
+func NewTCPServer(host, port string, handler Handler) *TCPServer {
 	return &TCPServer{
 		Host:    host,
 		Port:    port,
@@ -20,26 +51,207 @@ func NewTCPServer(host, port string, handler func(net.Conn)) *TCPServer {
 }
 
 func (s *TCPServer) ListenAndServe() error {
-	// Start socket listener
+	// Start socket listener (or take over the parent's, after an upgrade)
 	addr := s.Host + ":" + s.Port
-	listener, err := net.Listen("tcp", addr)
+	listener, err := listen(addr)
 	if err != nil {
 		return fmt.Errorf("listen error: %w", err)
 	}
 
-	fmt.Println("Server listening on", addr)
+	slog.Info("Server listening", "addr", addr)
+
+	// If we were started by an upgrade, the parent can start draining now
+	notifyReady()
+
+	return s.Serve(listener)
+}
+
+// ListenAndServeExclusive is like ListenAndServe for a server whose listener isn't handed over
+// on upgrade: it retries binding the address for up to the given time, as the previous edge
+// process may still hold it while it drains.
+func (s *TCPServer) ListenAndServeExclusive(retryFor time.Duration) error {
+	addr := s.Host + ":" + s.Port
+	deadline := time.Now().Add(retryFor)
+	for {
+		listener, err := net.Listen("tcp", addr)
+		if err == nil {
+			slog.Info("Server listening", "addr", addr)
+			return s.Serve(listener)
+		}
+		if s.conns.Closing() {
+			return ErrServerClosed
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("listen error: %w", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Serve accepts client connections on the given listener and handles each one concurrently
+// with the server's Handler, until Shutdown is called.
+func (s *TCPServer) Serve(listener net.Listener) error {
+	stopped, ok := s.conns.Serve(listener)
+	if !ok {
+		return ErrServerClosed
+	}
+	defer stopped()
+
+	// Terminate TLS on top of the raw listener (the handshake runs on the first read)
+	if s.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.TLSConfig)
+	}
 
 	// Listen for incoming client connections
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if s.conns.Closing() {
+				return ErrServerClosed
+			}
 			// Error occurred while accepting client connection, skip that client and keep listening
-			fmt.Println("accept error:", err)
+			slog.Error("Accept error", "err", err)
+			continue
+		}
+		slog.Debug("Client connected", "remote", conn.RemoteAddr())
+
+		if !s.conns.Track(conn, s.MaxConns) {
+			go rejectBusy(conn)
 			continue
 		}
-		fmt.Println("Client connected from:", conn.RemoteAddr())
 
 		// Concurrently handle client connections
-		go HandleClient(conn)
+		go func() {
+			defer s.conns.Untrack(conn)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Shutdown gracefully stops the server: it stops accepting new connections, then waits for
+// in-flight connections to finish. If ctx expires first, the remaining connections are closed
+// and ctx's error is returned.
+func (s *TCPServer) Shutdown(ctx context.Context) error {
+	s.conns.Close()
+	return s.conns.Drain(ctx)
+}
+
+// serveConn reads a request from the given client connection and serves it with the server's Handler.
+func (s *TCPServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	// Parse client request (headers, then body, each with its own deadline so a client
+	// dribbling bytes can't hold the connection open indefinitely)
+	start := time.Now()
+	reader := bufio.NewReader(conn)
+	conn.SetReadDeadline(deadline(s.HeaderReadTimeout))
+	req, err := http.ParseReqHead(reader, s.Limits)
+	if err == nil && req != nil {
+		conn.SetReadDeadline(deadline(s.BodyReadTimeout))
+		err = req.ReadBody(reader, s.Limits)
+	}
+
+	if err != nil || req == nil {
+		if err != nil && err.Error() == "EOF" {
+			// Health check, silently ignore
+			return
+		}
+		if tlsConn, ok := conn.(*tls.Conn); ok && !tlsConn.ConnectionState().HandshakeComplete {
+			// No TLS session to answer in (a plaintext response would be garbage to the client)
+			slog.Debug("TLS handshake failed", "remote", conn.RemoteAddr(), "err", err)
+			return
+		}
+		conn.SetWriteDeadline(deadline(s.WriteTimeout))
+		rejectRequest(conn, parseErrorStatus(err))
+		return
+	}
+
+	// Continue the client's trace, or start one
+	parent, _ := tracing.ParseTraceparent(req.Header("traceparent"))
+	span := s.Tracer.Start(req.Method, tracing.Server, parent, start)
+	span.AddChild("parse", start, time.Now())
+	span.SetAttr("http.request.method", req.Method)
+	span.SetAttr("url.path", req.Path)
+	span.SetAttr("server.address", req.Header("Host"))
+	span.SetAttr("client.address", conn.RemoteAddr().String())
+
+	conn.SetWriteDeadline(deadline(s.WriteTimeout))
+	w := &connWriter{conn: conn, headOnly: req.Method == "HEAD", span: span}
+	edgeReq := &Request{Request: req, Conn: conn, Span: span, OrigPath: req.Path}
+	s.Handler.ServeEdge(w, edgeReq)
+
+	span.SetAttr("http.response.status_code", w.Status())
+	span.SetAttr("http.response.body.size", w.BodyBytes())
+	if edgeReq.CacheStatus != "" {
+		span.SetAttr("edge.cache_status", string(edgeReq.CacheStatus))
+	}
+	if w.Status() >= 500 {
+		span.SetError(fmt.Errorf("status %d", w.Status()))
+	}
+	span.End()
+}
+
+// deadline returns the deadline for an operation allowed to take the given time (0 = none).
+func deadline(timeout time.Duration) time.Time {
+	if timeout == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// parseErrorStatus returns the status code to answer a request that failed to parse with.
+func parseErrorStatus(err error) int {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, http.ErrHeaderTooLarge), errors.Is(err, http.ErrTooManyHeaders):
+		return 431
+	case errors.Is(err, http.ErrBodyTooLarge):
+		return 413
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return 408
+	default:
+		return 400
+	}
+}
+
+// ActiveConns returns the number of client connections being served.
+func (s *TCPServer) ActiveConns() int {
+	return s.conns.Active()
+}
+
+// rejectBusy answers a connection accepted over the server's connection limit with 503.
+// The request itself is never read.
+func rejectBusy(conn net.Conn) {
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(time.Second)) // TLS connections read the handshake first
+	resp := http.BuildErrorResponse(503).WithHeader("Retry-After", "1")
+	conn.Write([]byte(resp.HeadString()))
+	conn.Write(resp.Body)
+	discardUnread(conn)
+}
+
+// rejectRequest writes an error response with the given status code to the given connection,
+// for a request that was not (fully) read.
+func rejectRequest(conn net.Conn, status int) {
+	resp := http.BuildErrorResponse(status)
+	conn.Write([]byte(resp.HeadString()))
+	conn.Write(resp.Body)
+	discardUnread(conn)
+}
+
+// discardUnread reads and discards (a bounded amount of) unread request data after an early
+// response. Closing with unread data makes the kernel reset the connection, which can discard
+// the response before the client reads it. TLS connections get a close_notify first, then the
+// raw connection underneath is drained (the data is of no use, so it isn't decrypted).
+func discardUnread(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.CloseWrite()
+		conn = tlsConn.NetConn()
+	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.CloseWrite()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		io.Copy(io.Discard, io.LimitReader(conn, 256<<10))
 	}
 }
@@ -0,0 +1,341 @@
+package edge
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer serves the handler on a loopback port with the given server settings, and
+// returns the server's address. The server is shut down when the test ends.
+func startServer(t *testing.T, handler Handler, configure func(s *TCPServer)) (*TCPServer, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewTCPServer("127.0.0.1", "0", handler)
+	if configure != nil {
+		configure(s)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(listener) }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+		<-done
+	})
+	return s, listener.Addr().String()
+}
+
+// roundTrip sends raw request bytes and returns the status of the response, or 0 if the
+// server closed the connection without one.
+func roundTrip(t *testing.T, addr, request string) int {
+	t.Helper()
+	status, err := send(addr, request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// send is roundTrip for use outside the test's goroutine: it returns an error if the
+// request couldn't be sent.
+func send(addr, request string) (int, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, request); err != nil {
+		return 0, err
+	}
+	resp, err := http.ParseResp(bufio.NewReader(conn))
+	if err != nil {
+		return 0, nil
+	}
+	return resp.Status, nil
+}
+
+var okHandler = HandlerFunc(func(w ResponseWriter, req *Request) {
+	w.WriteResponse(http.BuildResponse(200, "text/plain", []byte("ok")))
+})
+
+func TestServeConnLimits(t *testing.T) {
+	_, addr := startServer(t, okHandler, func(s *TCPServer) {
+		s.Limits = http.Limits{MaxHeaderBytes: 256, MaxHeaderCount: 4, MaxBodyBytes: 16}
+	})
+
+	tests := []struct {
+		name, request string
+		status        int
+	}{
+		{"ok", "GET /a.txt HTTP/1.0\r\n\r\n", 200},
+		{"malformed", "GET\r\n\r\n", 400},
+		{"header too large", "GET /a.txt HTTP/1.0\r\nX-A: " + strings.Repeat("a", 300) + "\r\n\r\n", 431},
+		{"too many headers", "GET /a.txt HTTP/1.0\r\n" + strings.Repeat("X-A: 1\r\n", 5) + "\r\n", 431},
+		{"body too large", "PUT /a.txt HTTP/1.0\r\nContent-Length: 17\r\n\r\n" + strings.Repeat("a", 17), 413},
+		{"bad length", "PUT /a.txt HTTP/1.0\r\nContent-Length: x\r\n\r\n", 400},
+		{"body within limit", "PUT /a.txt HTTP/1.0\r\nContent-Length: 16\r\n\r\n" + strings.Repeat("a", 16), 200},
+	}
+	for _, tt := range tests {
+		if status := roundTrip(t, addr, tt.request); status != tt.status {
+			t.Errorf("%s: got %d, want %d", tt.name, status, tt.status)
+		}
+	}
+}
+
+func TestServeConnReadTimeouts(t *testing.T) {
+	_, addr := startServer(t, okHandler, func(s *TCPServer) {
+		s.HeaderReadTimeout = 100 * time.Millisecond
+		s.BodyReadTimeout = 100 * time.Millisecond
+	})
+
+	tests := []struct {
+		name, request string
+	}{
+		{"head never finished", "GET /a.txt HTTP/1.0\r\nHost: a"},
+		{"body never finished", "PUT /a.txt HTTP/1.0\r\nContent-Length: 10\r\n\r\nabc"},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		if status := roundTrip(t, addr, tt.request); status != 408 {
+			t.Errorf("%s: got %d, want 408", tt.name, status)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("%s: answered after %v", tt.name, elapsed)
+		}
+	}
+}
+
+func TestServeConnWriteTimeout(t *testing.T) {
+	body := make([]byte, 64<<20) // more than the socket buffers hold
+	wrote := make(chan struct{})
+	handler := HandlerFunc(func(w ResponseWriter, req *Request) {
+		w.WriteResponse(http.BuildResponse(200, "application/octet-stream", body))
+		close(wrote)
+	})
+	s, addr := startServer(t, handler, func(s *TCPServer) {
+		s.WriteTimeout = 200 * time.Millisecond
+	})
+
+	// A client that never reads the response
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /big.bin HTTP/1.0\r\n\r\n")
+
+	select {
+	case <-wrote:
+	case <-time.After(5 * time.Second):
+		t.Fatal("response write didn't time out")
+	}
+	for deadline := time.Now().Add(2 * time.Second); s.ActiveConns() > 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("connection still open after the write timed out")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRejectBusy(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	handler := HandlerFunc(func(w ResponseWriter, req *Request) {
+		started <- struct{}{}
+		<-release
+		okHandler(w, req)
+	})
+	s, addr := startServer(t, handler, func(s *TCPServer) { s.MaxConns = 1 })
+
+	// Occupy the only connection slot
+	first := make(chan int, 1)
+	go func() {
+		status, _ := send(addr, "GET /slow.txt HTTP/1.0\r\n\r\n")
+		first <- status
+	}()
+	<-started
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	// The request is never read, but sending one must not make the response get lost
+	io.WriteString(conn, "GET /a.txt HTTP/1.0\r\n\r\n")
+	resp, err := http.ParseResp(bufio.NewReader(conn))
+	if err != nil {
+		t.Fatalf("busy response lost: %v", err)
+	}
+	if resp.Status != 503 || resp.Headers["Retry-After"] != "1" {
+		t.Errorf("got %d with Retry-After %q, want 503 with Retry-After 1", resp.Status, resp.Headers["Retry-After"])
+	}
+	if n := s.ActiveConns(); n != 1 {
+		t.Errorf("got %d active connections, want 1 (the rejected one isn't tracked)", n)
+	}
+
+	close(release)
+	if status := <-first; status != 200 {
+		t.Errorf("first request: got %d, want 200", status)
+	}
+	if status := roundTrip(t, addr, "GET /a.txt HTTP/1.0\r\n\r\n"); status != 200 {
+		t.Errorf("after the slot was freed: got %d, want 200", status)
+	}
+}
+
+// selfSigned returns a self-signed certificate for localhost.
+func selfSigned(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestServeConnTLS(t *testing.T) {
+	release := make(chan struct{})
+	handler := HandlerFunc(func(w ResponseWriter, req *Request) {
+		if req.Path == "/slow.txt" {
+			<-release
+		}
+		okHandler(w, req)
+	})
+	_, addr := startServer(t, handler, func(s *TCPServer) {
+		s.TLSConfig = &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}}
+		s.Limits = http.Limits{MaxHeaderBytes: 256}
+		s.MaxConns = 2
+	})
+	clientConfig := &tls.Config{ServerName: "localhost", InsecureSkipVerify: true}
+
+	// sendTLS sends the request, the whole of it, before reading the response
+	sendTLS := func(request string) (int, error) {
+		conn, err := tls.Dial("tcp", addr, clientConfig)
+		if err != nil {
+			return 0, err
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.WriteString(conn, request); err != nil {
+			return 0, err
+		}
+		resp, err := http.ParseResp(bufio.NewReader(conn))
+		if resp == nil {
+			return 0, err
+		}
+		return resp.Status, nil
+	}
+
+	if status, err := sendTLS("GET /a.txt HTTP/1.0\r\n\r\n"); status != 200 {
+		t.Errorf("TLS request: got %d (%v), want 200", status, err)
+	}
+
+	// Early responses reach the client in full, with request data left unread
+	large := "GET /a.txt HTTP/1.0\r\nX-A: " + strings.Repeat("a", 200<<10) + "\r\n\r\n"
+	for range 5 {
+		if status, err := sendTLS(large); status != 431 {
+			t.Errorf("TLS request with a large header: got %d (%v), want 431", status, err)
+		}
+	}
+
+	// A plaintext request gets no plaintext response, the handshake just fails
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /a.txt HTTP/1.0\r\n\r\n")
+	got, _ := io.ReadAll(conn)
+	conn.Close()
+	if strings.Contains(string(got), "HTTP/") {
+		t.Errorf("plaintext request answered in plaintext: %q", got)
+	}
+
+	// Connections over the limit get 503 over TLS
+	statuses := make(chan int, 2)
+	for range 2 {
+		go func() {
+			status, _ := sendTLS("GET /slow.txt HTTP/1.0\r\n\r\n")
+			statuses <- status
+		}()
+	}
+	time.Sleep(100 * time.Millisecond) // both slow requests are being served
+	if status, err := sendTLS("GET /a.txt HTTP/1.0\r\n\r\n"); status != 503 {
+		t.Errorf("TLS request over the connection limit: got %d (%v), want 503", status, err)
+	}
+	close(release)
+	for range 2 {
+		if status := <-statuses; status != 200 {
+			t.Errorf("slow request: got %d, want 200", status)
+		}
+	}
+}
+
+func TestDiscardUnreadTLS(t *testing.T) {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{selfSigned(t)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.Read(make([]byte, 16)) // the request is only partly read
+		rejectRequest(conn, 431)
+	}()
+
+	conn, err := tls.Dial("tcp", ln.Addr().String(), &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /a.txt HTTP/1.0\r\nX-A: "+strings.Repeat("a", 64<<10)+"\r\n\r\n")
+
+	// The response ends with a close_notify while the server still drains the request
+	got, err := io.ReadAll(conn)
+	if err != nil || !strings.HasPrefix(string(got), "HTTP/1.0 431 ") {
+		t.Fatalf("got %q, %v; want a 431 response ended by close_notify", got, err)
+	}
+	select {
+	case <-returned:
+		t.Error("server closed the connection without draining the request")
+	default:
+	}
+	conn.Close()
+	<-returned
+}
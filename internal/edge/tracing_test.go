@@ -0,0 +1,177 @@
+package edge
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/cache"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/origin"
+	"cdn-edge-server/internal/tracing"
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startOrigin serves files from a temporary storage directory holding the given files with an
+// in-process origin server recording spans with tracer (if set), and routes every edge request
+// to it. It returns the origin server.
+func startOrigin(t *testing.T, files map[string]string, tracer *tracing.Tracer) *origin.Server {
+	t.Helper()
+	storage := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(storage, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reserve a port for the origin, which listens itself
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	host, port, _ := net.SplitHostPort(addr)
+
+	c, err := cache.New("", t.TempDir(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Init()
+	vh := &VirtualHost{Name: "www.test", Origin: addr, Cache: c}
+
+	prevStorage, prevVhosts := config.StorageDir, vhosts
+	config.StorageDir, vhosts = storage, &vhostTable{list: []*VirtualHost{vh}, def: vh}
+
+	srv := origin.NewServer(host, port)
+	srv.Tracer = tracer
+	done := make(chan error, 1)
+	go func() { done <- srv.ListenAndServe() }()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+		<-done
+		config.StorageDir, vhosts = prevStorage, prevVhosts
+	})
+
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("origin didn't start")
+		}
+	}
+	return srv
+}
+
+// readSpans returns the spans exported to the given OTLP JSON file.
+func readSpans(t *testing.T, file string) []tracing.SpanData {
+	t.Helper()
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var spans []tracing.SpanData
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var export tracing.ExportRequest
+		if err := json.Unmarshal(scanner.Bytes(), &export); err != nil {
+			t.Fatalf("invalid OTLP JSON in %s: %v", file, err)
+		}
+		for _, rs := range export.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				spans = append(spans, ss.Spans...)
+			}
+		}
+	}
+	return spans
+}
+
+func TestTracePropagation(t *testing.T) {
+	dir := t.TempDir()
+	edgeTraces, originTraces := filepath.Join(dir, "edge.jsonl"), filepath.Join(dir, "origin.jsonl")
+	edgeTracer, err := tracing.Open("edge", edgeTraces, "", 0) // only clients' sampled traces are recorded
+	if err != nil {
+		t.Fatal(err)
+	}
+	originTracer, err := tracing.Open("origin", originTraces, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := startOrigin(t, map[string]string{"a.txt": "a", "b.txt": "b", "c.txt": "c"}, originTracer)
+	edge, addr := startServer(t, NewHandler(), func(s *TCPServer) { s.Tracer = edgeTracer })
+
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		clientID = "00f067aa0ba902b7"
+	)
+	requests := []string{
+		"GET /a.txt HTTP/1.0\r\ntraceparent: 00-" + traceID + "-" + clientID + "-01\r\n\r\n",
+		"GET /b.txt HTTP/1.0\r\ntraceparent: 00-" + traceID + "-" + clientID + "-00\r\n\r\n", // not sampled
+		"GET /c.txt HTTP/1.0\r\ntraceparent: invalid\r\n\r\n",                                // new trace, not sampled
+		"GET /c.txt HTTP/1.0\r\n\r\n",
+	}
+	for _, r := range requests {
+		if status := roundTrip(t, addr, r); status != 200 {
+			t.Fatalf("%q: got %d, want 200", r, status)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	edge.Shutdown(ctx)
+	edgeTracer.Close()
+	srv.Shutdown(ctx)
+	originTracer.Close()
+
+	// The edge continues the client's trace, and the origin the edge's
+	byName := map[string]tracing.SpanData{}
+	for _, s := range readSpans(t, edgeTraces) {
+		if s.TraceID != traceID {
+			t.Errorf("edge span %q in trace %s, want only the client's sampled trace", s.Name, s.TraceID)
+		}
+		byName[s.Name] = s
+	}
+	server, exchange := byName["GET"], byName["upstream response"]
+	if server.Kind != tracing.Server || server.ParentSpanID != clientID {
+		t.Errorf("edge server span %+v, want a child of the client's span", server)
+	}
+	for _, name := range []string{"parse", "cache lookup", "upstream connect", "upstream response", "cache store", "write"} {
+		if s, ok := byName[name]; !ok || s.ParentSpanID != server.SpanID {
+			t.Errorf("edge span %q missing or not a child of the server span: %+v", name, s)
+		}
+	}
+	if exchange.Kind != tracing.Client {
+		t.Errorf("upstream response span kind %d, want client", exchange.Kind)
+	}
+	attrs := map[string]string{}
+	for _, kv := range server.Attributes {
+		attrs[kv.Key] = kv.Value.String()
+	}
+	if attrs["url.path"] != "/a.txt" || attrs["http.response.status_code"] != "200" || attrs["edge.cache_status"] != "MISS" {
+		t.Errorf("edge server span attributes %v", attrs)
+	}
+
+	var originServer []tracing.SpanData
+	for _, s := range readSpans(t, originTraces) {
+		if s.Kind == tracing.Server {
+			originServer = append(originServer, s)
+		}
+	}
+	if len(originServer) != 1 {
+		t.Fatalf("got %d origin server spans, want 1 (the sampled request's)", len(originServer))
+	}
+	if s := originServer[0]; s.TraceID != traceID || s.ParentSpanID != exchange.SpanID {
+		t.Errorf("origin span %+v, want a child of the edge's upstream response span %s", s, exchange.SpanID)
+	}
+}
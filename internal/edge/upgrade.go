@@ -0,0 +1,128 @@
+//go:build unix
+
+package edge
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const (
+	listenFDEnv    = "EDGE_LISTEN_FD" // inherited listening socket (set by the parent on upgrade)
+	readyFDEnv     = "EDGE_READY_FD"  // pipe the child writes to once it is accepting connections
+	upgradeTimeout = 10 * time.Second // how long the parent waits for the child to become ready
+)
+
+// UpgradeSignal triggers a zero-downtime restart (see TCPServer.Upgrade).
+var UpgradeSignal os.Signal = syscall.SIGUSR2
+
+// listen returns the listening socket inherited from a parent edge process if there is one,
+// otherwise a new listener on the given address.
+func listen(addr string) (net.Listener, error) {
+	fdStr := os.Getenv(listenFDEnv)
+	if fdStr == "" {
+		return net.Listen("tcp", addr)
+	}
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %q", listenFDEnv, fdStr)
+	}
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close() // FileListener dups the descriptor
+
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("inherit listener: %w", err)
+	}
+	slog.Info("Inherited listening socket from parent process")
+	return ln, nil
+}
+
+// notifyReady tells the parent edge process (if this process was started by an upgrade)
+// that the inherited listener is being served, so the parent can start draining.
+func notifyReady() {
+	fdStr := os.Getenv(readyFDEnv)
+	if fdStr == "" {
+		return
+	}
+	if fd, err := strconv.Atoi(fdStr); err == nil {
+		f := os.NewFile(uintptr(fd), "ready")
+		f.Write([]byte{1})
+		f.Close()
+	}
+
+	// Not meant for our own children
+	os.Unsetenv(listenFDEnv)
+	os.Unsetenv(readyFDEnv)
+}
+
+// Upgrade starts a new edge process from the current executable and hands it the server's
+// listening socket. Both processes accept connections from the shared socket until the
+// caller shuts this server down, so no connection is refused during the swap.
+// It returns once the child is serving, or an error if it failed to start in time (in which
+// case this server keeps running untouched).
+func (s *TCPServer) Upgrade() (*os.Process, error) {
+	tcpLn, ok := s.conns.Listener().(*net.TCPListener)
+	if !ok {
+		return nil, errors.New("upgrade: server is not listening on a TCP socket")
+	}
+	lnFile, err := tcpLn.File()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: %w", err)
+	}
+	defer lnFile.Close()
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: %w", err)
+	}
+	defer readyR.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		readyW.Close()
+		return nil, fmt.Errorf("upgrade: %w", err)
+	}
+
+	// ExtraFiles[i] becomes descriptor 3+i in the child
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{lnFile, readyW}
+	cmd.Env = append(os.Environ(), listenFDEnv+"=3", readyFDEnv+"=4")
+
+	err = cmd.Start()
+	readyW.Close() // only the child holds the write end now
+	if err != nil {
+		return nil, fmt.Errorf("upgrade: start child: %w", err)
+	}
+
+	// The child writes one byte once it is serving; if it exits first, the read returns EOF instead
+	ready := make(chan bool, 1)
+	go func() {
+		n, _ := readyR.Read(make([]byte, 1))
+		ready <- n == 1
+	}()
+	go cmd.Wait() // reap the child if it exits while we are still around
+
+	select {
+	case ok := <-ready:
+		if !ok {
+			return nil, errors.New("upgrade: child exited before serving")
+		}
+	case <-time.After(upgradeTimeout):
+		cmd.Process.Kill()
+		return nil, errors.New("upgrade: child did not become ready in time")
+	}
+
+	slog.Info("Upgraded: new edge process is serving", "pid", cmd.Process.Pid)
+	return cmd.Process, nil
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package edge
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// UpgradeSignal is nil where zero-downtime restarts aren't supported (no signal triggers one).
+var UpgradeSignal os.Signal
+
+func listen(addr string) (net.Listener, error) {
+	return net.Listen("tcp", addr)
+}
+
+func notifyReady() {}
+
+// Upgrade is only supported on Unix, where listening sockets can be passed to a child process.
+func (s *TCPServer) Upgrade() (*os.Process, error) {
+	return nil, errors.New("upgrade: not supported on this platform")
+}
@@ -0,0 +1,126 @@
+//go:build unix
+
+package edge
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv(listenFDEnv) != "" {
+		// Started by TestUpgrade's handoff: serve the inherited listener as the new process
+		time.AfterFunc(30*time.Second, func() { os.Exit(0) }) // in case the test died
+		s := NewTCPServer("127.0.0.1", "0", servedBy("child"))
+		fmt.Fprintln(os.Stderr, s.ListenAndServe())
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// servedBy answers every request with an X-Served-By header naming the given process.
+func servedBy(name string) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		w.WriteResponse(http.BuildResponse(200, "text/plain", []byte(name)).WithHeader("X-Served-By", name))
+	})
+}
+
+func TestUpgrade(t *testing.T) {
+	parent, addr := startServer(t, servedBy("parent"), nil)
+
+	// Clients keep connecting throughout the handoff
+	var served sync.Map // process name → *atomic.Int64
+	var failures atomic.Int64
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				name, err := servedByRequest(addr)
+				if err != nil {
+					failures.Add(1)
+					t.Errorf("request failed during the handoff: %v", err)
+					continue
+				}
+				n, _ := served.LoadOrStore(name, new(atomic.Int64))
+				n.(*atomic.Int64).Add(1)
+			}
+		}()
+	}
+	count := func(name string) int64 {
+		if n, ok := served.Load(name); ok {
+			return n.(*atomic.Int64).Load()
+		}
+		return 0
+	}
+	waitFor := func(what string, cond func() bool) {
+		for deadline := time.Now().Add(10 * time.Second); !cond(); time.Sleep(5 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				close(stop)
+				wg.Wait()
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	waitFor("the parent to serve", func() bool { return count("parent") >= 20 })
+	child, err := parent.Upgrade()
+	if err != nil {
+		close(stop)
+		wg.Wait()
+		t.Fatal(err)
+	}
+	defer child.Kill()
+
+	// As after the upgrade signal: the parent drains while the child serves
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := parent.Shutdown(ctx); err != nil {
+		t.Errorf("parent didn't drain: %v", err)
+	}
+	waitFor("the child to serve", func() bool { return count("child") >= 50 })
+	close(stop)
+	wg.Wait()
+
+	if n := failures.Load(); n > 0 {
+		t.Errorf("%d requests refused or dropped", n)
+	}
+	t.Logf("parent served %d requests, child %d", count("parent"), count("child"))
+}
+
+// servedByRequest sends a request to addr and returns the process that answered it.
+func servedByRequest(addr string) (string, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := io.WriteString(conn, "GET /a.txt HTTP/1.0\r\n\r\n"); err != nil {
+		return "", err
+	}
+	resp, err := http.ParseResp(bufio.NewReader(conn))
+	if resp == nil {
+		return "", fmt.Errorf("no response: %v", err)
+	}
+	if resp.Status != 200 {
+		return "", fmt.Errorf("got status %d", resp.Status)
+	}
+	return resp.Headers["X-Served-By"], nil
+}
@@ -0,0 +1,195 @@
+package edge
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/cache"
+	"cdn-edge-server/internal/config"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// VirtualHost is a site served by the edge: requests whose Host header names it are fetched
+// from its origin and cached in its own cache namespace.
+type VirtualHost struct {
+	Name   string // hostname, also sent as Host to the origin
+	Origin string // origin address (host:port)
+	Cache  *cache.Cache
+	hot    *hotKeys // most requested files, if WARM_HOT_KEYS is set
+}
+
+// vhostTable maps hostnames to virtual hosts. With no table configured, byName is nil and
+// every request goes to the default host.
+type vhostTable struct {
+	byName map[string]*VirtualHost
+	list   []*VirtualHost // in config order
+	def    *VirtualHost   // serves requests without a Host header
+}
+
+var vhosts *vhostTable
+
+// routeVirtualHost sets the virtual host named by each request's Host header, or answers
+// requests for unknown hosts with 404.
+func routeVirtualHost(next Handler) Handler {
+	return HandlerFunc(func(w ResponseWriter, req *Request) {
+		req.VHost = vhosts.lookup(req.Header("Host"))
+		if req.VHost == nil {
+			slog.Debug("Unknown virtual host", "host", req.Header("Host"), "method", req.Method, "path", req.Path)
+			writeError(w, 404)
+			return
+		}
+		next.ServeEdge(w, req)
+	})
+}
+
+// LoadVirtualHosts reads the virtual host table from config.VHostsFile (or sets up the single
+// implicit host for config's origin if unset) and loads each host's cache.
+func LoadVirtualHosts() error {
+	var table *vhostTable
+	var err error
+	if config.VHostsFile == "" {
+		table, err = implicitVhost()
+	} else {
+		table, err = loadVhostsFile(config.VHostsFile, config.VHostDefault)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, vh := range table.list {
+		vh.Cache.Init()
+	}
+	vhosts = table
+	return nil
+}
+
+// FlushCaches persists the FIFO order of every virtual host's cache.
+func FlushCaches() error {
+	var errs []error
+	for _, vh := range vhosts.list {
+		if err := vh.Cache.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", vh.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// implicitVhost returns the table used without a vhosts file: every request, whatever its
+// Host, is served from config's origin and cached directly in config.CacheDir.
+func implicitVhost() (*vhostTable, error) {
+	c, err := cache.New("", config.CacheDir, config.CacheMaxFiles)
+	if err != nil {
+		return nil, err
+	}
+	vh := &VirtualHost{
+		Name:   config.OriginHost,
+		Origin: net.JoinHostPort(config.OriginHost, config.OriginPort),
+		Cache:  c,
+		hot:    newHotKeys(config.WarmHotKeys),
+	}
+	return &vhostTable{list: []*VirtualHost{vh}, def: vh}, nil
+}
+
+// loadVhostsFile parses a virtual host table, one "<host> <origin host:port> [max cached files]"
+// per line ('#' starts a comment). Each host is cached in its own directory under config.CacheDir.
+// The default host is defaultName, or the first one in the file if empty.
+func loadVhostsFile(path, defaultName string) (*vhostTable, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	table := &vhostTable{byName: make(map[string]*VirtualHost)}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("%s:%d: expected <host> <origin host:port> [max cached files]", path, lineNo)
+		}
+
+		name := strings.ToLower(fields[0])
+		if !validHostname(name) {
+			return nil, fmt.Errorf("%s:%d: invalid host %q", path, lineNo, fields[0])
+		}
+		if table.byName[name] != nil {
+			return nil, fmt.Errorf("%s:%d: duplicate host %q", path, lineNo, name)
+		}
+		if _, _, err := net.SplitHostPort(fields[1]); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid origin %q: %v", path, lineNo, fields[1], err)
+		}
+
+		capacity := config.CacheMaxFiles
+		if len(fields) == 3 {
+			capacity, err = strconv.Atoi(fields[2])
+			if err != nil || capacity < 1 {
+				return nil, fmt.Errorf("%s:%d: invalid max cached files %q", path, lineNo, fields[2])
+			}
+		}
+
+		c, err := cache.New(name, filepath.Join(config.CacheDir, name), capacity)
+		if err != nil {
+			return nil, err
+		}
+		vh := &VirtualHost{Name: name, Origin: fields[1], Cache: c, hot: newHotKeys(config.WarmHotKeys)}
+		table.byName[name] = vh
+		table.list = append(table.list, vh)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(table.list) == 0 {
+		return nil, fmt.Errorf("%s: no virtual hosts", path)
+	}
+
+	table.def = table.list[0]
+	if defaultName != "" {
+		if table.def = table.byName[strings.ToLower(defaultName)]; table.def == nil {
+			return nil, fmt.Errorf("default host %q is not in %s", defaultName, path)
+		}
+	}
+	return table, nil
+}
+
+// validHostname reports whether name is a plain DNS name, safe to use as a directory name.
+func validHostname(name string) bool {
+	if name == "" || name[0] == '.' || strings.Contains(name, "..") {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// virtualHosts returns every virtual host (none before LoadVirtualHosts).
+func virtualHosts() []*VirtualHost {
+	if vhosts == nil {
+		return nil
+	}
+	return vhosts.list
+}
+
+// lookup returns the virtual host the given Host header value names (port and trailing dot
+// ignored), the default host if it is empty, or nil if the host is unknown.
+func (t *vhostTable) lookup(host string) *VirtualHost {
+	if t.byName == nil || host == "" {
+		return t.def
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	return t.byName[host]
+}
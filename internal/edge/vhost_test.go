@@ -0,0 +1,40 @@
+package edge
+
+import "testing"
+
+func TestRouteVirtualHost(t *testing.T) {
+	a := &VirtualHost{Name: "a.example"}
+	b := &VirtualHost{Name: "b.example"}
+	defer func(prev *vhostTable) { vhosts = prev }(vhosts)
+	vhosts = &vhostTable{
+		byName: map[string]*VirtualHost{"a.example": a, "b.example": b},
+		list:   []*VirtualHost{a, b},
+		def:    a,
+	}
+
+	tests := []struct {
+		headers map[string]string
+		status  int
+		vhost   string
+	}{
+		{map[string]string{"Host": "b.example"}, 200, "b.example"},
+		{map[string]string{"host": "b.example"}, 200, "b.example"}, // header names are case-insensitive
+		{map[string]string{"HOST": "B.Example:8080"}, 200, "b.example"},
+		{map[string]string{"Host": "b.example."}, 200, "b.example"},
+		{nil, 200, "a.example"}, // no Host: default host
+		{map[string]string{"host": "c.example"}, 404, ""},
+	}
+	for _, tt := range tests {
+		routed := ""
+		record := func(next Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, req *Request) {
+				routed = req.VHost.Name
+				next.ServeEdge(w, req)
+			})
+		}
+		status, _ := serveThrough(t, "127.0.0.1", "GET", "/a.txt", tt.headers, routeVirtualHost, record)
+		if status != tt.status || routed != tt.vhost {
+			t.Errorf("headers %v: got %d routed to %q, want %d routed to %q", tt.headers, status, routed, tt.status, tt.vhost)
+		}
+	}
+}
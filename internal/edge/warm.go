@@ -0,0 +1,349 @@
+package edge
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/config"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warmJob is a cache warm-up of one virtual host: files of a manifest fetched from the origin
+// into free cache slots, so the origin isn't stampeded by a cold cache.
+type warmJob struct {
+	Host     string     `json:"host"`
+	Source   string     `json:"source"` // "manifest", "hot keys" or "admin"
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished"` // null while running
+	Total    int        `json:"total"`    // files in the manifest
+	Done     int        `json:"done"`     // files processed so far
+	Cached   int        `json:"cached"`   // fetched and cached
+	Present  int        `json:"already_cached"`
+	Failed   int        `json:"failed"`  // origin unreachable or not 200
+	NoRoom   int        `json:"no_room"` // skipped so as not to evict cached files
+}
+
+var (
+	warmMu   sync.Mutex              // guards warmJobs and the jobs' fields
+	warmJobs = map[string]*warmJob{} // host → its last warm-up
+)
+
+// warmJobsStatus returns a copy of the last warm-up of every host that had one.
+func warmJobsStatus() []warmJob {
+	warmMu.Lock()
+	defer warmMu.Unlock()
+
+	jobs := []warmJob{}
+	for _, vh := range virtualHosts() {
+		if j := warmJobs[vh.Name]; j != nil {
+			jobs = append(jobs, *j)
+		}
+	}
+	return jobs
+}
+
+// warmRunning reports whether the virtual host's cache is being warmed.
+func warmRunning(vh *VirtualHost) bool {
+	warmMu.Lock()
+	defer warmMu.Unlock()
+	j := warmJobs[vh.Name]
+	return j != nil && j.Finished == nil
+}
+
+// startWarm starts warming the virtual host's cache with the given files (most important
+// first) in the background, returning false if a warm-up of the host is already running.
+func startWarm(vh *VirtualHost, files []string, source string) (*warmJob, bool) {
+	warmMu.Lock()
+	defer warmMu.Unlock()
+
+	if j := warmJobs[vh.Name]; j != nil && j.Finished == nil {
+		return j, false
+	}
+	j := &warmJob{Host: vh.Name, Source: source, Started: time.Now(), Total: len(files)}
+	warmJobs[vh.Name] = j
+	go j.run(vh, files)
+	return j, true
+}
+
+// run fetches the files with config.WarmConcurrency workers, at most config.WarmRate per second,
+// into the free slots of the cache (fetching no more files than there are free slots).
+func (j *warmJob) run(vh *VirtualHost, files []string) {
+	slog.Info("Cache warm-up started", "host", vh.Name, "source", j.Source, "files", len(files))
+
+	stats := vh.Cache.Stats()
+	room := newWarmSlots(stats.Capacity - stats.Entries)
+
+	var interval time.Duration
+	if config.WarmRate > 0 {
+		interval = time.Duration(float64(time.Second) / config.WarmRate)
+	}
+	var pace sync.Mutex // spaces fetches by interval
+	next := time.Now()
+
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for range max(1, config.WarmConcurrency) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range queue {
+				result := j.warm(vh, name, room, func() {
+					pace.Lock()
+					wait := time.Until(next)
+					next = time.Now().Add(max(wait, 0) + interval)
+					pace.Unlock()
+					time.Sleep(wait)
+				})
+
+				warmMu.Lock()
+				*result++
+				j.Done++
+				warmMu.Unlock()
+			}
+		}()
+	}
+	for _, name := range files {
+		queue <- name
+	}
+	close(queue)
+	wg.Wait()
+
+	warmMu.Lock()
+	now := time.Now()
+	j.Finished = &now
+	done := *j
+	warmMu.Unlock()
+	slog.Info("Cache warm-up finished", "host", vh.Name, "source", done.Source, "cached", done.Cached,
+		"already_cached", done.Present, "failed", done.Failed, "no_room", done.NoRoom,
+		"duration", now.Sub(done.Started).Round(time.Millisecond))
+}
+
+// warm fetches one file into the cache, after waiting its turn, if it isn't cached and a slot
+// can be reserved for it. It returns the counter of the outcome (to increment under warmMu).
+func (j *warmJob) warm(vh *VirtualHost, name string, room *warmSlots, wait func()) *int {
+	if vh.Cache.Has(name) {
+		return &j.Present
+	}
+	if !room.reserve() {
+		return &j.NoRoom
+	}
+
+	wait()
+	gen := vh.Cache.Generation(name)
+	resp, err := fetchFromOrigin(nil, vh, "GET", name, nil)
+	if err != nil || resp.Status != 200 {
+		slog.Debug("Cache warm-up fetch failed", "host", vh.Name, "file", name, "err", err)
+		room.release(false)
+		return &j.Failed
+	}
+	room.release(true)
+
+	// Client requests may have filled the cache meanwhile
+	if stats := vh.Cache.Stats(); stats.Entries >= stats.Capacity && !vh.Cache.Has(name) {
+		return &j.NoRoom
+	}
+	if err := vh.Cache.AddIfCurrent(name, resp.Body, gen); err != nil {
+		slog.Debug("Cache warm-up store failed", "host", vh.Name, "file", name, "err", err)
+		return &j.Failed
+	}
+	return &j.Cached
+}
+
+// warmSlots counts the free cache slots a warm-up may fill, so it never evicts cached files.
+type warmSlots struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	free    int // slots not reserved
+	pending int // reserved by fetches in flight
+}
+
+func newWarmSlots(free int) *warmSlots {
+	s := &warmSlots{free: max(free, 0)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// reserve reserves a slot, waiting for the fetches in flight if none is free (as a failed
+// one gives its slot back), and returns false if there is no room left.
+func (s *warmSlots) reserve() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.free == 0 && s.pending > 0 {
+		s.cond.Wait()
+	}
+	if s.free == 0 {
+		return false
+	}
+	s.free--
+	s.pending++
+	return true
+}
+
+// release ends a reservation: its slot is filled if the file was fetched, or free again.
+func (s *warmSlots) release(filled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending--
+	if !filled {
+		s.free++
+	}
+	s.cond.Broadcast()
+}
+
+// parseManifest reads a warm-up manifest: one "[<host> ]<file>" per line, most important
+// first ('#' starts a comment). Files without a host are for defaultHost. It returns the files
+// of each host, without duplicates, in manifest order.
+func parseManifest(r io.Reader, defaultHost *VirtualHost) (map[*VirtualHost][]string, error) {
+	files := make(map[*VirtualHost][]string)
+	seen := make(map[*VirtualHost]map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		vh := defaultHost
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+		case 2:
+			if vh = vhosts.lookup(fields[0]); vh == nil {
+				return nil, fmt.Errorf("line %d: unknown host %q", lineNo, fields[0])
+			}
+		default:
+			return nil, fmt.Errorf("line %d: expected [<host> ]<file>", lineNo)
+		}
+
+		name := filepath.Base(fields[len(fields)-1]) // cached by file name, as requests are
+		if seen[vh] == nil {
+			seen[vh] = make(map[string]bool)
+		}
+		if !seen[vh][name] {
+			seen[vh][name] = true
+			files[vh] = append(files[vh], name)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// WarmOnStartup starts warming each virtual host's cache with the files of config.WarmManifest,
+// then the hot keys saved by the previous run (if config.WarmHotKeys is set), if any.
+func WarmOnStartup() error {
+	files := make(map[*VirtualHost][]string)
+	var sources []string
+	load := func(path, source string) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		m, err := parseManifest(f, vhosts.def)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for vh, names := range m {
+			files[vh] = append(files[vh], names...) // duplicates are counted as already cached
+		}
+		sources = append(sources, source)
+		return nil
+	}
+
+	if config.WarmManifest != "" {
+		if err := load(config.WarmManifest, "manifest"); err != nil {
+			return err
+		}
+	}
+	if config.WarmHotKeys > 0 {
+		if err := load(config.WarmHotKeysFile, "hot keys"); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	for _, vh := range virtualHosts() {
+		if len(files[vh]) > 0 {
+			startWarm(vh, files[vh], strings.Join(sources, "+"))
+		}
+	}
+	return nil
+}
+
+// hotKeys estimates a virtual host's most requested files with the Space-Saving algorithm:
+// it counts up to max files, and a file not counted yet replaces the least counted one,
+// inheriting its count (so counts may overestimate, but the hottest files stay).
+type hotKeys struct {
+	mu     sync.Mutex
+	max    int
+	counts map[string]uint64
+}
+
+// newHotKeys returns a counter precise enough to find the top n files (nil if n is 0).
+func newHotKeys(n int) *hotKeys {
+	if n <= 0 {
+		return nil
+	}
+	return &hotKeys{max: 10 * n, counts: make(map[string]uint64)}
+}
+
+// record counts a request for the given file.
+func (h *hotKeys) record(name string) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.counts[name]; !ok && len(h.counts) >= h.max {
+		var minName string
+		var minCount uint64
+		for n, c := range h.counts {
+			if minName == "" || c < minCount {
+				minName, minCount = n, c
+			}
+		}
+		delete(h.counts, minName)
+		h.counts[name] = minCount
+	}
+	h.counts[name]++
+}
+
+// top returns the n most requested files, most requested first.
+func (h *hotKeys) top(n int) []string {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	names := make([]string, 0, len(h.counts))
+	for name := range h.counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if h.counts[names[i]] != h.counts[names[j]] {
+			return h.counts[names[i]] > h.counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names[:min(n, len(names))]
+}
+
+// SaveHotKeys writes the config.WarmHotKeys most requested files of each virtual host to
+// config.WarmHotKeysFile, as a warm-up manifest for the next start (if enabled).
+func SaveHotKeys() error {
+	if config.WarmHotKeys <= 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Most requested files per host, saved %s\n", time.Now().Format(time.RFC3339))
+	for _, vh := range virtualHosts() {
+		for _, name := range vh.hot.top(config.WarmHotKeys) {
+			fmt.Fprintf(&b, "%s %s\n", vh.Name, name)
+		}
+	}
+	return os.WriteFile(config.WarmHotKeysFile, []byte(b.String()), 0644)
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+// ConnTracker keeps track of a server's listener and in-flight connections, so the server can
+// stop accepting and drain them on shutdown. The zero value is ready to use.
+type ConnTracker struct {
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{} // in-flight connections
+	wg       sync.WaitGroup        // one per in-flight connection
+	closing  bool
+	stopped  chan struct{} // closed once the accept loop has exited
+}
+
+// Serve registers the listener of an accept loop about to start, and returns a function to
+// call once the loop has exited. If Close was already called, it closes the listener and
+// returns false instead.
+func (t *ConnTracker) Serve(listener net.Listener) (stopped func(), ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closing {
+		listener.Close()
+		return nil, false
+	}
+	t.listener = listener
+	done := make(chan struct{})
+	t.stopped = done
+	return func() { close(done) }, true
+}
+
+// Listener returns the listener registered by Serve (nil if none).
+func (t *ConnTracker) Listener() net.Listener {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.listener
+}
+
+// Closing reports whether Close was called.
+func (t *ConnTracker) Closing() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closing
+}
+
+// Track registers an accepted connection as in-flight, unless max connections (if > 0) already
+// are. Each tracked connection must be released with Untrack.
+func (t *ConnTracker) Track(conn net.Conn, max int) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if max > 0 && len(t.conns) >= max {
+		return false
+	}
+	if t.conns == nil {
+		t.conns = make(map[net.Conn]struct{})
+	}
+	t.conns[conn] = struct{}{}
+	t.wg.Add(1)
+	return true
+}
+
+// Untrack releases a connection registered by Track.
+func (t *ConnTracker) Untrack(conn net.Conn) {
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+	t.wg.Done()
+}
+
+// Active returns the number of in-flight connections.
+func (t *ConnTracker) Active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// Close stops accepting: it closes the listener and waits for the accept loop to exit.
+func (t *ConnTracker) Close() {
+	t.mu.Lock()
+	t.closing = true
+	stopped := t.stopped
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	t.mu.Unlock()
+
+	// Connections accepted before the listener closed are still served, and tracked
+	// by the time the accept loop exits
+	if stopped != nil {
+		<-stopped
+	}
+}
+
+// Drain waits for in-flight connections to finish. If ctx expires first, the remaining
+// connections are closed and ctx's error is returned.
+func (t *ConnTracker) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		for conn := range t.conns {
+			conn.Close()
+		}
+		t.mu.Unlock()
+		return ctx.Err()
+	}
+}
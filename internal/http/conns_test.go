@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnTracker(t *testing.T) {
+	var tr ConnTracker
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped, ok := tr.Serve(ln)
+	if !ok {
+		t.Fatal("Serve before Close: not ok")
+	}
+
+	a, b := net.Pipe()
+	defer b.Close()
+	if !tr.Track(a, 1) {
+		t.Fatal("Track under the limit: rejected")
+	}
+	c, d := net.Pipe()
+	defer d.Close()
+	if tr.Track(c, 1) {
+		t.Error("Track at the limit: accepted")
+	}
+	if n := tr.Active(); n != 1 {
+		t.Errorf("Active() = %d, want 1", n)
+	}
+
+	// Close waits for the accept loop, which exits once the listener is closed
+	go func() {
+		for {
+			if _, err := ln.Accept(); err != nil {
+				stopped()
+				return
+			}
+		}
+	}()
+	tr.Close()
+	if !tr.Closing() {
+		t.Error("Closing() = false after Close")
+	}
+
+	// The in-flight connection outlives the deadline, and is closed
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tr.Drain(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Drain() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := a.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("write to drained connection: %v, want closed", err)
+	}
+	tr.Untrack(a)
+	if err := tr.Drain(context.Background()); err != nil {
+		t.Errorf("Drain() with no connections = %v", err)
+	}
+
+	// Serving after Close closes the listener
+	ln2, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tr.Serve(ln2); ok {
+		t.Error("Serve after Close: ok")
+	}
+	if _, err := ln2.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("Accept on listener passed after Close: %v, want closed", err)
+	}
+}
@@ -2,18 +2,23 @@ package http
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"path"
 	"strconv"
 	"strings"
 )
 
 type Request struct {
-	Method  string
-	Path    string
-	Version string
-	Headers map[string]string
-	Body    []byte // NEEDED FOR POST/PUT !!
+	Method   string
+	Path     string // without the query string
+	RawQuery string // query string, without the '?'
+	Query    url.Values
+	Version  string
+	Headers  map[string]string
+	Body     []byte // NEEDED FOR POST/PUT !!
 }
 
 type Response struct {
@@ -24,18 +29,57 @@ type Response struct {
 	Body       []byte
 }
 
+// Limits bounds how much of a request the parser will read. Zero means unlimited.
+type Limits struct {
+	MaxHeaderBytes int   // request line + headers, including line endings
+	MaxHeaderCount int   // number of header lines
+	MaxBodyBytes   int64 // declared Content-Length
+}
+
+// DefaultLimits are used by ParseReq; generous, but enough to stop a peer from exhausting memory.
+var DefaultLimits = Limits{
+	MaxHeaderBytes: 1 << 20,
+	MaxHeaderCount: 1000,
+}
+
+var (
+	ErrHeaderTooLarge = errors.New("request header too large")     // answer with 431
+	ErrTooManyHeaders = errors.New("too many request headers")     // answer with 431
+	ErrBodyTooLarge   = errors.New("request body too large")       // answer with 413
+	ErrBadLength      = errors.New("invalid Content-Length value") // answer with 400
+
+	ErrResponseTooLarge = errors.New("response exceeds size limits")
+	ErrBadStatusLine    = errors.New("malformed response status line")
+)
+
 // ParseReq reads an HTTP request from the given bufio.Reader and parses the request line
-// and headers until it encounters a blank line.
+// and headers until it encounters a blank line, then the body (for POST/PUT requests).
 // Returns a populated Request on success, nil if the request is empty or malformed,
 // and an error if the reader encounters an I/O issue.
-// TODO: ADD BODY PARSING (for PUSH/PUT requests)
 func ParseReq(reader *bufio.Reader) (*Request, error) {
+	req, err := ParseReqHead(reader, DefaultLimits)
+	if err != nil || req == nil {
+		return nil, err
+	}
+	if err := req.ReadBody(reader, DefaultLimits); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// ParseReqHead reads and parses the request line and headers from the given bufio.Reader,
+// leaving the body unread (see ReadBody), so callers can apply separate read deadlines.
+// Returns nil if the request is empty or malformed, and ErrHeaderTooLarge or ErrTooManyHeaders
+// if the head exceeds the given limits.
+func ParseReqHead(reader *bufio.Reader, limits Limits) (*Request, error) {
 	var lines []string
+	headerBytes := 0
 	for {
-		line, err := reader.ReadString('\n')
+		line, err := readLine(reader, limits.MaxHeaderBytes-headerBytes, limits.MaxHeaderBytes > 0)
 		if err != nil {
 			return nil, err
 		}
+		headerBytes += len(line)
 
 		line = strings.TrimSpace(line)
 		if line == "" {
@@ -43,6 +87,9 @@ func ParseReq(reader *bufio.Reader) (*Request, error) {
 		}
 
 		lines = append(lines, line)
+		if limits.MaxHeaderCount > 0 && len(lines)-1 > limits.MaxHeaderCount {
+			return nil, ErrTooManyHeaders
+		}
 	}
 
 	if len(lines) == 0 {
@@ -58,7 +105,8 @@ func ParseReq(reader *bufio.Reader) (*Request, error) {
 	}
 
 	method := parts[0]
-	path := parts[1]
+	path, rawQuery, _ := strings.Cut(parts[1], "?")
+	query, _ := url.ParseQuery(rawQuery) // keep what parses, ignore malformed pairs
 	version := parts[2]
 	headers := make(map[string]string)
 	for _, h := range lines[1:] {
@@ -72,40 +120,130 @@ func ParseReq(reader *bufio.Reader) (*Request, error) {
 		headers[key] = value
 	}
 
-	req := &Request{
-		Method:  method,
-		Path:    path,
-		Version: version,
-		Headers: headers,
-		Body:    nil,
-	}
-
-	// Parse body (for POST, PUT requests)
-	if cl, ok := headers["Content-Length"]; ok {
-		n, _ := strconv.Atoi(cl)
-		if n > 0 {
-			body := make([]byte, n)
-			_, err := io.ReadFull(reader, body)
-			if err != nil {
-				return nil, err
-			}
-			req.Body = body
+	return &Request{
+		Method:   method,
+		Path:     path,
+		RawQuery: rawQuery,
+		Query:    query,
+		Version:  version,
+		Headers:  headers,
+		Body:     nil,
+	}, nil
+}
+
+// StripQuery removes the given parameters from the request's query string.
+func (req *Request) StripQuery(names ...string) {
+	for _, name := range names {
+		req.Query.Del(name)
+	}
+	req.RawQuery = req.Query.Encode()
+}
+
+// ContentPath returns the path of the file the given request path names, "/<file name>":
+// files are stored flat, by name, so "/a/logo.png" and "/b/logo.png" are the same file.
+// Rules scoped to paths must match this path, not the request's.
+func ContentPath(p string) string {
+	name := path.Base(p)
+	if name == "/" || name == "." {
+		return "/"
+	}
+	return "/" + name
+}
+
+// CheckContentPrefix returns an error if rules scoped to the given path prefix can't be
+// enforced on content paths (see ContentPath), i.e. unless it is "/" followed by the start of
+// a file name, without directories, e.g. "/private-".
+func CheckContentPrefix(prefix string) error {
+	if !strings.HasPrefix(prefix, "/") || strings.Contains(prefix[1:], "/") {
+		return fmt.Errorf("invalid path prefix %q: files are stored by name, so a prefix must be \"/\" followed by the start of a file name (e.g. \"/private-\")", prefix)
+	}
+	return nil
+}
+
+// Header returns the value of the given header, matching its name case-insensitively
+// ("" if absent).
+func (req *Request) Header(name string) string {
+	if v, ok := req.Headers[name]; ok {
+		return v
+	}
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, name) {
+			return v
 		}
 	}
+	return ""
+}
 
-	return req, nil
+// ReadBody reads the request body (for POST, PUT requests) from the given reader according
+// to the request's Content-Length. It returns ErrBodyTooLarge without reading anything if
+// the declared length exceeds the given limits.
+func (req *Request) ReadBody(reader *bufio.Reader, limits Limits) error {
+	cl, ok := req.Headers["Content-Length"]
+	if !ok {
+		return nil
+	}
+
+	n, err := strconv.ParseInt(cl, 10, 64)
+	if err != nil || n < 0 {
+		return ErrBadLength
+	}
+	if limits.MaxBodyBytes > 0 && n > limits.MaxBodyBytes {
+		return ErrBodyTooLarge
+	}
+	if n == 0 {
+		return nil
+	}
+
+	body := make([]byte, n)
+	if _, err := io.ReadFull(reader, body); err != nil {
+		return err
+	}
+	req.Body = body
+	return nil
+}
+
+// readLine reads a single line (including its '\n') from the given reader. If capped, it
+// returns ErrHeaderTooLarge as soon as the line grows past max bytes, without buffering the rest.
+func readLine(reader *bufio.Reader, max int, capped bool) (string, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if capped && len(line) > max {
+			return "", ErrHeaderTooLarge
+		}
+		if err == bufio.ErrBufferFull {
+			continue // line longer than the reader's buffer, keep reading
+		}
+		if err != nil {
+			return "", err
+		}
+		return string(line), nil
+	}
 }
 
 // ParseResp reads an HTTP response from the given bufio.Reader and parses it, returning
 // a Response struct. It also returns an error if the status line is of an invalid format,
 // or if an error occurred while attempting to parse the response body.
 func ParseResp(reader *bufio.Reader) (*Response, error) {
+	return ParseRespLimits(reader, DefaultLimits)
+}
+
+// ParseRespLimits is like ParseResp, but returns ErrResponseTooLarge as soon as the response
+// exceeds the given limits (MaxBodyBytes bounding its Content-Length), so a misbehaving peer
+// can't exhaust memory. A body over the limit isn't read, but the parsed head is returned.
+func ParseRespLimits(reader *bufio.Reader, limits Limits) (*Response, error) {
 	var lines []string
+	headerBytes := 0
 	for {
-		line, err := reader.ReadString('\n')
+		line, err := readLine(reader, limits.MaxHeaderBytes-headerBytes, limits.MaxHeaderBytes > 0)
+		if errors.Is(err, ErrHeaderTooLarge) {
+			return nil, ErrResponseTooLarge
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse response status line: %w", err)
 		}
+		headerBytes += len(line)
 
 		line = strings.TrimSpace(line)
 		if line == "" {
@@ -113,9 +251,18 @@ func ParseResp(reader *bufio.Reader) (*Response, error) {
 		}
 
 		lines = append(lines, line)
+		if limits.MaxHeaderCount > 0 && len(lines)-1 > limits.MaxHeaderCount {
+			return nil, ErrResponseTooLarge
+		}
 	}
 
+	if len(lines) == 0 {
+		return nil, ErrBadStatusLine
+	}
 	parts := strings.Split(lines[0], " ")
+	if len(parts) < 2 {
+		return nil, ErrBadStatusLine
+	}
 	ver := parts[0]
 	statCode, _ := strconv.Atoi(parts[1])
 	statTxt := strings.Join(parts[2:], "")
@@ -152,6 +299,9 @@ func ParseResp(reader *bufio.Reader) (*Response, error) {
 	if contentLength == 0 {
 		return resp, nil
 	}
+	if contentLength < 0 || (limits.MaxBodyBytes > 0 && int64(contentLength) > limits.MaxBodyBytes) {
+		return resp, ErrResponseTooLarge // the head is still valid for HEAD responses
+	}
 
 	body := make([]byte, contentLength)
 	_, err := io.ReadFull(reader, body)
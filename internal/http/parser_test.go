@@ -0,0 +1,140 @@
+package http
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestContentPath(t *testing.T) {
+	tests := []struct{ path, want string }{
+		{"/logo.png", "/logo.png"},
+		{"/a/b/logo.png", "/logo.png"},
+		{"/logo.png/", "/logo.png"},
+		{"logo.png", "/logo.png"},
+		{"/", "/"},
+		{"", "/"},
+		{"//", "/"},
+	}
+	for _, tt := range tests {
+		if got := ContentPath(tt.path); got != tt.want {
+			t.Errorf("ContentPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheckContentPrefix(t *testing.T) {
+	for _, prefix := range []string{"/", "/private-", "/report.pdf"} {
+		if err := CheckContentPrefix(prefix); err != nil {
+			t.Errorf("CheckContentPrefix(%q) = %v, want nil", prefix, err)
+		}
+	}
+	for _, prefix := range []string{"", "private-", "/protected/", "/a/b"} {
+		if err := CheckContentPrefix(prefix); err == nil {
+			t.Errorf("CheckContentPrefix(%q) = nil, want an error", prefix)
+		}
+	}
+}
+
+func TestParseReqLimits(t *testing.T) {
+	limits := Limits{MaxHeaderBytes: 64, MaxHeaderCount: 2, MaxBodyBytes: 10}
+	requestLine := "GET /a.txt HTTP/1.0\r\n" // 21 bytes, the blank line ending the head is 2 more
+
+	tests := []struct {
+		name    string
+		request string
+		limits  Limits
+		err     error
+		body    string
+	}{
+		{"within limits", requestLine + "Host: a\r\nX-A: 1\r\n\r\n", limits, nil, ""},
+		{"head at the byte limit", requestLine + "X-A: " + strings.Repeat("a", 34) + "\r\n\r\n", limits, nil, ""},
+		{"head over the byte limit", requestLine + "X-A: " + strings.Repeat("a", 35) + "\r\n\r\n", limits, ErrHeaderTooLarge, ""},
+		{"request line over the byte limit", "GET /" + strings.Repeat("a", 64) + " HTTP/1.0\r\n\r\n", limits, ErrHeaderTooLarge, ""},
+		{"line longer than the read buffer", requestLine + "X-A: " + strings.Repeat("a", 8<<10) + "\r\n\r\n", Limits{MaxHeaderBytes: 4 << 10}, ErrHeaderTooLarge, ""},
+		{"header count at the limit", requestLine + "A: 1\r\nB: 2\r\n\r\n", limits, nil, ""},
+		{"header count over the limit", requestLine + "A: 1\r\nB: 2\r\nC: 3\r\n\r\n", limits, ErrTooManyHeaders, ""},
+		{"no limits", requestLine + strings.Repeat("X-A: 1\r\n", 5000) + "\r\n", Limits{}, nil, ""},
+		{"body at the limit", "PUT /a.txt HTTP/1.0\r\nContent-Length: 10\r\n\r\n0123456789", limits, nil, "0123456789"},
+		{"body over the limit", "PUT /a.txt HTTP/1.0\r\nContent-Length: 11\r\n\r\n0123456789a", limits, ErrBodyTooLarge, ""},
+		{"negative length", "PUT /a.txt HTTP/1.0\r\nContent-Length: -1\r\n\r\n", limits, ErrBadLength, ""},
+		{"invalid length", "PUT /a.txt HTTP/1.0\r\nContent-Length: ten\r\n\r\n", limits, ErrBadLength, ""},
+		{"truncated body", "PUT /a.txt HTTP/1.0\r\nContent-Length: 5\r\n\r\nabc", limits, io.ErrUnexpectedEOF, ""},
+		{"truncated head", "GET /a.txt HTTP/1.0\r\nHost: a", limits, io.EOF, ""},
+	}
+	for _, tt := range tests {
+		reader := bufio.NewReader(strings.NewReader(tt.request))
+		req, err := ParseReqHead(reader, tt.limits)
+		if err == nil {
+			if req == nil {
+				t.Errorf("%s: request not parsed", tt.name)
+				continue
+			}
+			err = req.ReadBody(reader, tt.limits)
+		}
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && string(req.Body) != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.name, req.Body, tt.body)
+		}
+	}
+}
+
+func TestParseReqHead(t *testing.T) {
+	req, err := ParseReqHead(bufio.NewReader(strings.NewReader("GET /a/b.txt?x=1&y=%20 HTTP/1.0\r\nHost:  a.test \r\nbad header\r\n\r\n")), DefaultLimits)
+	if err != nil || req == nil {
+		t.Fatalf("got %v, %v", req, err)
+	}
+	if req.Method != "GET" || req.Path != "/a/b.txt" || req.RawQuery != "x=1&y=%20" || req.Query.Get("y") != " " || req.Version != "HTTP/1.0" {
+		t.Errorf("unexpected request line %+v", req)
+	}
+	if len(req.Headers) != 1 || req.Header("HOST") != "a.test" {
+		t.Errorf("unexpected headers %v", req.Headers)
+	}
+
+	for _, malformed := range []string{"\r\n", "GET /a.txt\r\n\r\n"} {
+		if req, err := ParseReqHead(bufio.NewReader(strings.NewReader(malformed)), DefaultLimits); req != nil || err != nil {
+			t.Errorf("ParseReqHead(%q) = %v, %v, want nil, nil", malformed, req, err)
+		}
+	}
+}
+
+func TestParseRespLimits(t *testing.T) {
+	limits := Limits{MaxHeaderBytes: 64, MaxHeaderCount: 2, MaxBodyBytes: 10}
+	statusLine := "HTTP/1.0 200 OK\r\n"
+
+	tests := []struct {
+		name     string
+		response string
+		err      error
+		status   int // of the head returned (0 = none)
+		body     string
+	}{
+		{"within limits", statusLine + "Content-Length: 10\r\n\r\n0123456789", nil, 200, "0123456789"},
+		{"head over the byte limit", statusLine + "X-A: " + strings.Repeat("a", 64) + "\r\n\r\n", ErrResponseTooLarge, 0, ""},
+		{"header count over the limit", statusLine + "A: 1\r\nB: 2\r\nC: 3\r\n\r\n", ErrResponseTooLarge, 0, ""},
+		{"body over the limit", statusLine + "Content-Length: 11\r\n\r\n0123456789a", ErrResponseTooLarge, 200, ""},
+		{"negative length", statusLine + "Content-Length: -1\r\n\r\n", ErrResponseTooLarge, 200, ""},
+		{"no status line", "\r\n", ErrBadStatusLine, 0, ""},
+		{"no status code", "HTTP/1.0\r\n\r\n", ErrBadStatusLine, 0, ""},
+		{"truncated head", statusLine + "Content-Le", io.EOF, 0, ""},
+	}
+	for _, tt := range tests {
+		resp, err := ParseRespLimits(bufio.NewReader(strings.NewReader(tt.response)), limits)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: got error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		status, body := 0, ""
+		if resp != nil {
+			status, body = resp.Status, string(resp.Body)
+		}
+		if status != tt.status || body != tt.body {
+			t.Errorf("%s: got status %d, body %q, want %d, %q", tt.name, status, body, tt.status, tt.body)
+		}
+	}
+}
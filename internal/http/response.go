@@ -1,15 +1,30 @@
 package http
 
-import "fmt"
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
 
 var statusTextMap = map[int]string{
 	200: "OK",
+	301: "Moved Permanently",
+	302: "Found",
+	307: "Temporary Redirect",
+	308: "Permanent Redirect",
 	400: "Bad Request",
+	401: "Unauthorized",
 	403: "Forbidden",
 	404: "Not Found",
 	405: "Method Not Allowed",
+	408: "Request Timeout",
+	409: "Conflict",
+	413: "Content Too Large",
+	429: "Too Many Requests",
+	431: "Request Header Fields Too Large",
 	500: "Internal Server Error",
-	502: "Bad Gateway", // server unreachable, etc.
+	502: "Bad Gateway",         // server unreachable, etc.
+	503: "Service Unavailable", // too many connections, etc.
 }
 
 // NewResponse initializes a Response with the given status code and the appropriate status text.
@@ -52,6 +67,18 @@ func BuildErrorResponse(status int) *Response {
 	return resp
 }
 
+// BuildRedirectResponse builds and returns a redirect Response with the given status code
+// (301, 302, 307 or 308) pointing to the given location.
+func BuildRedirectResponse(status int, location string) *Response {
+	return BuildErrorResponse(status).WithHeader("Location", location)
+}
+
+// IsRedirect reports whether the given status code is a redirect this package can build.
+// 301 and 302 allow clients to change the method to GET, 307 and 308 don't.
+func IsRedirect(status int) bool {
+	return status == 301 || status == 302 || status == 307 || status == 308
+}
+
 // WithHeader sets a header with the given key–value pair on the response
 // and returns the modified Response to allow for fluent chaining.
 // NOTE: add last-modified for better caching? or no cuz it's just fifo?
@@ -67,3 +94,10 @@ func (r *Response) WithBody(b []byte) *Response {
 	r.Headers["Content-Length"] = fmt.Sprint(len(b)) // update content length as needed
 	return r
 }
+
+// ETag returns a strong entity tag for the given content. The origin sends it with every
+// file it serves and in change events, so edges can tell whether a cached copy is current.
+func ETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}
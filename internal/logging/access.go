@@ -0,0 +1,215 @@
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStatus is how the edge cache took part in answering a request.
+type CacheStatus string
+
+const (
+	CacheHit     CacheStatus = "HIT"     // served from the cache
+	CacheMiss    CacheStatus = "MISS"    // fetched from the origin (and cached if found)
+	CacheExpired CacheStatus = "EXPIRED" // cached copy past its TTL, fetched again from the origin
+	CacheStale   CacheStatus = "STALE"   // cached copy past its TTL, served because the origin failed
+	CacheBypass  CacheStatus = "BYPASS"  // not cacheable (e.g. a write), passed to the origin
+)
+
+// Entry is one access log record.
+type Entry struct {
+	Time      time.Time // when the request was received
+	RequestID string
+	ClientIP  string
+	Host      string
+	Method    string
+	Target    string // path and query string, as requested
+	Proto     string
+	Status    int
+	Bytes     int64 // response body bytes sent
+	Duration  time.Duration
+	Upstream  time.Duration // spent waiting for the origin (0 = origin not contacted)
+	Cache     CacheStatus   // "" = the request was answered before reaching the cache
+	Referer   string
+	UserAgent string
+}
+
+// Format is an access log line format.
+type Format int
+
+const (
+	FormatCommon   Format = iota // Common Log Format
+	FormatCombined               // Combined Log Format, followed by cache status, timings and request ID
+	FormatJSON                   // one JSON object per line, every Entry field
+)
+
+// ParseFormat returns the format with the given name ("common", "combined" or "json").
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "common":
+		return FormatCommon, nil
+	case "combined":
+		return FormatCombined, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return 0, fmt.Errorf("unknown access log format %q (expected common, combined or json)", name)
+}
+
+// AccessLog writes entries to a writer in the given format, one per line.
+type AccessLog struct {
+	mu     sync.Mutex
+	w      io.Writer
+	format Format
+}
+
+func NewAccessLog(w io.Writer, format Format) *AccessLog {
+	return &AccessLog{w: w, format: format}
+}
+
+// Log writes the given entry.
+func (l *AccessLog) Log(e Entry) {
+	line := l.format.line(e)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(line)
+}
+
+func (f Format) line(e Entry) []byte {
+	if f == FormatJSON {
+		line, _ := json.Marshal(jsonEntry{
+			Time:       e.Time.Format(time.RFC3339Nano),
+			RequestID:  e.RequestID,
+			ClientIP:   e.ClientIP,
+			Host:       e.Host,
+			Method:     e.Method,
+			Target:     e.Target,
+			Proto:      e.Proto,
+			Status:     e.Status,
+			Bytes:      e.Bytes,
+			DurationMS: milliseconds(e.Duration),
+			UpstreamMS: milliseconds(e.Upstream),
+			Cache:      e.Cache,
+			Referer:    e.Referer,
+			UserAgent:  e.UserAgent,
+		})
+		return append(line, '\n')
+	}
+
+	// host ident authuser [date] "request" status bytes
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s - - [%s] %q %d %s",
+		orDash(e.ClientIP), e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method+" "+e.Target+" "+e.Proto, e.Status, bytesField(e.Bytes))
+	if f == FormatCombined {
+		fmt.Fprintf(&b, " %q %q cache=%s rt=%.3f urt=%.3f id=%s",
+			orDash(e.Referer), orDash(e.UserAgent), orDash(string(e.Cache)),
+			e.Duration.Seconds(), e.Upstream.Seconds(), orDash(e.RequestID))
+	}
+	b.WriteByte('\n')
+	return []byte(b.String())
+}
+
+// clfLine matches the Common Log Format part of common and combined lines:
+// host ident authuser [date] "request" status bytes
+var clfLine = regexp.MustCompile(`^(\S+) \S+ \S+ \[([^\]]+)\] ("(?:[^"\\]|\\.)*") (\d{3}) (\d+|-)`)
+
+// ParseLine parses an access log line in any Format. Of combined lines, only the Common Log
+// Format fields are parsed.
+func ParseLine(line string) (Entry, error) {
+	line = strings.TrimSpace(line)
+	if strings.HasPrefix(line, "{") {
+		var j jsonEntry
+		if err := json.Unmarshal([]byte(line), &j); err != nil {
+			return Entry{}, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, j.Time)
+		if err != nil {
+			return Entry{}, err
+		}
+		return Entry{
+			Time:      t,
+			RequestID: j.RequestID,
+			ClientIP:  j.ClientIP,
+			Host:      j.Host,
+			Method:    j.Method,
+			Target:    j.Target,
+			Proto:     j.Proto,
+			Status:    j.Status,
+			Bytes:     j.Bytes,
+			Duration:  time.Duration(j.DurationMS * float64(time.Millisecond)),
+			Upstream:  time.Duration(j.UpstreamMS * float64(time.Millisecond)),
+			Cache:     j.Cache,
+			Referer:   j.Referer,
+			UserAgent: j.UserAgent,
+		}, nil
+	}
+
+	m := clfLine.FindStringSubmatch(line)
+	if m == nil {
+		return Entry{}, fmt.Errorf("not an access log line: %.40q", line)
+	}
+	t, err := time.Parse("02/Jan/2006:15:04:05 -0700", m[2])
+	if err != nil {
+		return Entry{}, err
+	}
+	request, err := strconv.Unquote(m[3])
+	if err != nil {
+		return Entry{}, err
+	}
+	fields := strings.Fields(request)
+	if len(fields) != 3 {
+		return Entry{}, fmt.Errorf("malformed request %q", request)
+	}
+	e := Entry{Time: t, Method: fields[0], Target: fields[1], Proto: fields[2]}
+	if m[1] != "-" {
+		e.ClientIP = m[1]
+	}
+	e.Status, _ = strconv.Atoi(m[4])
+	if m[5] != "-" {
+		e.Bytes, _ = strconv.ParseInt(m[5], 10, 64)
+	}
+	return e, nil
+}
+
+type jsonEntry struct {
+	Time       string      `json:"time"`
+	RequestID  string      `json:"request_id,omitempty"`
+	ClientIP   string      `json:"client_ip"`
+	Host       string      `json:"host,omitempty"`
+	Method     string      `json:"method"`
+	Target     string      `json:"target"`
+	Proto      string      `json:"proto"`
+	Status     int         `json:"status"`
+	Bytes      int64       `json:"bytes"`
+	DurationMS float64     `json:"duration_ms"`
+	UpstreamMS float64     `json:"upstream_ms"`
+	Cache      CacheStatus `json:"cache,omitempty"`
+	Referer    string      `json:"referer,omitempty"`
+	UserAgent  string      `json:"user_agent,omitempty"`
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func bytesField(n int64) string {
+	if n == 0 {
+		return "-"
+	}
+	return fmt.Sprint(n)
+}
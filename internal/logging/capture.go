@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is one captured request and the edge's answer, a line of the traffic capture file.
+type Record struct {
+	Time           time.Time         `json:"time"` // when the request was received
+	RequestID      string            `json:"request_id,omitempty"`
+	Method         string            `json:"method"`
+	Target         string            `json:"target"`  // path and query string, as requested
+	Headers        map[string]string `json:"headers"` // as sent, without credentials
+	BodySize       int               `json:"body_size"`
+	BodySHA256     string            `json:"body_sha256,omitempty"`
+	Body           []byte            `json:"body,omitempty"` // only if bodies are captured
+	Status         int               `json:"status"`
+	ResponseSize   int               `json:"response_size"`
+	ResponseSHA256 string            `json:"response_sha256"`
+	Cache          CacheStatus       `json:"cache,omitempty"`
+	DurationMS     float64           `json:"duration_ms"`
+}
+
+// redactedHeaders carry credentials, which are never captured.
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Auth-Date"}
+
+// Capture writes records to a writer as JSON, one per line.
+type Capture struct {
+	mu     sync.Mutex
+	w      io.Writer
+	bodies bool // keep request bodies, so writes can be replayed
+}
+
+func NewCapture(w io.Writer, bodies bool) *Capture {
+	return &Capture{w: w, bodies: bodies}
+}
+
+// Record writes the given record, after dropping credential headers (and the request body,
+// unless bodies are captured).
+func (c *Capture) Record(r Record) {
+	headers := make(map[string]string, len(r.Headers))
+	for name, value := range r.Headers {
+		if !isRedacted(name) {
+			headers[name] = value
+		}
+	}
+	r.Headers = headers
+	if !c.bodies {
+		r.Body = nil
+	}
+
+	line, err := json.Marshal(r)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.w.Write(line)
+}
+
+func isRedacted(name string) bool {
+	for _, h := range redactedHeaders {
+		if strings.EqualFold(name, h) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashBody returns the hex SHA-256 of a body, as recorded in captures.
+func HashBody(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// ReadCapture reads the records of a capture file.
+func ReadCapture(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 64<<20) // lines with captured bodies can be long
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
@@ -0,0 +1,21 @@
+// Package logging sets up the servers' diagnostic logging (log/slog) and writes the edge's
+// per-request access log.
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup makes slog's default logger write text records at or above the given level
+// ("debug", "info", "warn" or "error") to stderr.
+func Setup(level string) error {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(strings.ToUpper(level))); err != nil {
+		return fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", level)
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: l})))
+	return nil
+}
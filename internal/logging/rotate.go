@@ -0,0 +1,110 @@
+package logging
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// RotatingFile is an append-only log file that is rotated once it reaches a maximum size:
+// <path> is renamed to <path>.1 (shifting older backups to <path>.2 and so on, dropping the
+// oldest beyond maxBackups) and a new <path> is started.
+type RotatingFile struct {
+	path       string
+	maxSize    int64 // 0 = never rotate
+	maxBackups int
+
+	mu           sync.Mutex
+	f            *os.File
+	size         int64
+	rotateFailed bool // the last rotation failed (and was reported)
+}
+
+// OpenRotatingFile opens (appending to) or creates the log file at the given path.
+func OpenRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first if p would take it over the maximum size.
+// If the rotation fails (e.g. disk full), p is appended to the current file anyway, the
+// failure is logged, and rotation is tried again on the next write.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		err := r.rotate()
+		if err != nil && !r.rotateFailed {
+			slog.Error("Log rotation failed, still writing to the current file", "file", r.path, "err", err)
+		} else if err == nil && r.rotateFailed {
+			slog.Info("Log rotation recovered", "file", r.path)
+		}
+		r.rotateFailed = err != nil
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
+
+// rotate starts a new file, moving the current one to the first backup. The new file is
+// created first, so that if anything fails the current one is kept open for writing.
+func (r *RotatingFile) rotate() error {
+	next := r.path + ".new"
+	f, err := os.OpenFile(next, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if r.maxBackups == 0 {
+		os.Remove(r.path)
+	} else {
+		os.Remove(r.backup(r.maxBackups))
+		for i := r.maxBackups - 1; i >= 1; i-- {
+			os.Rename(r.backup(i), r.backup(i+1))
+		}
+		if err := os.Rename(r.path, r.backup(1)); err != nil && !os.IsNotExist(err) {
+			f.Close()
+			os.Remove(next)
+			return err
+		}
+	}
+	if err := os.Rename(next, r.path); err != nil {
+		f.Close()
+		os.Remove(next)
+		return err
+	}
+
+	r.f.Close()
+	r.f, r.size = f, 0
+	return nil
+}
+
+func (r *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
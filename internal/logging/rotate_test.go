@@ -0,0 +1,73 @@
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// readFile returns the contents of the given file ("" if missing).
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	r, err := OpenRotatingFile(path, 8, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, line := range []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each line takes the file over 8 bytes, so each one started a new file; the oldest is dropped
+	want := map[string]string{path: "dddd\n", path + ".1": "cccc\n", path + ".2": "bbbb\n", path + ".3": ""}
+	for file, content := range want {
+		if got := readFile(t, file); got != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(file), got, content)
+		}
+	}
+}
+
+func TestRotatingFileFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	r, err := OpenRotatingFile(path, 8, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.Write([]byte("aaaa\n"))
+
+	// The new file can't be created: lines keep going to the current one
+	if err := os.Mkdir(path+".new", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"bbbb\n", "cccc\n"} {
+		if n, err := r.Write([]byte(line)); n != len(line) || err != nil {
+			t.Fatalf("Write(%q) during failed rotation = %d, %v", line, n, err)
+		}
+	}
+	if got := readFile(t, path); got != "aaaa\nbbbb\ncccc\n" {
+		t.Errorf("current file = %q, want every line", got)
+	}
+	if got := readFile(t, path+".1"); got != "" {
+		t.Errorf("backup = %q, want none", got)
+	}
+
+	// Rotation resumes once it can
+	os.Remove(path + ".new")
+	r.Write([]byte("dddd\n"))
+	if got, backup := readFile(t, path), readFile(t, path+".1"); got != "dddd\n" || backup != "aaaa\nbbbb\ncccc\n" {
+		t.Errorf("after recovery: file = %q, backup = %q", got, backup)
+	}
+}
@@ -0,0 +1,111 @@
+package metrics
+
+import (
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files with the current output")
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("test_requests_total", "Requests served.", "method", "status")
+	requests.Inc("GET", "200")
+	requests.Add(2, "GET", "200")
+	requests.Inc("POST", "201")
+	requests.Inc("DELETE", "404")
+	requests.Inc("GET", "404")
+
+	r.NewCounter("test_errors_total", "Errors, never updated.")
+
+	temperature := r.NewGauge("test_temperature", "Help with a backslash \\ and a\nnewline.", "room")
+	temperature.Set(21.5, `kitchen "north"`)
+	temperature.Set(-3, "back\\yard")
+	temperature.Set(math.Inf(1), "line\nbreak")
+	temperature.Set(18, "attic")
+	temperature.Set(19, "attic") // overwrites
+
+	latency := r.NewHistogram("test_latency_seconds", "Request latency.", []float64{.1, .5, 1}, "host")
+	for _, v := range []float64{.05, .1, .3, .7, 2} {
+		latency.Observe(v, "b.test")
+	}
+	latency.Observe(.2, "a.test")
+
+	sizes := r.NewHistogram("test_size_bytes", "Sizes, without labels.", []float64{100, 1000})
+	sizes.Observe(1e6)
+
+	entries := map[string]float64{"z.test": 3, "a.test": 1}
+	r.NewGaugeFunc("test_cache_entries", "Entries cached per host.", []string{"host"}, func(emit func(float64, ...string)) {
+		for host, n := range entries {
+			emit(n, host)
+		}
+	})
+	r.NewCounterFunc("test_evictions_total", "Evictions.", nil, func(emit func(float64, ...string)) {
+		emit(7)
+	})
+
+	got := writeText(t, r)
+	checkGolden(t, "exposition.golden", got)
+
+	// Func metrics are collected again each time, dropping series not emitted anymore
+	delete(entries, "z.test")
+	entries["m.test"] = 2
+	if again := writeText(t, r); !strings.Contains(again, `test_cache_entries{host="m.test"} 2`) || strings.Contains(again, "z.test") {
+		t.Errorf("func gauge not collected again:\n%s", again)
+	}
+}
+
+func TestLabelCountMismatch(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("test_total", "Test.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("Inc with missing label values didn't panic")
+		}
+	}()
+	c.Inc("x")
+}
+
+func TestDuplicateMetric(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("test_value", "Test.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a metric twice didn't panic")
+		}
+	}()
+	r.NewCounter("test_value", "Test.")
+}
+
+func writeText(t *testing.T, r *Registry) string {
+	t.Helper()
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// checkGolden compares got with testdata/<name>, or rewrites the file with -update.
+func checkGolden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("output differs from %s (run with -update to accept it):\n--- got\n%s--- want\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,184 @@
+package origin
+
+import (
+	"cdn-edge-server/internal/http"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	EventsPath      = "/_events" // path edges subscribe to for change events
+	maxEventBacklog = 1024       // change events kept for replay to reconnecting edges
+	subscriberQueue = 64         // events buffered per subscriber before it is dropped
+	pingInterval    = 15 * time.Second
+)
+
+// ChangeEvent records that the object stored under Key was created or replaced.
+type ChangeEvent struct {
+	Seq  uint64
+	Key  string
+	ETag string
+}
+
+// eventLog keeps a bounded history of change events and fans new ones out to subscribed edges.
+// Sequence numbers are only meaningful within one epoch (one run of the origin server).
+type eventLog struct {
+	mu      sync.Mutex
+	epoch   string
+	lastSeq uint64
+	backlog []ChangeEvent
+	subs    map[chan ChangeEvent]struct{}
+	closed  bool // shutting down, no new subscribers
+}
+
+var events = newEventLog()
+
+func newEventLog() *eventLog {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return &eventLog{
+		epoch: hex.EncodeToString(b),
+		subs:  make(map[chan ChangeEvent]struct{}),
+	}
+}
+
+// publish records a change to the given key and notifies every subscriber.
+// A subscriber that cannot keep up is dropped; it will resync when it reconnects.
+func (l *eventLog) publish(key, etag string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.lastSeq++
+	ev := ChangeEvent{Seq: l.lastSeq, Key: key, ETag: etag}
+
+	l.backlog = append(l.backlog, ev)
+	if len(l.backlog) > maxEventBacklog {
+		l.backlog = l.backlog[len(l.backlog)-maxEventBacklog:]
+	}
+
+	for ch := range l.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(l.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// subscribe registers a new subscriber that has seen every event up to and including since
+// in the given epoch. It returns the events the subscriber missed, the sequence number of the
+// latest event, and resync=true if the missed events can't be replayed (different epoch, or the
+// backlog no longer reaches back far enough), in which case the subscriber must revalidate its
+// whole cache.
+func (l *eventLog) subscribe(epoch string, since uint64) (ch chan ChangeEvent, missed []ChangeEvent, head uint64, resync bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ch = make(chan ChangeEvent, subscriberQueue)
+	if l.closed {
+		close(ch)
+	} else {
+		l.subs[ch] = struct{}{}
+	}
+
+	oldest := l.lastSeq + 1
+	if len(l.backlog) > 0 {
+		oldest = l.backlog[0].Seq
+	}
+
+	if epoch != l.epoch || since > l.lastSeq || since+1 < oldest {
+		return ch, nil, l.lastSeq, true
+	}
+
+	for _, ev := range l.backlog {
+		if ev.Seq > since {
+			missed = append(missed, ev)
+		}
+	}
+	return ch, missed, l.lastSeq, false
+}
+
+// unsubscribe removes the given subscriber, if it has not already been dropped.
+func (l *eventLog) unsubscribe(ch chan ChangeEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.subs[ch]; ok {
+		delete(l.subs, ch)
+		close(ch)
+	}
+}
+
+// close ends every subscriber's stream (on shutdown). Edges resync once they reconnect.
+func (l *eventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for ch := range l.subs {
+		delete(l.subs, ch)
+		close(ch)
+	}
+}
+
+// serveEvents streams change events to a subscribed edge over the given (persistent) connection.
+// Each event is a single line; the stream runs until either side closes the connection:
+//
+//	resync <seq>              revalidate every cached object, then continue from seq
+//	purge <seq> <key> <etag>  the object stored under key changed
+//	ping                      keep-alive
+func serveEvents(conn net.Conn, req *http.Request) {
+	since, _ := strconv.ParseUint(req.Headers["Last-Event-Seq"], 10, 64)
+	ch, missed, head, resync := events.subscribe(req.Headers["X-Event-Epoch"], since)
+	defer events.unsubscribe(ch)
+
+	resp := http.NewResponse(200).
+		WithHeader("Content-Type", "text/plain").
+		WithHeader("X-Event-Epoch", events.epoch)
+	if _, err := conn.Write([]byte(resp.HeadString())); err != nil {
+		return
+	}
+
+	if resync {
+		if _, err := fmt.Fprintf(conn, "resync %d\n", head); err != nil {
+			return
+		}
+	}
+	for _, ev := range missed {
+		if writeEvent(conn, ev) != nil {
+			return
+		}
+	}
+
+	slog.Info("Edge subscribed to change events", "remote", conn.RemoteAddr())
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return // dropped for falling behind (or shutting down), edge will resync on reconnect
+			}
+			if writeEvent(conn, ev) != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := conn.Write([]byte("ping\n")); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(conn net.Conn, ev ChangeEvent) error {
+	_, err := fmt.Fprintf(conn, "purge %d %s %s\n", ev.Seq, ev.Key, ev.ETag)
+	return err
+}
@@ -0,0 +1,95 @@
+package origin
+
+import (
+	"slices"
+	"testing"
+)
+
+// seqs returns the sequence numbers of the given events.
+func seqs(events []ChangeEvent) []uint64 {
+	var s []uint64
+	for _, ev := range events {
+		s = append(s, ev.Seq)
+	}
+	return s
+}
+
+func TestEventLogReplay(t *testing.T) {
+	l := newEventLog()
+	for _, key := range []string{"a.txt", "b.txt", "c.txt"} {
+		l.publish(key, `"etag"`)
+	}
+
+	tests := []struct {
+		name   string
+		epoch  string
+		since  uint64
+		missed []uint64
+		resync bool
+	}{
+		{"up to date", l.epoch, 3, nil, false},
+		{"missed some", l.epoch, 1, []uint64{2, 3}, false},
+		{"missed all", l.epoch, 0, []uint64{1, 2, 3}, false},
+		{"first subscription", "", 0, nil, true},
+		{"other epoch", "0123456789abcdef", 1, nil, true},
+		{"ahead of the origin", l.epoch, 4, nil, true},
+	}
+	for _, tt := range tests {
+		ch, missed, head, resync := l.subscribe(tt.epoch, tt.since)
+		l.unsubscribe(ch)
+		if !slices.Equal(seqs(missed), tt.missed) || head != 3 || resync != tt.resync {
+			t.Errorf("%s: got missed %v, head %d, resync %t, want missed %v, head 3, resync %t",
+				tt.name, seqs(missed), head, resync, tt.missed, tt.resync)
+		}
+	}
+}
+
+func TestEventLogBacklogOverflow(t *testing.T) {
+	l := newEventLog()
+	for range maxEventBacklog + 5 {
+		l.publish("a.txt", `"etag"`)
+	}
+
+	// Events 1 to 5 fell out of the backlog
+	if _, _, head, resync := l.subscribe(l.epoch, 4); !resync || head != maxEventBacklog+5 {
+		t.Errorf("since 4: got head %d, resync %t, want head %d, resync", head, resync, maxEventBacklog+5)
+	}
+	_, missed, _, resync := l.subscribe(l.epoch, 5)
+	if resync || len(missed) != maxEventBacklog || missed[0].Seq != 6 {
+		t.Errorf("since 5: got %d missed from %v, resync %t, want %d from 6", len(missed), seqs(missed[:1]), resync, maxEventBacklog)
+	}
+}
+
+func TestEventLogFanOut(t *testing.T) {
+	l := newEventLog()
+	fast, _, _, _ := l.subscribe(l.epoch, 0)
+	slow, _, _, _ := l.subscribe(l.epoch, 0)
+
+	for i := range subscriberQueue + 1 {
+		l.publish("a.txt", `"etag"`)
+		if ev := <-fast; ev.Seq != uint64(i+1) || ev.Key != "a.txt" {
+			t.Fatalf("fast subscriber got %+v, want event %d", ev, i+1)
+		}
+	}
+
+	// The slow subscriber's queue overflowed: it was dropped after its queued events
+	for i := range subscriberQueue {
+		if ev := <-slow; ev.Seq != uint64(i+1) {
+			t.Fatalf("slow subscriber got %+v, want event %d", ev, i+1)
+		}
+	}
+	if _, ok := <-slow; ok {
+		t.Error("slow subscriber not dropped")
+	}
+
+	// A dropped subscriber can still unsubscribe; closing ends the others, and refuses new ones
+	l.unsubscribe(slow)
+	l.close()
+	if _, ok := <-fast; ok {
+		t.Error("subscriber still open after close")
+	}
+	late, _, _, _ := l.subscribe(l.epoch, 0)
+	if _, ok := <-late; ok {
+		t.Error("subscription accepted after close")
+	}
+}
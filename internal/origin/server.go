@@ -4,44 +4,123 @@ import (
 	"bufio"
 	"cdn-edge-server/internal/config"
 	"cdn-edge-server/internal/http"
+	"cdn-edge-server/internal/tracing"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
 	"mime"
 	"net"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
-// StartOrigin starts the origin server.
-func StartOrigin() {
-	ln, _ := net.Listen("tcp", config.OriginHost+":"+config.OriginPort)
-	fmt.Printf("Origin server running on %s ...\n", config.OriginHost+":"+config.OriginPort)
+// ErrServerClosed is returned by ListenAndServe after a call to Shutdown.
+var ErrServerClosed = errors.New("origin: server closed")
+
+// Server is the origin server: it serves files from storage to edges.
+type Server struct {
+	Addr string
+
+	// TLSConfig, if set, makes the server terminate TLS (optionally requiring edge certificates)
+	TLSConfig *tls.Config
+
+	// Tracer, if set, records a span for each request, continuing the edge's trace
+	Tracer *tracing.Tracer
+
+	conns http.ConnTracker // listener and in-flight edge connections
+}
+
+// NewServer returns an origin server that will listen on the given host and port.
+func NewServer(host, port string) *Server {
+	return &Server{Addr: host + ":" + port}
+}
+
+// ListenAndServe starts the origin server and handles edge connections until Shutdown is called.
+func (s *Server) ListenAndServe() error {
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("listen error: %w", err)
+	}
+	if s.TLSConfig != nil {
+		ln = tls.NewListener(ln, s.TLSConfig)
+	}
+
+	stopped, ok := s.conns.Serve(ln)
+	if !ok {
+		return ErrServerClosed
+	}
+	defer stopped()
+
+	slog.Info("Origin server running", "addr", s.Addr)
 
 	for {
-		conn, _ := ln.Accept()
-		go handle(conn) // multithreaded origin
+		conn, err := ln.Accept()
+		if err != nil {
+			if s.conns.Closing() {
+				return ErrServerClosed
+			}
+			slog.Error("Accept error", "err", err)
+			continue
+		}
+
+		s.conns.Track(conn, 0)
+
+		go func() { // multithreaded origin
+			defer s.conns.Untrack(conn)
+			handle(conn, s.Tracer)
+		}()
 	}
 }
 
-func handle(conn net.Conn) {
+// Shutdown gracefully stops the origin server: it stops accepting new connections, ends the
+// change event streams, then waits for in-flight requests to finish. If ctx expires first,
+// the remaining connections are closed and ctx's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.conns.Close()
+
+	// Subscribed edges hold their connection open indefinitely, end their streams
+	events.close()
+
+	return s.conns.Drain(ctx)
+}
+
+func handle(conn net.Conn, tracer *tracing.Tracer) {
 	defer conn.Close()
 
+	start := time.Now()
 	req, err := http.ParseReq(bufio.NewReader(conn))
 	if err != nil || req == nil {
 		return
 	}
 
+	// Edge subscribing to change events (connection stays open)
+	if req.Method == "GET" && req.Path == EventsPath {
+		serveEvents(conn, req)
+		return
+	}
+
+	parent, _ := tracing.ParseTraceparent(req.Header("traceparent"))
+	span := tracer.Start(req.Method, tracing.Server, parent, start)
+	defer span.End()
+	span.AddChild("parse", start, time.Now())
+	span.SetAttr("http.request.method", req.Method)
+	span.SetAttr("url.path", req.Path)
+
 	filename := filepath.Base(req.Path)
 
 	switch req.Method {
 	case "GET":
-		serveGET(conn, filename)
+		serveGET(conn, span, filename)
 	case "HEAD":
-		serveHEAD(conn, filename)
+		serveHEAD(conn, span, filename)
 	case "POST":
-		handlePOST(conn, filename, req.Body)
+		handlePOST(conn, span, filename, req.Body)
 	case "PUT":
-		handlePUT(conn, filename, req.Body)
+		handlePUT(conn, span, filename, req.Body)
 	default:
 		resp := http.NewResponse(400)
 		conn.Write([]byte(resp.HeadString()))
@@ -52,34 +131,52 @@ func handle(conn net.Conn) {
 // GET + HEAD
 //
 
-func serveGET(conn net.Conn, filename string) {
-	path := filepath.Join(config.StorageDir, filename)
-	data, err := os.ReadFile(path)
+func serveGET(conn net.Conn, span *tracing.Span, filename string) {
+	data, err := readFile(span, filename)
 	if err != nil {
 		write404(conn)
 		return
 	}
 
-	resp := http.BuildResponse(200, detectMime(filename), data)
+	resp := http.BuildResponse(200, detectMime(filename), data).WithHeader("ETag", http.ETag(data))
+	write := span.StartChild("write", tracing.Internal)
 	conn.Write([]byte(resp.HeadString()))
 	conn.Write(resp.Body)
+	write.End()
 }
 
-func serveHEAD(conn net.Conn, filename string) {
-	path := filepath.Join(config.StorageDir, filename)
-	data, err := os.ReadFile(path)
+func serveHEAD(conn net.Conn, span *tracing.Span, filename string) {
+	data, err := readFile(span, filename)
 	if err != nil {
 		write404(conn)
 		return
 	}
 
-	resp := http.BuildResponse(200, detectMime(filename), data)
+	resp := http.BuildResponse(200, detectMime(filename), data).WithHeader("ETag", http.ETag(data))
 	conn.Write([]byte(resp.HeadString()))
 }
 
+// readFile reads the given file from storage, recording the read as a child of span.
+func readFile(span *tracing.Span, filename string) ([]byte, error) {
+	read := span.StartChild("storage read", tracing.Internal)
+	defer read.End()
+	data, err := os.ReadFile(filepath.Join(config.StorageDir, filename))
+	read.SetAttr("file.found", err == nil)
+	return data, err
+}
+
+// writeFile writes the given file to storage, recording the write as a child of span.
+func writeFile(span *tracing.Span, filename string, body []byte) error {
+	write := span.StartChild("storage write", tracing.Internal)
+	defer write.End()
+	err := os.WriteFile(filepath.Join(config.StorageDir, filename), body, 0644)
+	write.SetError(err)
+	return err
+}
+
 // handlePOST writes a new file to storage using the given filename and body.
 // It returns an error response if the file already exists (POST is create only).
-func handlePOST(conn net.Conn, filename string, body []byte) {
+func handlePOST(conn net.Conn, span *tracing.Span, filename string, body []byte) {
 	path := filepath.Join(config.StorageDir, filename)
 
 	// Reject if file already exists (POST = create)
@@ -89,12 +186,13 @@ func handlePOST(conn net.Conn, filename string, body []byte) {
 		return
 	}
 
-	err := os.WriteFile(path, body, 0644)
-	if err != nil {
+	if err := writeFile(span, filename, body); err != nil {
 		write500(conn)
 		return
 	}
 
+	events.publish(filename, http.ETag(body))
+
 	resp := http.NewResponse(200).WithHeader("Created", filename)
 	conn.Write([]byte(resp.HeadString()))
 	conn.Write(resp.Body)
@@ -102,16 +200,15 @@ func handlePOST(conn net.Conn, filename string, body []byte) {
 
 // handlePUT creates or overwrites a file with the provided filename and body.
 // It always writes the file (PUT is create or replace).
-func handlePUT(conn net.Conn, filename string, body []byte) {
-	path := filepath.Join(config.StorageDir, filename)
-
+func handlePUT(conn net.Conn, span *tracing.Span, filename string, body []byte) {
 	// PUT = create or overwrite
-	err := os.WriteFile(path, body, 0644)
-	if err != nil {
+	if err := writeFile(span, filename, body); err != nil {
 		write500(conn)
 		return
 	}
 
+	events.publish(filename, http.ETag(body))
+
 	resp := http.NewResponse(200).WithHeader("Updated", filename)
 	conn.Write([]byte(resp.HeadString()))
 }
@@ -0,0 +1,331 @@
+// Package rewrite applies an ordered list of URL rewrite, redirect and header rules to requests
+// before they reach the cache.
+package rewrite
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Action is what a rule does to a request it matches.
+type Action int
+
+const (
+	Rewrite     Action = iota // replace the path (later rules see the new path)
+	Redirect                  // answer with a redirect instead of serving the request
+	SetHeader                 // set a header on the response
+	StripHeader               // remove a header from the response
+)
+
+var actionNames = map[string]Action{
+	"rewrite":      Rewrite,
+	"redirect":     Redirect,
+	"set-header":   SetHeader,
+	"strip-header": StripHeader,
+}
+
+// minArgs is the number of arguments each action takes after its regex, before any "if".
+var minArgs = map[Action]int{
+	Rewrite:     1,
+	Redirect:    1,
+	SetHeader:   2,
+	StripHeader: 1,
+}
+
+// Condition restricts a rule to requests with one of the given methods, or to requests that
+// have the given header (with a value matching a pattern, if set).
+type Condition struct {
+	Methods []string
+	Header  string
+	Value   *regexp.Regexp // nil = header present with any value
+	Negate  bool
+}
+
+// Rule matches the request path against a regular expression and, if all its conditions hold,
+// applies its action.
+type Rule struct {
+	Action     Action
+	Pattern    *regexp.Regexp
+	Target     string // rewrite: new path, redirect: location ($1... expand capture groups)
+	Status     int    // redirect status
+	Header     string // set-header/strip-header: header name
+	Value      string // set-header: header value
+	Conditions []Condition
+	Line       int // line in the rules file, for logging
+}
+
+// Rules is an ordered rule list. Every matching rule applies, in order, until a redirect.
+type Rules []Rule
+
+// Result is the outcome of applying Rules to a request.
+type Result struct {
+	Path         string            // path to serve (rewritten or not)
+	Location     string            // redirect target ("" = no redirect)
+	Status       int               // redirect status
+	SetHeaders   map[string]string // response headers to set (after stripping)
+	StripHeaders []string          // response headers to remove
+}
+
+// Parse reads rules, one per line ('#' starts a comment, values with spaces are double-quoted):
+//
+//	rewrite      <regex> <path>                  [if <condition>...]
+//	redirect     <regex> <location> [<status>]   [if <condition>...]
+//	set-header   <regex> <name> <value>          [if <condition>...]
+//	strip-header <regex> <name>                  [if <condition>...]
+//
+// Redirect statuses are 301, 302 (the default), 307 and 308. Conditions all have to hold, and
+// are either method=<method>[,<method>...], header:<name> (present) or header:<name>~<regex>,
+// optionally negated with a leading '!'. Conditions start at the first unquoted "if" after the
+// action's arguments. For example:
+//
+//	rewrite      ^/old/(.*)$   /new/$1
+//	redirect     ^/blog/(.*)$  https://blog.example.com/$1  301  if method=GET,HEAD
+//	set-header   ^/static/     Cache-Control  "public, max-age=3600"
+//	strip-header .             Server         if !header:X-Debug
+func Parse(r io.Reader) (Rules, error) {
+	var rules Rules
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		fields, quoted, err := splitFields(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule, err := parseRule(fields, quoted)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		rule.Line = n
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// Load reads the rules in the given file.
+func Load(file string) (Rules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// parseRule parses the fields of a rule line; quoted[i] is whether fields[i] was quoted.
+func parseRule(fields []string, quoted []bool) (Rule, error) {
+	var rule Rule
+
+	if len(fields) < 2 {
+		return rule, fmt.Errorf("expected <action> <regex> ...")
+	}
+	action, ok := actionNames[fields[0]]
+	if !ok {
+		return rule, fmt.Errorf("unknown action %q", fields[0])
+	}
+	rule.Action = action
+
+	// Conditions follow an unquoted "if" after the action's arguments (so "if" can be a value)
+	var conds []string
+	for i := 2 + minArgs[action]; i < len(fields); i++ {
+		if fields[i] == "if" && !quoted[i] {
+			fields, conds = fields[:i], fields[i+1:]
+			if len(conds) == 0 {
+				return rule, fmt.Errorf("expected conditions after \"if\"")
+			}
+			break
+		}
+	}
+
+	pattern, err := regexp.Compile(fields[1])
+	if err != nil {
+		return rule, fmt.Errorf("invalid pattern: %v", err)
+	}
+	rule.Pattern = pattern
+
+	args := fields[2:]
+	switch action {
+	case Rewrite:
+		if len(args) != 1 {
+			return rule, fmt.Errorf("expected rewrite <regex> <path>")
+		}
+		rule.Target = args[0]
+	case Redirect:
+		if len(args) < 1 || len(args) > 2 {
+			return rule, fmt.Errorf("expected redirect <regex> <location> [<status>]")
+		}
+		rule.Target, rule.Status = args[0], 302
+		if len(args) == 2 {
+			rule.Status, err = strconv.Atoi(args[1])
+			if err != nil || !http.IsRedirect(rule.Status) {
+				return rule, fmt.Errorf("invalid redirect status %q (expected 301, 302, 307 or 308)", args[1])
+			}
+		}
+	case SetHeader:
+		if len(args) != 2 {
+			return rule, fmt.Errorf("expected set-header <regex> <name> <value>")
+		}
+		rule.Header, rule.Value = args[0], args[1]
+	case StripHeader:
+		if len(args) != 1 {
+			return rule, fmt.Errorf("expected strip-header <regex> <name>")
+		}
+		rule.Header = args[0]
+	}
+
+	for _, c := range conds {
+		cond, err := parseCondition(c)
+		if err != nil {
+			return rule, err
+		}
+		rule.Conditions = append(rule.Conditions, cond)
+	}
+	return rule, nil
+}
+
+func parseCondition(s string) (Condition, error) {
+	var cond Condition
+	if rest, ok := strings.CutPrefix(s, "!"); ok {
+		cond.Negate, s = true, rest
+	}
+
+	if methods, ok := strings.CutPrefix(s, "method="); ok {
+		for _, m := range strings.Split(methods, ",") {
+			if m != "" {
+				cond.Methods = append(cond.Methods, strings.ToUpper(m))
+			}
+		}
+		if len(cond.Methods) == 0 {
+			return cond, fmt.Errorf("invalid condition %q: no methods", s)
+		}
+		return cond, nil
+	}
+
+	if header, ok := strings.CutPrefix(s, "header:"); ok {
+		name, value, hasValue := strings.Cut(header, "~")
+		if name == "" {
+			return cond, fmt.Errorf("invalid condition %q: no header name", s)
+		}
+		cond.Header = name
+		if hasValue {
+			re, err := regexp.Compile(value)
+			if err != nil {
+				return cond, fmt.Errorf("invalid condition %q: %v", s, err)
+			}
+			cond.Value = re
+		}
+		return cond, nil
+	}
+
+	return cond, fmt.Errorf("invalid condition %q (expected method=... or header:...)", s)
+}
+
+// splitFields splits a rule line into whitespace-separated fields, keeping double-quoted
+// fields whole, and dropping everything after an unquoted '#'. It also reports which fields
+// were (at least partly) quoted.
+func splitFields(line string) (fields []string, wasQuoted []bool, err error) {
+	var field strings.Builder
+	inField, quoted, fieldQuoted := false, false, false
+
+loop:
+	for _, r := range line {
+		switch {
+		case quoted && r == '"':
+			quoted = false
+		case quoted:
+			field.WriteRune(r)
+		case r == '"':
+			quoted, inField, fieldQuoted = true, true, true
+		case r == '#':
+			break loop
+		case r == ' ' || r == '\t':
+			if inField {
+				fields, wasQuoted = append(fields, field.String()), append(wasQuoted, fieldQuoted)
+				field.Reset()
+				inField, fieldQuoted = false, false
+			}
+		default:
+			field.WriteRune(r)
+			inField = true
+		}
+	}
+	if quoted {
+		return nil, nil, fmt.Errorf("unterminated quote")
+	}
+	if inField {
+		fields, wasQuoted = append(fields, field.String()), append(wasQuoted, fieldQuoted)
+	}
+	return fields, wasQuoted, nil
+}
+
+// Apply evaluates the rules for a request with the given method, path and headers.
+func (rs Rules) Apply(method, path string, headers map[string]string) Result {
+	res := Result{Path: path}
+
+	for i := range rs {
+		rule := &rs[i]
+		match := rule.Pattern.FindStringSubmatchIndex(res.Path)
+		if match == nil || !rule.conditionsHold(method, headers) {
+			continue
+		}
+
+		switch rule.Action {
+		case Rewrite:
+			res.Path = string(rule.Pattern.ExpandString(nil, rule.Target, res.Path, match))
+		case Redirect:
+			res.Location = string(rule.Pattern.ExpandString(nil, rule.Target, res.Path, match))
+			res.Status = rule.Status
+			return res
+		case SetHeader:
+			if res.SetHeaders == nil {
+				res.SetHeaders = make(map[string]string)
+			}
+			res.SetHeaders[rule.Header] = rule.Value
+			res.StripHeaders = slices.DeleteFunc(res.StripHeaders, func(h string) bool {
+				return strings.EqualFold(h, rule.Header)
+			})
+		case StripHeader:
+			for h := range res.SetHeaders {
+				if strings.EqualFold(h, rule.Header) {
+					delete(res.SetHeaders, h)
+				}
+			}
+			res.StripHeaders = append(res.StripHeaders, rule.Header)
+		}
+	}
+	return res
+}
+
+func (r *Rule) conditionsHold(method string, headers map[string]string) bool {
+	for _, c := range r.Conditions {
+		if c.holds(method, headers) == c.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Condition) holds(method string, headers map[string]string) bool {
+	if c.Methods != nil {
+		return slices.Contains(c.Methods, method)
+	}
+	for name, value := range headers {
+		if strings.EqualFold(name, c.Header) {
+			return c.Value == nil || c.Value.MatchString(value)
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package rewrite
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	rules, err := Load("testdata/rules.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	debug := map[string]string{"X-Debug": "1"}
+	tests := []struct {
+		method, path string
+		headers      map[string]string
+		want         Result
+	}{
+		{"GET", "/a.txt", debug, Result{Path: "/a.txt"}},
+		{"GET", "/a.txt", nil, Result{Path: "/a.txt", StripHeaders: []string{"Server"}}},
+
+		// Rewrites chain, later rules seeing the rewritten path
+		{"GET", "/old/a.txt", debug, Result{Path: "/new/a.txt"}},
+		{"GET", "/old/page.htm", debug, Result{Path: "/new/page.html", SetHeaders: map[string]string{"X-Frame-Options": "DENY"}}},
+		{"GET", "/index.html", map[string]string{"user-agent": "Foo Mobile/1.0", "X-Debug": "1"},
+			Result{Path: "/index-m.html", SetHeaders: map[string]string{"X-Frame-Options": "DENY"}}},
+		{"GET", "/index.html", map[string]string{"User-Agent": "Desktop", "X-Debug": "1"},
+			Result{Path: "/index.html", SetHeaders: map[string]string{"X-Frame-Options": "DENY"}}},
+
+		// Redirects stop at the first match, with conditions and capture groups
+		{"GET", "/blog/2024/post", debug, Result{Path: "/blog/2024/post", Location: "https://blog.example.com/2024/post", Status: 301}},
+		{"POST", "/blog/2024/post", debug, Result{Path: "/blog/2024/post"}},
+		{"GET", "/go", debug, Result{Path: "/go", Location: "/landing.html", Status: 302}},
+		{"GET", "/go", nil, Result{Path: "/go", Location: "/landing.html", Status: 302}}, // before the strip-header rule
+		{"GET", "/tmp/a.txt", debug, Result{Path: "/tmp/a.txt", Location: "/temp/a.txt", Status: 307}},
+		{"GET", "/tmp/a.txt", map[string]string{"X-Keep": "", "X-Debug": "1"}, Result{Path: "/tmp/a.txt"}},
+
+		// Header edits: later rules override earlier ones on the same header, case-insensitively
+		{"GET", "/static/app.js", debug, Result{Path: "/static/app.js", SetHeaders: map[string]string{"Cache-Control": "public, max-age=3600"}}},
+		{"GET", "/static/private-a.js", debug, Result{Path: "/static/private-a.js", SetHeaders: map[string]string{}, StripHeaders: []string{"cache-control"}}},
+		{"GET", "/debug.txt", nil, Result{Path: "/debug.txt", SetHeaders: map[string]string{"server": "edge"}, StripHeaders: []string{}}},
+
+		// "if" as a header value
+		{"GET", "/cond/a.txt", debug, Result{Path: "/cond/a.txt", SetHeaders: map[string]string{"X-Cond": "if", "X-Word": "if"}}},
+		{"POST", "/cond/a.txt", debug, Result{Path: "/cond/a.txt", SetHeaders: map[string]string{"X-Word": "if"}}},
+	}
+	for _, tt := range tests {
+		if got := rules.Apply(tt.method, tt.path, tt.headers); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %s %v:\ngot  %+v\nwant %+v", tt.method, tt.path, tt.headers, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct{ rule, err string }{
+		{"move ^/a$ /b", "unknown action"},
+		{"rewrite ^/a$", "expected rewrite <regex> <path>"},
+		{"rewrite ^/(a$ /b", "invalid pattern"},
+		{"redirect ^/a$ /b 200", "invalid redirect status"},
+		{"set-header ^/a$ X-A", "expected set-header"},
+		{"strip-header ^/a$", "expected strip-header"},
+		{"rewrite ^/a$ /b if", "expected conditions"},
+		{"set-header ^/a$ X-A if method=GET", "expected set-header"}, // "if" is the value
+		{`rewrite ^/a$ /b "if" method=GET`, "expected rewrite"},
+		{"rewrite ^/a$ /b if method=", "no methods"},
+		{"rewrite ^/a$ /b if header:", "no header name"},
+		{"rewrite ^/a$ /b if header:X~(", "invalid condition"},
+		{"rewrite ^/a$ /b if cookie:x", "expected method=... or header:..."},
+		{`set-header ^/a$ X-A "unterminated`, "unterminated quote"},
+	}
+	for _, tt := range tests {
+		_, err := Parse(strings.NewReader("# comment\n" + tt.rule + "\n"))
+		if err == nil || !strings.HasPrefix(err.Error(), "line 2: ") || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Parse(%q) = %v, want a line 2 error containing %q", tt.rule, err, tt.err)
+		}
+	}
+}
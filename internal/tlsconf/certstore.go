@@ -0,0 +1,142 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CertStore serves certificates loaded from a directory, selected by the SNI hostname the
+// client asks for. Each certificate is a <name>.crt file (PEM, leaf first) with its private
+// key in <name>.key; it is used for every DNS name (or, failing that, common name) it covers,
+// including wildcards. default.crt (or else the first pair in alphabetical order) is served
+// to clients that send no or an unknown hostname.
+type CertStore struct {
+	dir     string
+	current atomic.Pointer[certSet]
+	stamp   string // fingerprint of the directory's cert files at the last load
+}
+
+type certSet struct {
+	byName   map[string]*tls.Certificate // lower-case hostname or "*.domain" → cert
+	fallback *tls.Certificate
+}
+
+// LoadCertStore loads every certificate pair in the given directory. It fails if the
+// directory holds no valid pair, as the server could not complete any handshake.
+func LoadCertStore(dir string) (*CertStore, error) {
+	s := &CertStore{dir: dir}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetCertificate picks the certificate for the hostname in the given ClientHello
+// (use as tls.Config.GetCertificate).
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	set := s.current.Load()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+
+	if cert, ok := set.byName[name]; ok {
+		return cert, nil
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert, ok := set.byName["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	return set.fallback, nil
+}
+
+// Watch polls the directory every interval and reloads the certificates when a cert or key
+// file is added, removed or modified. If the new files fail to load, the previous certificates
+// stay in use. Watch never returns.
+func (s *CertStore) Watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		stamp, err := dirStamp(s.dir)
+		if err != nil || stamp == s.stamp {
+			continue
+		}
+		if err := s.reload(); err != nil {
+			slog.Error("TLS: keeping previous certificates, reload failed", "err", err)
+			continue
+		}
+		slog.Info("TLS: reloaded certificates", "dir", s.dir)
+	}
+}
+
+func (s *CertStore) reload() error {
+	stamp, err := dirStamp(s.dir)
+	if err != nil {
+		return err
+	}
+
+	certFiles, _ := filepath.Glob(filepath.Join(s.dir, "*.crt"))
+	sort.Strings(certFiles)
+
+	set := &certSet{byName: make(map[string]*tls.Certificate)}
+	for _, certFile := range certFiles {
+		keyFile := strings.TrimSuffix(certFile, ".crt") + ".key"
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("load %s: %w", filepath.Base(certFile), err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("parse %s: %w", filepath.Base(certFile), err)
+		}
+		cert.Leaf = leaf
+
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			set.byName[strings.ToLower(name)] = &cert
+		}
+
+		if set.fallback == nil || filepath.Base(certFile) == "default.crt" {
+			set.fallback = &cert
+		}
+	}
+
+	if set.fallback == nil {
+		return errors.New("no certificates (<name>.crt + <name>.key) in " + s.dir)
+	}
+
+	s.current.Store(set)
+	s.stamp = stamp
+	return nil
+}
+
+// dirStamp summarizes the names, sizes and modification times of the cert and key files
+// in the given directory, so changes can be detected without reading them.
+func dirStamp(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if ext != ".crt" && ext != ".key" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "%s:%d:%d;", e.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,187 @@
+package tlsconf
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self-signed certificate for the given common name and DNS names to
+// <dir>/<name>.crt and its key to <dir>/<name>.key.
+func writeCert(t *testing.T, dir, name, commonName string, dnsNames ...string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// served returns the common name of the certificate the store serves for the given SNI name.
+func served(t *testing.T, s *CertStore, serverName string) string {
+	t.Helper()
+	cert, err := s.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil || cert == nil {
+		t.Fatalf("GetCertificate(%q) = %v, %v", serverName, cert, err)
+	}
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestCertStoreSNI(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "a", "a", "a.test", "www.a.test")
+	writeCert(t, dir, "b", "b", "*.b.test")
+	writeCert(t, dir, "c", "c.test") // no DNS names: its common name is used
+	writeCert(t, dir, "default", "default", "default.test")
+
+	s, err := LoadCertStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct{ serverName, want string }{
+		{"a.test", "a"},
+		{"WWW.A.Test.", "a"},
+		{"x.b.test", "b"},
+		{"b.test", "default"}, // wildcards cover one label
+		{"y.x.b.test", "default"},
+		{"c.test", "c.test"},
+		{"default.test", "default"},
+		{"unknown.test", "default"},
+		{"", "default"}, // no SNI
+	}
+	for _, tt := range tests {
+		if got := served(t, s, tt.serverName); got != tt.want {
+			t.Errorf("SNI %q: served %q, want %q", tt.serverName, got, tt.want)
+		}
+	}
+
+	// The store picks the certificate during real handshakes
+	cfg, err := ServerConfig(s, "1.2", "modern")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for serverName, want := range map[string]string{"www.a.test": "a", "x.b.test": "b", "": "default"} {
+		client, server := net.Pipe()
+		go tls.Server(server, cfg).Handshake()
+		conn := tls.Client(client, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+		if err := conn.Handshake(); err != nil {
+			t.Fatalf("handshake for %q: %v", serverName, err)
+		}
+		if got := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; got != want {
+			t.Errorf("handshake for %q: served %q, want %q", serverName, got, want)
+		}
+		server.Close() // first, so the client's close_notify doesn't wait for a reader
+		conn.Close()
+	}
+}
+
+func TestCertStoreFallback(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "m", "m", "m.test")
+	writeCert(t, dir, "k", "k", "k.test")
+
+	s, err := LoadCertStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := served(t, s, "unknown.test"); got != "k" {
+		t.Errorf("without default.crt: served %q, want the first in alphabetical order (k)", got)
+	}
+}
+
+func TestLoadCertStoreErrors(t *testing.T) {
+	if _, err := LoadCertStore(t.TempDir()); err == nil {
+		t.Error("empty directory: got no error")
+	}
+
+	dir := t.TempDir()
+	writeCert(t, dir, "a", "a", "a.test")
+	os.Remove(filepath.Join(dir, "a.key"))
+	if _, err := LoadCertStore(dir); err == nil {
+		t.Error("certificate without key: got no error")
+	}
+
+	if _, err := LoadCertStore(filepath.Join(dir, "missing")); err == nil {
+		t.Error("missing directory: got no error")
+	}
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "default", "first", "a.test")
+	s, err := LoadCertStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Watch(10 * time.Millisecond)
+
+	waitFor := func(serverName, want string) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); served(t, s, serverName) != want; time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("SNI %q: still served %q, want %q", serverName, served(t, s, serverName), want)
+			}
+		}
+	}
+
+	// A new certificate is picked up
+	writeCert(t, dir, "b", "b", "b.test")
+	waitFor("b.test", "b")
+
+	// A replaced one too (a new key and certificate, of a different size)
+	writeCert(t, dir, "default", "second-default", "a.test")
+	waitFor("a.test", "second-default")
+
+	// A broken pair keeps the previous certificates in use, until it is fixed
+	if err := os.WriteFile(filepath.Join(dir, "b.key"), []byte("not a key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	writeCert(t, dir, "c", "c", "c.test")
+	time.Sleep(100 * time.Millisecond)
+	if got := served(t, s, "c.test"); got != "second-default" {
+		t.Errorf("with a broken pair: served %q for c.test, want the previous certificates", got)
+	}
+	writeCert(t, dir, "b", "b2", "b.test")
+	waitFor("c.test", "c")
+	waitFor("b.test", "b2")
+
+	// Removed certificates are dropped
+	os.Remove(filepath.Join(dir, "c.crt"))
+	os.Remove(filepath.Join(dir, "c.key"))
+	waitFor("c.test", "second-default")
+}
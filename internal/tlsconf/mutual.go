@@ -0,0 +1,98 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+)
+
+// LoadCertPool loads the PEM-encoded CA certificates in the given file.
+func LoadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
+
+// MutualServerConfig returns a TLS server configuration that presents the given certificate.
+// If requireClientCert is set, clients must present a certificate signed by a CA in caFile,
+// and if allowed is non-empty, its subject must be one of them (see allowSubjects).
+// An allowlist without requireClientCert is an error, since it could never be enforced.
+func MutualServerConfig(certFile, keyFile, caFile string, requireClientCert bool, allowed []string) (*tls.Config, error) {
+	if len(allowed) > 0 && !requireClientCert {
+		return nil, errors.New("a client certificate subject allowlist requires client certificates")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if requireClientCert {
+		if cfg.ClientCAs, err = LoadCertPool(caFile); err != nil {
+			return nil, err
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		cfg.VerifyConnection = allowSubjects("client", allowed)
+	}
+	return cfg, nil
+}
+
+// MutualClientConfig returns a TLS client configuration that verifies the server's certificate
+// against the CAs in caFile and the given server name, checks its subject against allowed
+// (if non-empty), and presents the given client certificate (if any) when asked for one.
+func MutualClientConfig(certFile, keyFile, caFile, serverName string, allowed []string) (*tls.Config, error) {
+	pool, err := LoadCertPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		RootCAs:          pool,
+		ServerName:       serverName,
+		MinVersion:       tls.VersionTLS12,
+		VerifyConnection: allowSubjects("server", allowed),
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// allowSubjects returns a tls.Config.VerifyConnection check that accepts a peer whose (already
+// chain-verified) certificate subject matches one of the allowed entries, either by common
+// name (e.g. "edge-1") or by full distinguished name (e.g. "CN=edge-1,O=CDN").
+// An empty allowlist accepts any verified peer.
+func allowSubjects(role string, allowed []string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(allowed) == 0 {
+			return nil
+		}
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("peer presented no certificate")
+		}
+
+		subject := cs.PeerCertificates[0].Subject
+		if slices.Contains(allowed, subject.CommonName) || slices.Contains(allowed, subject.String()) {
+			return nil
+		}
+		slog.Warn("TLS: rejected "+role+" certificate, subject is not allowed", "subject", subject.String())
+		return fmt.Errorf("%s certificate subject %q is not allowed", role, subject.String())
+	}
+}
@@ -0,0 +1,102 @@
+package tlsconf
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeCA writes the certificates of the given names in dir to a single bundle, and returns its path.
+func writeCA(t *testing.T, dir string, names ...string) string {
+	t.Helper()
+	var bundle []byte
+	for _, name := range names {
+		pem, err := os.ReadFile(filepath.Join(dir, name+".crt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bundle = append(bundle, pem...)
+	}
+	path := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(path, bundle, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// handshake runs a TLS handshake between the given configurations over loopback TCP, and returns
+// the server side's error.
+func handshake(t *testing.T, serverCfg, clientCfg *tls.Config) error {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := tls.Dial("tcp", ln.Addr().String(), clientCfg)
+		if err != nil {
+			return
+		}
+		conn.Read(make([]byte, 1)) // until the server closes, so TLS 1.3 sees its verdict
+		conn.Close()
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return tls.Server(conn, serverCfg).Handshake()
+}
+
+func TestMutualServerConfig(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "origin", "origin", "origin.test")
+	writeCert(t, dir, "edge-1", "edge-1")
+	writeCert(t, dir, "edge-2", "edge-2")
+	ca := writeCA(t, dir, "origin", "edge-1", "edge-2")
+	file := func(name string) string { return filepath.Join(dir, name) }
+
+	// An allowlist can only be checked against client certificates
+	if _, err := MutualServerConfig(file("origin.crt"), file("origin.key"), ca, false, []string{"edge-1"}); err == nil {
+		t.Error("allowlist without required client certificates: no error")
+	}
+
+	tests := []struct {
+		name          string
+		requireClient bool
+		allowed       []string
+		clientCert    string
+		wantOK        bool
+	}{
+		{"no client auth", false, nil, "", true},
+		{"client cert required and missing", true, nil, "", false},
+		{"any signed client", true, nil, "edge-2", true},
+		{"allowed by CN", true, []string{"edge-1"}, "edge-1", true},
+		{"allowed by DN", true, []string{"CN=edge-2"}, "edge-2", true},
+		{"not allowed", true, []string{"edge-1"}, "edge-2", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverCfg, err := MutualServerConfig(file("origin.crt"), file("origin.key"), ca, tt.requireClient, tt.allowed)
+			if err != nil {
+				t.Fatal(err)
+			}
+			certFile, keyFile := "", ""
+			if tt.clientCert != "" {
+				certFile, keyFile = file(tt.clientCert+".crt"), file(tt.clientCert+".key")
+			}
+			clientCfg, err := MutualClientConfig(certFile, keyFile, ca, "origin.test", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := handshake(t, serverCfg, clientCfg); (err == nil) != tt.wantOK {
+				t.Errorf("handshake error = %v, want ok = %t", err, tt.wantOK)
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+// Package tlsconf builds the TLS configurations used by the edge and origin servers.
+package tlsconf
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// ParseVersion converts a configured TLS version ("1.0" to "1.3") to its crypto/tls constant.
+func ParseVersion(v string) (uint16, error) {
+	switch v {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2", "":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("unknown TLS version %q (expected 1.0, 1.1, 1.2 or 1.3)", v)
+	}
+}
+
+// CipherSuites returns the TLS 1.0–1.2 cipher suites allowed by the given policy
+// (TLS 1.3 suites are not configurable in crypto/tls):
+//
+//	default     crypto/tls's defaults (nil)
+//	modern      forward-secret AEAD suites only (ECDHE with AES-GCM or ChaCha20-Poly1305)
+//	compatible  modern, plus ECDHE with AES-CBC for older clients
+func CipherSuites(policy string) ([]uint16, error) {
+	modern := []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+	}
+
+	switch policy {
+	case "default", "":
+		return nil, nil
+	case "modern":
+		return modern, nil
+	case "compatible":
+		return append(modern,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+		), nil
+	default:
+		return nil, fmt.Errorf("unknown cipher policy %q (expected default, modern or compatible)", policy)
+	}
+}
+
+// ServerConfig returns a TLS server configuration that serves certificates from the given
+// store, with the given minimum version and cipher policy (see ParseVersion and CipherSuites).
+func ServerConfig(store *CertStore, minVersion, cipherPolicy string) (*tls.Config, error) {
+	version, err := ParseVersion(minVersion)
+	if err != nil {
+		return nil, err
+	}
+	suites, err := CipherSuites(cipherPolicy)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		GetCertificate: store.GetCertificate,
+		MinVersion:     version,
+		CipherSuites:   suites,
+	}, nil
+}
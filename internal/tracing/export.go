@@ -0,0 +1,208 @@
+package tracing
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/http"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Exporter sends a batch of spans, encoded as an OTLP JSON ExportTraceServiceRequest.
+type Exporter interface {
+	Export(payload []byte) error
+}
+
+// FileExporter appends each batch to a file, one JSON document per line.
+type FileExporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileExporter opens (or creates) the given file for appending.
+func NewFileExporter(path string) (*FileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileExporter{file: f}, nil
+}
+
+func (e *FileExporter) Export(payload []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err := e.file.Write(append(payload, '\n'))
+	return err
+}
+
+// Close closes the file.
+func (e *FileExporter) Close() error {
+	return e.file.Close()
+}
+
+// CollectorPath is where collectors accept OTLP/HTTP trace exports.
+const CollectorPath = "/v1/traces"
+
+// CollectorExporter posts each batch to an OTLP/HTTP collector (JSON encoding, plain HTTP).
+type CollectorExporter struct {
+	Addr    string // host:port
+	Timeout time.Duration
+}
+
+func (e *CollectorExporter) Export(payload []byte) error {
+	conn, err := net.DialTimeout("tcp", e.Addr, e.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(e.Timeout))
+
+	head := fmt.Sprintf(
+		"POST %s HTTP/1.0\r\nHost: %s\r\nContent-Type: application/json\r\nContent-Length: %d\r\n\r\n",
+		CollectorPath, e.Addr, len(payload),
+	)
+	if _, err := conn.Write(append([]byte(head), payload...)); err != nil {
+		return err
+	}
+
+	resp, err := http.ParseResp(bufio.NewReader(conn))
+	if resp == nil {
+		return err
+	}
+	if resp.Status < 200 || resp.Status > 299 {
+		return fmt.Errorf("collector answered %d %s", resp.Status, resp.StatusText)
+	}
+	return nil
+}
+
+// ExportRequest is the OTLP JSON encoding of a batch of spans (ExportTraceServiceRequest),
+// limited to the fields this package sets.
+type ExportRequest struct {
+	ResourceSpans []ResourceSpans `json:"resourceSpans"`
+}
+
+type ResourceSpans struct {
+	Resource   Resource     `json:"resource"`
+	ScopeSpans []ScopeSpans `json:"scopeSpans"`
+}
+
+type Resource struct {
+	Attributes []KeyValue `json:"attributes"`
+}
+
+type ScopeSpans struct {
+	Scope Scope      `json:"scope"`
+	Spans []SpanData `json:"spans"`
+}
+
+type Scope struct {
+	Name string `json:"name"`
+}
+
+// SpanData is an exported span. IDs are lowercase hex, times are Unix nanoseconds as strings.
+type SpanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              SpanKind   `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []KeyValue `json:"attributes,omitempty"`
+	Status            Status     `json:"status"`
+}
+
+type KeyValue struct {
+	Key   string   `json:"key"`
+	Value AnyValue `json:"value"`
+}
+
+// AnyValue holds one of its fields, as in OTLP (64-bit integers are strings in OTLP JSON).
+type AnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// String returns the value as text, whatever its type.
+func (v AnyValue) String() string {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return *v.IntValue
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+type Status struct {
+	Code    int    `json:"code"` // 0 = unset, 2 = error
+	Message string `json:"message,omitempty"`
+}
+
+// ScopeName is the instrumentation scope of every span.
+const ScopeName = "cdn-edge-server"
+
+// encodeOTLP encodes the given spans of the given service as an OTLP JSON export request.
+func encodeOTLP(service string, spans []*Span) ([]byte, error) {
+	data := make([]SpanData, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		d := SpanData{
+			TraceID:           hex.EncodeToString(s.context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.context.SpanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parent != [8]byte{} {
+			d.ParentSpanID = hex.EncodeToString(s.parent[:])
+		}
+		for _, a := range s.attrs {
+			d.Attributes = append(d.Attributes, keyValue(a.key, a.value))
+		}
+		if s.hasError {
+			d.Status = Status{Code: 2, Message: s.errorMsg}
+		}
+		s.mu.Unlock()
+		data = append(data, d)
+	}
+
+	return json.Marshal(ExportRequest{ResourceSpans: []ResourceSpans{{
+		Resource:   Resource{Attributes: []KeyValue{keyValue("service.name", service)}},
+		ScopeSpans: []ScopeSpans{{Scope: Scope{Name: ScopeName}, Spans: data}},
+	}}})
+}
+
+func keyValue(key string, value any) KeyValue {
+	var v AnyValue
+	switch value := value.(type) {
+	case string:
+		v.StringValue = &value
+	case int:
+		s := strconv.Itoa(value)
+		v.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(value, 10)
+		v.IntValue = &s
+	case bool:
+		v.BoolValue = &value
+	case float64:
+		v.DoubleValue = &value
+	default:
+		s := fmt.Sprint(value)
+		v.StringValue = &s
+	}
+	return KeyValue{Key: key, Value: v}
+}
@@ -0,0 +1,323 @@
+// Package tracing records request spans, propagates trace context between the edge and the
+// origin with W3C traceparent headers, and exports finished spans as OTLP JSON.
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span within a trace, as carried by a traceparent header.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether the context has a trace and span ID.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent returns the context as a traceparent header value ("" if invalid).
+func (sc SpanContext) Traceparent() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a traceparent header value ("00-<trace id>-<parent id>-<flags>").
+// It returns false if the value is malformed, in which case callers start a new trace.
+func ParseTraceparent(s string) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return sc, false
+	}
+	// Version 00 has exactly four fields; later versions may append more
+	if parts[0] == "00" && len(parts) != 4 {
+		return sc, false
+	}
+
+	var flags [1]byte
+	if !decodeHex(sc.TraceID[:], parts[1]) || !decodeHex(sc.SpanID[:], parts[2]) || !decodeHex(flags[:], parts[3]) {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// decodeHex decodes s, which must be lowercase hex of exactly len(dst) bytes, into dst.
+func decodeHex(dst []byte, s string) bool {
+	if len(s) != 2*len(dst) || strings.ToLower(s) != s {
+		return false
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err == nil
+}
+
+// SpanKind is the role of a span in a request, as in OTLP.
+type SpanKind int
+
+const (
+	Internal SpanKind = 1 // work within a server
+	Server   SpanKind = 2 // serving a request
+	Client   SpanKind = 3 // a request to another server
+)
+
+// Tracer creates spans and exports the sampled ones in batches. A nil Tracer creates spans
+// that are never exported but still propagate their parent's context.
+type Tracer struct {
+	service     string
+	exporter    Exporter
+	sampleRatio float64 // chance that a new trace (one without a parent) is sampled
+
+	mu      sync.Mutex
+	pending []*Span // ended spans waiting for the next export
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+const (
+	batchSize     = 256             // export as soon as this many spans have ended
+	flushInterval = 2 * time.Second // export whatever has ended at least this often
+)
+
+// NewTracer returns a tracer exporting the spans of the given service to exp. New traces are
+// sampled with probability sampleRatio; traces started elsewhere follow their sampled flag.
+func NewTracer(service string, exp Exporter, sampleRatio float64) *Tracer {
+	t := &Tracer{
+		service:     service,
+		exporter:    exp,
+		sampleRatio: sampleRatio,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	go t.flushLoop()
+	return t
+}
+
+// Open returns a tracer for the given service exporting to a file (one OTLP JSON batch per
+// line) or to an OTLP/HTTP collector at host:port, or nil if neither is set.
+func Open(service, file, collector string, sampleRatio float64) (*Tracer, error) {
+	switch {
+	case file != "" && collector != "":
+		return nil, errors.New("trace file and collector are mutually exclusive")
+	case file != "":
+		exp, err := NewFileExporter(file)
+		if err != nil {
+			return nil, err
+		}
+		return NewTracer(service, exp, sampleRatio), nil
+	case collector != "":
+		return NewTracer(service, &CollectorExporter{Addr: collector, Timeout: 5 * time.Second}, sampleRatio), nil
+	}
+	return nil, nil
+}
+
+// Start starts a span that began at the given time, as a child of parent (a new trace if
+// parent is invalid).
+func (t *Tracer) Start(name string, kind SpanKind, parent SpanContext, start time.Time) *Span {
+	if t == nil {
+		return &Span{context: parent}
+	}
+
+	s := &Span{tracer: t, name: name, kind: kind, start: start}
+	if parent.IsValid() {
+		s.context.TraceID = parent.TraceID
+		s.context.Sampled = parent.Sampled
+		s.parent = parent.SpanID
+	} else {
+		s.context.TraceID = randomID16()
+		s.context.Sampled = rand.Float64() < t.sampleRatio
+	}
+	s.context.SpanID = randomID8()
+	return s
+}
+
+// Close exports the spans that have ended and stops the background export.
+func (t *Tracer) Close() {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	<-t.done
+	t.flush()
+	if c, ok := t.exporter.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func (t *Tracer) flushLoop() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.flush()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// enqueue queues an ended span for export, exporting the batch if it is full.
+func (t *Tracer) enqueue(s *Span) {
+	t.mu.Lock()
+	t.pending = append(t.pending, s)
+	full := len(t.pending) >= batchSize
+	t.mu.Unlock()
+
+	if full {
+		go t.flush()
+	}
+}
+
+// flush exports the spans that have ended so far.
+func (t *Tracer) flush() {
+	t.mu.Lock()
+	spans := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+	payload, err := encodeOTLP(t.service, spans)
+	if err == nil {
+		err = t.exporter.Export(payload)
+	}
+	if err != nil {
+		slog.Warn("Trace export failed, dropped spans", "spans", len(spans), "err", err)
+	}
+}
+
+func randomID16() (id [16]byte) {
+	for i := 0; i < 16; i += 8 {
+		putUint64(id[i:], rand.Uint64())
+	}
+	return id
+}
+
+func randomID8() (id [8]byte) {
+	for id == [8]byte{} {
+		putUint64(id[:], rand.Uint64())
+	}
+	return id
+}
+
+func putUint64(b []byte, v uint64) {
+	for i := range 8 {
+		b[i] = byte(v >> (56 - 8*i))
+	}
+}
+
+// Span is a timed operation within a trace. Spans of a nil Tracer, or of unsampled traces,
+// aren't recorded: their methods only keep the trace context. A nil *Span has no context.
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+	parent  [8]byte
+	name    string
+	kind    SpanKind
+
+	mu       sync.Mutex
+	start    time.Time
+	end      time.Time
+	attrs    []attr
+	errorMsg string
+	hasError bool // error status
+	ended    bool
+}
+
+type attr struct {
+	key   string
+	value any // string, int, int64, bool or float64
+}
+
+// Context returns the span's context, to pass to the next server as its traceparent.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+func (s *Span) recording() bool {
+	return s != nil && s.tracer != nil && s.context.Sampled
+}
+
+// StartChild starts a child span now.
+func (s *Span) StartChild(name string, kind SpanKind) *Span {
+	if s == nil || s.tracer == nil {
+		return &Span{context: s.Context()}
+	}
+	return s.tracer.Start(name, kind, s.context, time.Now())
+}
+
+// AddChild records a child span for an operation that already happened.
+func (s *Span) AddChild(name string, start, end time.Time) {
+	if !s.recording() {
+		return
+	}
+	child := s.tracer.Start(name, Internal, s.context, start)
+	child.EndAt(end)
+}
+
+// SetAttr sets an attribute of the span (value is a string, int, int64, bool or float64).
+func (s *Span) SetAttr(key string, value any) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.attrs {
+		if s.attrs[i].key == key {
+			s.attrs[i].value = value
+			return
+		}
+	}
+	s.attrs = append(s.attrs, attr{key, value})
+}
+
+// SetError marks the span as failed with the given error.
+func (s *Span) SetError(err error) {
+	if !s.recording() || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hasError, s.errorMsg = true, err.Error()
+}
+
+// End ends the span now.
+func (s *Span) End() {
+	s.EndAt(time.Now())
+}
+
+// EndAt ends the span at the given time and queues it for export. Later calls do nothing.
+func (s *Span) EndAt(end time.Time) {
+	if !s.recording() {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended, s.end = true, end
+	s.mu.Unlock()
+	s.tracer.enqueue(s)
+}
@@ -0,0 +1,158 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		spanID  = "00f067aa0ba902b7"
+	)
+	tests := []struct {
+		name, value string
+		ok, sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"other flags", "00-" + traceID + "-" + spanID + "-03", true, true},
+		{"surrounding spaces", " 00-" + traceID + "-" + spanID + "-01 ", true, true},
+		{"future version with more fields", "01-" + traceID + "-" + spanID + "-01-extra", true, true},
+		{"missing", "", false, false},
+		{"version 00 with more fields", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"invalid version", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false, false},
+		{"short trace id", "00-" + traceID[2:] + "-" + spanID + "-01", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-" + spanID + "-01", false, false}, // callers start a new trace
+		{"zero span id", "00-" + traceID + "-0000000000000000-01", false, false},
+		{"not hex", "00-" + traceID + "-" + spanID + "-zz", false, false},
+		{"too few fields", "00-" + traceID + "-" + spanID, false, false},
+	}
+	for _, tt := range tests {
+		sc, ok := ParseTraceparent(tt.value)
+		if ok != tt.ok || ok && sc.Sampled != tt.sampled {
+			t.Errorf("%s: ParseTraceparent(%q) = %+v, %v; want ok %v, sampled %v", tt.name, tt.value, sc, ok, tt.ok, tt.sampled)
+			continue
+		}
+		if ok && (sc.Traceparent()[3:35] != traceID || sc.Traceparent()[36:52] != spanID) {
+			t.Errorf("%s: IDs not kept, got %s", tt.name, sc.Traceparent())
+		}
+	}
+
+	// Propagated values round-trip
+	sc, _ := ParseTraceparent("00-" + traceID + "-" + spanID + "-01")
+	if got, _ := ParseTraceparent(sc.Traceparent()); got != sc {
+		t.Errorf("round trip: got %+v, want %+v", got, sc)
+	}
+	if got := (SpanContext{}).Traceparent(); got != "" {
+		t.Errorf("invalid context: got traceparent %q, want none", got)
+	}
+}
+
+func TestStart(t *testing.T) {
+	tracer, err := Open("test", filepath.Join(t.TempDir(), "traces.jsonl"), "", 0) // never samples new traces
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tracer.Close()
+
+	parent, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	span := tracer.Start("GET", Server, parent, time.Now())
+	if ctx := span.Context(); ctx.TraceID != parent.TraceID || !ctx.Sampled || ctx.SpanID == parent.SpanID || span.parent != parent.SpanID {
+		t.Errorf("child of %+v: got %+v with parent %x", parent, ctx, span.parent)
+	}
+	if child := span.StartChild("lookup", Internal); child.Context().TraceID != parent.TraceID || child.parent != span.Context().SpanID {
+		t.Errorf("grandchild not in the trace: %+v", child.Context())
+	}
+
+	unsampled := parent
+	unsampled.Sampled = false
+	if span := tracer.Start("GET", Server, unsampled, time.Now()); span.recording() {
+		t.Error("child of an unsampled parent is recorded")
+	}
+	if span := tracer.Start("GET", Server, SpanContext{}, time.Now()); !span.Context().IsValid() || span.recording() {
+		t.Errorf("new trace: got %+v, recorded %v; want a valid unsampled context", span.Context(), span.recording())
+	}
+
+	// Without a tracer, spans still propagate the parent's context
+	var none *Tracer
+	if span := none.Start("GET", Server, parent, time.Now()); span.Context() != parent || span.StartChild("x", Internal).Context() != parent {
+		t.Errorf("nil tracer: got %+v, want %+v", span.Context(), parent)
+	}
+}
+
+func TestFileExport(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.jsonl")
+	tracer, err := Open("edge", file, "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 0)
+	root := tracer.Start("GET", Server, SpanContext{}, start)
+	root.SetAttr("url.path", "/a.txt")
+	root.SetAttr("http.response.status_code", 502)
+	root.SetAttr("http.response.body.size", int64(10))
+	root.SetAttr("edge.hit", false)
+	root.SetAttr("url.path", "/b.txt") // replaces
+	root.SetError(errors.New("status 502"))
+	root.AddChild("parse", start, start.Add(time.Millisecond))
+	root.EndAt(start.Add(5 * time.Millisecond))
+	root.EndAt(start.Add(time.Hour)) // ignored
+	tracer.Close()
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var spans []SpanData
+	var export ExportRequest
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := json.Unmarshal(scanner.Bytes(), &export); err != nil {
+			t.Fatalf("invalid OTLP JSON %s: %v", scanner.Bytes(), err)
+		}
+		rs := export.ResourceSpans[0]
+		if rs.Resource.Attributes[0].Key != "service.name" || rs.Resource.Attributes[0].Value.String() != "edge" || rs.ScopeSpans[0].Scope.Name != ScopeName {
+			t.Errorf("unexpected resource or scope in %s", scanner.Bytes())
+		}
+		spans = append(spans, rs.ScopeSpans[0].Spans...)
+	}
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+
+	parse, get := spans[0], spans[1]
+	if get.Name != "GET" || get.Kind != Server || get.ParentSpanID != "" || len(get.TraceID) != 32 || len(get.SpanID) != 16 {
+		t.Errorf("unexpected root span %+v", get)
+	}
+	if get.StartTimeUnixNano != "1700000000000000000" || get.EndTimeUnixNano != "1700000000005000000" {
+		t.Errorf("root span times %s-%s", get.StartTimeUnixNano, get.EndTimeUnixNano)
+	}
+	if get.Status.Code != 2 || get.Status.Message != "status 502" {
+		t.Errorf("root span status %+v", get.Status)
+	}
+	attrs := map[string]string{}
+	for _, kv := range get.Attributes {
+		attrs[kv.Key] = kv.Value.String()
+	}
+	want := map[string]string{"url.path": "/b.txt", "http.response.status_code": "502", "http.response.body.size": "10", "edge.hit": "false"}
+	if len(attrs) != len(want) {
+		t.Errorf("root span attributes %v, want %v", attrs, want)
+	}
+	for k, v := range want {
+		if attrs[k] != v {
+			t.Errorf("attribute %s = %q, want %q", k, attrs[k], v)
+		}
+	}
+	if parse.Name != "parse" || parse.Kind != Internal || parse.TraceID != get.TraceID || parse.ParentSpanID != get.SpanID {
+		t.Errorf("unexpected child span %+v", parse)
+	}
+}
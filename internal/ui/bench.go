@@ -0,0 +1,308 @@
+package ui
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/logging"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// benchResult is what one bench worker observed.
+type benchResult struct {
+	latencies []time.Duration // of the requests that got a response
+	statuses  map[int]uint64  // by status class (2 = 2xx, ...)
+	cache     map[string]uint64
+	errors    uint64
+	bytes     int64
+}
+
+// benchReportJSON is the -json output of the bench command.
+type benchReportJSON struct {
+	Requests      uint64             `json:"requests"`
+	Errors        uint64             `json:"errors"`
+	DurationS     float64            `json:"duration_s"`
+	RequestsPerS  float64            `json:"requests_per_s"`
+	BytesReceived int64              `json:"bytes_received"`
+	LatencyMS     map[string]float64 `json:"latency_ms"` // min, mean, p50, p90, p99, p999, max
+	Statuses      map[string]uint64  `json:"statuses"`   // by class ("2xx", ...)
+	Cache         map[string]uint64  `json:"cache"`      // by X-Cache value
+	HitRatio      float64            `json:"hit_ratio"`  // HIT and STALE / cacheable responses
+}
+
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cli bench [flags]\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	edge := fs.String("edge", net.JoinHostPort(config.EdgeHost, config.EdgePort), "edge server `address`")
+	host := fs.String("host", config.CLIHost, "virtual `host` to send in the Host header (the edge's default host if empty)")
+	var headers headerFlags
+	fs.Var(&headers, "H", "extra request `header` (\"Name: value\", repeatable)")
+	concurrency := fs.Int("c", 10, "number of concurrent connections")
+	duration := fs.Duration("duration", 10*time.Second, "how long to run (unless -n is set)")
+	count := fs.Int("n", 0, "number of requests to send, instead of running for -duration")
+	dist := fs.String("dist", "zipf", "request mix: zipf, uniform, or replay (the -keys file in order)")
+	zipfS := fs.Float64("zipf-s", 1.1, "Zipf exponent (> 1, higher = more skewed towards the first keys)")
+	keysFile := fs.String("keys", "", "file of keys (file names), one per line, most popular first")
+	keyPattern := fs.String("key-pattern", "file%d.txt", "key format, without -keys")
+	keyCount := fs.Int("key-count", 100, "number of keys to generate from -key-pattern, without -keys")
+	timeout := fs.Duration("timeout", 10*time.Second, "connection and response timeout of each request")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+
+	if positional, code, ok := parseFlags(fs, args); !ok || len(positional) > 0 {
+		if ok {
+			fs.Usage()
+			return exitUsage
+		}
+		return code
+	}
+	if *concurrency < 1 || *count < 0 || (*count == 0 && *duration <= 0) {
+		fmt.Fprintln(os.Stderr, "cli: -c must be at least 1, and -n or -duration positive")
+		return exitUsage
+	}
+	if *dist != "zipf" && *dist != "uniform" && *dist != "replay" {
+		fmt.Fprintf(os.Stderr, "cli: unknown -dist %q\n", *dist)
+		return exitUsage
+	}
+	if *dist == "zipf" && *zipfS <= 1 {
+		fmt.Fprintln(os.Stderr, "cli: -zipf-s must be greater than 1")
+		return exitUsage
+	}
+	if *dist == "replay" && *keysFile == "" {
+		fmt.Fprintln(os.Stderr, "cli: -dist replay needs a -keys file")
+		return exitUsage
+	}
+
+	var keys []string
+	if *keysFile != "" {
+		var err error
+		if keys, err = readKeys(*keysFile); err != nil {
+			fmt.Fprintln(os.Stderr, "cli:", err)
+			return exitError
+		}
+	} else {
+		for i := range *keyCount {
+			keys = append(keys, fmt.Sprintf(*keyPattern, i+1))
+		}
+	}
+	if len(keys) == 0 {
+		fmt.Fprintln(os.Stderr, "cli: no keys to request")
+		return exitUsage
+	}
+
+	// Build every request up front, to keep the generator's own overhead out of the latencies
+	requests := make([][]byte, len(keys))
+	for i, key := range keys {
+		requests[i] = buildRequest("GET", strings.TrimPrefix(key, "/"), *host, headers, nil)
+	}
+
+	if !*asJSON {
+		limit := fmt.Sprintf("for %s", *duration)
+		if *count > 0 {
+			limit = fmt.Sprintf("%d requests", *count)
+		}
+		fmt.Fprintf(os.Stderr, "Benchmarking %s: %d connections, %s, %s over %d keys\n", *edge, *concurrency, limit, *dist, len(keys))
+	}
+
+	var (
+		sent     atomic.Int64 // requests started, also the position in a replay
+		deadline = time.Now().Add(*duration)
+		results  = make([]benchResult, *concurrency)
+		wg       sync.WaitGroup
+	)
+	start := time.Now()
+	for w := range *concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := &results[w]
+			res.statuses = make(map[int]uint64)
+			res.cache = make(map[string]uint64)
+
+			r := rand.New(rand.NewPCG(uint64(start.UnixNano()), uint64(w)))
+			var zipf *rand.Zipf
+			if *dist == "zipf" {
+				zipf = rand.NewZipf(r, *zipfS, 1, uint64(len(keys)-1))
+			}
+
+			for {
+				n := sent.Add(1)
+				if *count > 0 && n > int64(*count) || *count == 0 && time.Now().After(deadline) {
+					return
+				}
+
+				var i int
+				switch *dist {
+				case "zipf":
+					i = int(zipf.Uint64())
+				case "uniform":
+					i = r.IntN(len(keys))
+				case "replay":
+					i = int((n - 1) % int64(len(keys)))
+				}
+
+				reqStart := time.Now()
+				resp, err := doRequest(*edge, *timeout, requests[i], false)
+				if err != nil {
+					res.errors++
+					continue
+				}
+				res.latencies = append(res.latencies, time.Since(reqStart))
+				res.statuses[resp.Status/100]++
+				res.bytes += int64(len(resp.Body))
+				if status := resp.Headers["X-Cache"]; status != "" {
+					res.cache[status]++
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	report := benchReport(results, elapsed)
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return exitError
+		}
+	} else {
+		printBenchReport(report)
+	}
+	if report.Errors > 0 {
+		return exitError
+	}
+	return exitOK
+}
+
+// readKeys reads a keys file: one key per line, ignoring blank lines and # comments.
+func readKeys(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("reading keys: " + err.Error())
+	}
+	return keys, nil
+}
+
+// benchReport merges the workers' results.
+func benchReport(results []benchResult, elapsed time.Duration) benchReportJSON {
+	report := benchReportJSON{
+		DurationS: roundTo(elapsed.Seconds(), 3),
+		LatencyMS: make(map[string]float64),
+		Statuses:  make(map[string]uint64),
+		Cache:     make(map[string]uint64),
+	}
+
+	var latencies []time.Duration
+	for _, res := range results {
+		latencies = append(latencies, res.latencies...)
+		report.Errors += res.errors
+		report.BytesReceived += res.bytes
+		for class, n := range res.statuses {
+			report.Statuses[fmt.Sprintf("%dxx", class)] += n
+		}
+		for status, n := range res.cache {
+			report.Cache[status] += n
+		}
+	}
+	report.Requests = uint64(len(latencies)) + report.Errors
+	report.RequestsPerS = roundTo(float64(report.Requests)/elapsed.Seconds(), 1)
+
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		var total time.Duration
+		for _, l := range latencies {
+			total += l
+		}
+		report.LatencyMS["min"] = ms(latencies[0])
+		report.LatencyMS["mean"] = ms(total / time.Duration(len(latencies)))
+		report.LatencyMS["p50"] = ms(percentile(latencies, 50))
+		report.LatencyMS["p90"] = ms(percentile(latencies, 90))
+		report.LatencyMS["p99"] = ms(percentile(latencies, 99))
+		report.LatencyMS["p999"] = ms(percentile(latencies, 99.9))
+		report.LatencyMS["max"] = ms(latencies[len(latencies)-1])
+	}
+
+	// Responses the edge didn't try to serve from the cache (BYPASS) don't count
+	hits := report.Cache[string(logging.CacheHit)] + report.Cache[string(logging.CacheStale)]
+	lookups := hits + report.Cache[string(logging.CacheMiss)] + report.Cache[string(logging.CacheExpired)]
+	if lookups > 0 {
+		report.HitRatio = roundTo(float64(hits)/float64(lookups), 4)
+	}
+	return report
+}
+
+// percentile returns the nearest-rank p-th percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	return sorted[max(0, rank-1)]
+}
+
+func ms(d time.Duration) float64 {
+	return roundTo(float64(d.Microseconds())/1000, 3)
+}
+
+func roundTo(x float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(x*scale) / scale
+}
+
+func printBenchReport(r benchReportJSON) {
+	fmt.Println("\n Benchmark")
+	fmt.Println("═══════════════════════════════════════")
+	fmt.Printf("Requests:   %d in %.3fs (%.1f req/s), %s received\n", r.Requests, r.DurationS, r.RequestsPerS, formatBytes(r.BytesReceived))
+	fmt.Printf("Errors:     %d\n", r.Errors)
+	if len(r.LatencyMS) > 0 {
+		fmt.Printf("Latency:    min %.3fms, mean %.3fms, max %.3fms\n", r.LatencyMS["min"], r.LatencyMS["mean"], r.LatencyMS["max"])
+		fmt.Printf("            p50 %.3fms, p90 %.3fms, p99 %.3fms, p999 %.3fms\n",
+			r.LatencyMS["p50"], r.LatencyMS["p90"], r.LatencyMS["p99"], r.LatencyMS["p999"])
+	}
+
+	var statuses []string
+	for _, class := range []string{"2xx", "3xx", "4xx", "5xx"} {
+		if n := r.Statuses[class]; n > 0 {
+			statuses = append(statuses, fmt.Sprintf("%s %d", class, n))
+		}
+	}
+	if len(statuses) == 0 {
+		statuses = []string{"-"}
+	}
+	fmt.Printf("Statuses:   %s\n", strings.Join(statuses, ", "))
+
+	var cache []string
+	for _, status := range []logging.CacheStatus{logging.CacheHit, logging.CacheMiss, logging.CacheExpired, logging.CacheStale, logging.CacheBypass} {
+		if n := r.Cache[string(status)]; n > 0 {
+			cache = append(cache, fmt.Sprintf("%s %d", status, n))
+		}
+	}
+	if len(cache) == 0 {
+		fmt.Printf("Hit ratio:  - (no X-Cache headers)\n")
+	} else {
+		fmt.Printf("Hit ratio:  %.1f%% (%s)\n", 100*r.HitRatio, strings.Join(cache, ", "))
+	}
+	fmt.Println("═══════════════════════════════════════")
+}
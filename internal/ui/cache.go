@@ -0,0 +1,242 @@
+package ui
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Cache statistics and entries as served by the edge's admin API (see edge.NewAdminHandler)
+type cacheStats struct {
+	Host      string            `json:"host"`
+	Entries   int               `json:"entries"`
+	Bytes     int64             `json:"bytes"`
+	Capacity  int               `json:"capacity"`
+	Hits      uint64            `json:"hits"`
+	Misses    uint64            `json:"misses"`
+	HitRatio  float64           `json:"hit_ratio"`
+	Evictions map[string]uint64 `json:"evictions"`
+}
+
+type cacheEntry struct {
+	Host       string     `json:"host"`
+	File       string     `json:"file"`
+	Size       int64      `json:"size"`
+	Position   int        `json:"position"`
+	CachedAt   time.Time  `json:"cached_at"`
+	AgeSeconds float64    `json:"age_seconds"`
+	TTLSeconds *float64   `json:"ttl_seconds"`
+	Hits       uint64     `json:"hits"`
+	LastAccess *time.Time `json:"last_access"`
+}
+
+// evictReasons are the eviction reasons in the order the admin API documents them
+var evictReasons = []string{"capacity", "ttl", "invalidation", "corruption"}
+
+func (c *CLI) cacheMenu() {
+	if config.AdminPort == "" {
+		fmt.Println("\n  The edge admin port is not configured!")
+		fmt.Println("   Set ADMIN_PORT in .env and restart the edge")
+		return
+	}
+
+	for {
+		fmt.Println("\n┌─ Cache ─────────────────────────────────┐")
+		fmt.Println("│ 1. Statistics                           │")
+		fmt.Println("│ 2. List cached files                    │")
+		fmt.Println("│ 3. Cached file details                  │")
+		fmt.Println("│ 4. Back to main menu                    │")
+		fmt.Println("└─────────────────────────────────────────┘")
+		fmt.Print("\nSelect option: ")
+
+		choice := c.readInput()
+
+		switch choice {
+		case "1":
+			c.showCacheStats()
+		case "2":
+			c.listCacheEntries()
+		case "3":
+			c.showCacheEntry()
+		case "4":
+			return
+		default:
+			fmt.Println("Invalid option. Please try again.")
+		}
+	}
+}
+
+func (c *CLI) showCacheStats() {
+	var stats struct {
+		TTLSeconds float64      `json:"ttl_seconds"`
+		Hosts      []cacheStats `json:"hosts"`
+		Total      cacheStats   `json:"total"`
+	}
+	if err := adminGet("/cache", &stats); err != nil {
+		fmt.Println("Error fetching cache statistics:", err)
+		return
+	}
+
+	fmt.Println("\n Cache Statistics")
+	fmt.Println("═══════════════════════════════════════")
+	if stats.TTLSeconds > 0 {
+		fmt.Printf("TTL: %s\n\n", time.Duration(stats.TTLSeconds*float64(time.Second)))
+	} else {
+		fmt.Printf("TTL: none\n\n")
+	}
+	for _, h := range stats.Hosts {
+		fmt.Printf("%s\n", h.Host)
+		printCacheStats(h)
+		fmt.Println()
+	}
+	if len(stats.Hosts) > 1 {
+		fmt.Println("Total")
+		printCacheStats(stats.Total)
+	}
+	fmt.Println("═══════════════════════════════════════")
+}
+
+func printCacheStats(s cacheStats) {
+	if s.Capacity > 0 {
+		fmt.Printf("  Entries:   %d/%d (%s)\n", s.Entries, s.Capacity, formatBytes(s.Bytes))
+	} else {
+		fmt.Printf("  Entries:   %d (%s)\n", s.Entries, formatBytes(s.Bytes))
+	}
+	fmt.Printf("  Hit ratio: %.1f%% (%d hits, %d misses)\n", 100*s.HitRatio, s.Hits, s.Misses)
+
+	var evictions []string
+	for _, reason := range evictReasons {
+		evictions = append(evictions, fmt.Sprintf("%s %d", reason, s.Evictions[reason]))
+	}
+	fmt.Printf("  Evictions: %s\n", strings.Join(evictions, ", "))
+}
+
+func (c *CLI) listCacheEntries() {
+	var list struct {
+		Entries []cacheEntry `json:"entries"`
+	}
+	if err := adminGet("/cache/entries", &list); err != nil {
+		fmt.Println("Error fetching cached files:", err)
+		return
+	}
+
+	fmt.Println("\n Cached Files (1 = next to be evicted)")
+	fmt.Println("═══════════════════════════════════════")
+	if len(list.Entries) == 0 {
+		fmt.Println("(empty)")
+	}
+	host := ""
+	for _, e := range list.Entries {
+		if e.Host != host {
+			host = e.Host
+			fmt.Printf("%s\n", host)
+			fmt.Printf("  %3s  %-24s %10s %8s %8s %6s\n", "#", "FILE", "SIZE", "AGE", "TTL", "HITS")
+		}
+		fmt.Printf("  %3d  %-24s %10s %8s %8s %6d\n",
+			e.Position, e.File, formatBytes(e.Size), formatSeconds(e.AgeSeconds), formatTTL(e.TTLSeconds), e.Hits)
+	}
+	fmt.Println("═══════════════════════════════════════")
+}
+
+func (c *CLI) showCacheEntry() {
+	fmt.Print("\nEnter filename: ")
+	filename := c.readInput()
+
+	if filename == "" {
+		fmt.Println("Filename cannot be empty")
+		return
+	}
+
+	query := url.Values{"file": {filename}}
+	if config.CLIHost != "" {
+		query.Set("host", config.CLIHost)
+	}
+	var e cacheEntry
+	if err := adminGet("/cache/entry?"+query.Encode(), &e); err != nil {
+		fmt.Println("Error fetching cached file:", err)
+		return
+	}
+
+	fmt.Println("\n Cached File")
+	fmt.Println("═══════════════════════════════════════")
+	fmt.Printf("Host:        %s\n", e.Host)
+	fmt.Printf("File:        %s\n", e.File)
+	fmt.Printf("Size:        %s\n", formatBytes(e.Size))
+	fmt.Printf("Position:    %d (1 = next to be evicted)\n", e.Position)
+	fmt.Printf("Cached at:   %s (%s ago)\n", e.CachedAt.Local().Format(time.DateTime), formatSeconds(e.AgeSeconds))
+	fmt.Printf("TTL left:    %s\n", formatTTL(e.TTLSeconds))
+	fmt.Printf("Hits:        %d\n", e.Hits)
+	if e.LastAccess != nil {
+		fmt.Printf("Last access: %s\n", e.LastAccess.Local().Format(time.DateTime))
+	} else {
+		fmt.Printf("Last access: never\n")
+	}
+	fmt.Println("═══════════════════════════════════════")
+}
+
+// adminGet fetches the given path from the edge's admin port and decodes the JSON response into v.
+func adminGet(path string, v any) error {
+	return adminDo("GET", path, nil, v)
+}
+
+// adminStatusError is returned by adminDo for non-200 responses.
+type adminStatusError struct {
+	status int
+	body   string
+}
+
+func (e *adminStatusError) Error() string {
+	return fmt.Sprintf("%d %s", e.status, e.body)
+}
+
+// adminDo sends a request for the given path (with the given body, if any) to the edge's
+// admin port and decodes the JSON response into v.
+func adminDo(method, path string, body []byte, v any) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(config.AdminHost, config.AdminPort), time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := fmt.Fprintf(conn, "%s %s HTTP/1.0\r\nContent-Length: %d\r\n\r\n%s", method, path, len(body), body); err != nil {
+		return err
+	}
+	resp, err := http.ParseResp(bufio.NewReader(conn))
+	if resp == nil {
+		return err
+	}
+	if resp.Status != 200 {
+		return &adminStatusError{resp.Status, strings.TrimSpace(string(resp.Body))}
+	}
+	return json.Unmarshal(resp.Body, v)
+}
+
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+func formatSeconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Second).String()
+}
+
+func formatTTL(s *float64) string {
+	switch {
+	case s == nil:
+		return "none"
+	case *s == 0:
+		return "expired"
+	}
+	return formatSeconds(*s)
+}
@@ -0,0 +1,480 @@
+package ui
+
+import (
+	"bufio"
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/http"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Exit codes of the non-interactive commands
+const (
+	exitOK          = 0
+	exitError       = 1 // connection, I/O or admin API error
+	exitUsage       = 2
+	exitClientError = 4 // 4xx response
+	exitServerError = 5 // 5xx response
+)
+
+const usage = `Usage: cli [command [flags] [args]]
+
+Without a command, cli starts the interactive menu.
+
+Commands:
+  get <file>        GET a file from the edge
+  head <file>       HEAD a file from the edge
+  post <file>       create a file on the origin through the edge
+  put <file>        update a file on the origin through the edge
+  delete <file>     DELETE a file through the edge
+  status            check whether the edge, origin and admin ports are up
+  config            print the configuration
+  purge [file...]   drop files from the edge cache (every cached file with -all)
+  warm <manifest>   fetch the files of a manifest into the edge cache, reporting
+                    progress (-status: the last warm-up of each host)
+  bench             generate load against the edge and report throughput, latency
+                    and the cache hit ratio
+  replay <file>...  re-issue traffic captured by the edge (CAPTURE_FILE) and report
+                    responses that differ from the recording
+  simulate <file>.. replay access logs or traces through simulated caches (offline)
+                    and print hit ratios by eviction policy and capacity as CSV
+
+Run "cli <command> -h" for the command's flags.
+
+Exit status: 0 for 2xx/3xx responses, 4 for 4xx, 5 for 5xx, 1 for connection
+and other errors (bench: any failed request, warm: any file that failed, replay: any response that
+differs or failed), 2 for invalid usage.
+`
+
+// RunCommand runs the non-interactive command given by args (os.Args[1:]) and returns the
+// process exit status.
+func RunCommand(args []string) int {
+	name, args := args[0], args[1:]
+	switch name {
+	case "get", "head", "post", "put", "delete":
+		return runRequest(strings.ToUpper(name), args)
+	case "status":
+		return runStatus(args)
+	case "config":
+		return runConfig(args)
+	case "purge":
+		return runPurge(args)
+	case "warm":
+		return runWarm(args)
+	case "bench":
+		return runBench(args)
+	case "replay":
+		return runReplay(args)
+	case "simulate":
+		return runSimulate(args)
+	case "help", "-h", "-help", "--help":
+		fmt.Print(usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "cli: unknown command %q\n\n%s", name, usage)
+		return exitUsage
+	}
+}
+
+// parseFlags parses the command's flags, which may come before, after or between its
+// arguments, and returns the arguments. ok is false if the flags are invalid (the flag set
+// has already printed why) or help was requested, in which case code is the exit status.
+func parseFlags(fs *flag.FlagSet, args []string) (positional []string, code int, ok bool) {
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, exitOK, false
+			}
+			return nil, exitUsage, false
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, exitOK, true
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// headerFlags collects repeated -H "Name: value" flags.
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	name, _, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return errors.New(`expected "Name: value"`)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// responseJSON is the -json output of the request commands.
+type responseJSON struct {
+	Method     string            `json:"method"`
+	File       string            `json:"file"`
+	Status     int               `json:"status"`
+	StatusText string            `json:"status_text"`
+	Headers    map[string]string `json:"headers"`
+	Body       *string           `json:"body,omitempty"`        // if valid UTF-8
+	BodyBase64 []byte            `json:"body_base64,omitempty"` // otherwise
+	Output     string            `json:"output,omitempty"`      // file the body was written to instead
+	DurationMS float64           `json:"duration_ms"`
+}
+
+func runRequest(method string, args []string) int {
+	fs := flag.NewFlagSet(strings.ToLower(method), flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cli %s [flags] <file>\n\nFlags:\n", fs.Name())
+		fs.PrintDefaults()
+	}
+	edge := fs.String("edge", net.JoinHostPort(config.EdgeHost, config.EdgePort), "edge server `address`")
+	host := fs.String("host", config.CLIHost, "virtual `host` to send in the Host header (the edge's default host if empty)")
+	var headers headerFlags
+	fs.Var(&headers, "H", "extra request `header` (\"Name: value\", repeatable)")
+	data := fs.String("d", "", "request body")
+	dataFile := fs.String("data-file", "", "read the request body from `path` (\"-\" for stdin)")
+	output := fs.String("o", "", "write the response body to `path` instead of stdout")
+	include := fs.Bool("i", false, "print the status line and headers before the body")
+	asJSON := fs.Bool("json", false, "print the response as JSON")
+	timeout := fs.Duration("timeout", 30*time.Second, "connection and response timeout")
+
+	positional, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if len(positional) != 1 {
+		fs.Usage()
+		return exitUsage
+	}
+	filename := strings.TrimPrefix(positional[0], "/")
+
+	body := []byte(*data)
+	if *dataFile != "" {
+		if *data != "" {
+			fmt.Fprintln(os.Stderr, "cli: -d and -data-file are mutually exclusive")
+			return exitUsage
+		}
+		var err error
+		if *dataFile == "-" {
+			body, err = io.ReadAll(os.Stdin)
+		} else {
+			body, err = os.ReadFile(*dataFile)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cli: reading body:", err)
+			return exitError
+		}
+	}
+
+	start := time.Now()
+	resp, err := doRequest(*edge, *timeout, buildRequest(method, filename, *host, headers, body), method == "HEAD")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cli:", err)
+		return exitError
+	}
+	elapsed := time.Since(start)
+
+	if *output != "" {
+		if err := os.WriteFile(*output, resp.Body, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, "cli: writing output:", err)
+			return exitError
+		}
+	}
+
+	if *asJSON {
+		j := responseJSON{
+			Method:     method,
+			File:       filename,
+			Status:     resp.Status,
+			StatusText: resp.StatusText,
+			Headers:    resp.Headers,
+			Output:     *output,
+			DurationMS: float64(elapsed.Microseconds()) / 1000,
+		}
+		if *output == "" && method != "HEAD" {
+			if utf8.Valid(resp.Body) {
+				s := string(resp.Body)
+				j.Body = &s
+			} else {
+				j.BodyBase64 = resp.Body
+			}
+		}
+		if err := printJSON(j); err != nil {
+			return exitError
+		}
+	} else {
+		if *include || method == "HEAD" {
+			printHead(resp)
+		}
+		if *output == "" {
+			os.Stdout.Write(resp.Body)
+		}
+	}
+	return statusExitCode(resp.Status)
+}
+
+// buildRequest returns the raw request for the given file on the edge, with the given
+// virtual host, extra headers and the CLI's credentials.
+func buildRequest(method, filename, host string, headers []string, body []byte) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s /%s HTTP/1.0\r\n", method, filename)
+	// Without a Host header, the edge serves the request from its default virtual host
+	if host != "" {
+		b.WriteString("Host: " + host + "\r\n")
+	}
+	b.WriteString(authHeaders(method, "/"+filename, string(body)))
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		fmt.Fprintf(&b, "%s: %s\r\n", strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	fmt.Fprintf(&b, "Content-Length: %d\r\n\r\n", len(body))
+	b.Write(body)
+	return []byte(b.String())
+}
+
+// doRequest sends the raw request to the given address and parses the response, ignoring
+// the missing body of HEAD responses.
+func doRequest(addr string, timeout time.Duration, req []byte, head bool) (*http.Response, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := conn.Write(req); err != nil {
+		return nil, fmt.Errorf("sending request: %w", err)
+	}
+	resp, err := http.ParseResp(bufio.NewReader(conn))
+	if err != nil && (resp == nil || !head) {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	return resp, nil
+}
+
+func statusExitCode(status int) int {
+	switch {
+	case status >= 500:
+		return exitServerError
+	case status >= 400:
+		return exitClientError
+	}
+	return exitOK
+}
+
+// printHead prints the response's status line and headers (sorted by name) to stdout.
+func printHead(resp *http.Response) {
+	names := make([]string, 0, len(resp.Headers))
+	for name := range resp.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Printf("%s %d %s\n", resp.Version, resp.Status, resp.StatusText)
+	for _, name := range names {
+		fmt.Printf("%s: %s\n", name, resp.Headers[name])
+	}
+	fmt.Println()
+}
+
+func printJSON(v any) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "cli:", err)
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// serverStatusJSON is one server's entry in the -json output of the status command.
+type serverStatusJSON struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	Up      bool   `json:"up"`
+	Error   string `json:"error,omitempty"`
+}
+
+func runStatus(args []string) int {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the status as JSON")
+	timeout := fs.Duration("timeout", time.Second, "connection timeout")
+	if positional, code, ok := parseFlags(fs, args); !ok || len(positional) > 0 {
+		if ok {
+			fs.Usage()
+			return exitUsage
+		}
+		return code
+	}
+
+	servers := []serverStatusJSON{
+		{Name: "edge", Address: net.JoinHostPort(config.EdgeHost, config.EdgePort)},
+		{Name: "origin", Address: net.JoinHostPort(config.OriginHost, config.OriginPort)},
+	}
+	if config.AdminPort != "" {
+		servers = append(servers, serverStatusJSON{Name: "admin", Address: net.JoinHostPort(config.AdminHost, config.AdminPort)})
+	}
+
+	code := exitOK
+	for i := range servers {
+		s := &servers[i]
+		conn, err := net.DialTimeout("tcp", s.Address, *timeout)
+		if err != nil {
+			s.Error = err.Error()
+			code = exitError
+			continue
+		}
+		conn.Close()
+		s.Up = true
+	}
+
+	if *asJSON {
+		if err := printJSON(map[string]any{"servers": servers}); err != nil {
+			return exitError
+		}
+		return code
+	}
+	for _, s := range servers {
+		state := "up"
+		if !s.Up {
+			state = "down"
+		}
+		fmt.Printf("%-7s %-22s %s\n", s.Name, s.Address, state)
+	}
+	return code
+}
+
+func runConfig(args []string) int {
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the configuration as JSON")
+	if positional, code, ok := parseFlags(fs, args); !ok || len(positional) > 0 {
+		if ok {
+			fs.Usage()
+			return exitUsage
+		}
+		return code
+	}
+
+	admin := ""
+	if config.AdminPort != "" {
+		admin = net.JoinHostPort(config.AdminHost, config.AdminPort)
+	}
+	// Credentials are reported by kind only, never printed
+	auth := "none"
+	switch {
+	case config.CLIAuthToken != "":
+		auth = "token"
+	case config.CLIAuthUser != "":
+		auth = "basic"
+	case config.CLIHMACKey != "":
+		auth = "hmac"
+	}
+
+	settings := []struct{ key, label, value string }{
+		{"edge", "Edge Server:", net.JoinHostPort(config.EdgeHost, config.EdgePort)},
+		{"origin", "Origin Server:", net.JoinHostPort(config.OriginHost, config.OriginPort)},
+		{"admin", "Admin Port:", admin},
+		{"host", "CLI Host:", config.CLIHost},
+		{"auth", "CLI Auth:", auth},
+		{"cache_dir", "Cache Dir:", config.CacheDir},
+		{"storage_dir", "Storage Dir:", config.StorageDir},
+	}
+
+	if *asJSON {
+		j := make(map[string]string, len(settings))
+		for _, s := range settings {
+			j[s.key] = s.value
+		}
+		if err := printJSON(j); err != nil {
+			return exitError
+		}
+		return exitOK
+	}
+	for _, s := range settings {
+		value := s.value
+		if value == "" {
+			value = "-"
+		}
+		fmt.Printf("%-15s %s\n", s.label, value)
+	}
+	return exitOK
+}
+
+func runPurge(args []string) int {
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cli purge [flags] <file>...\n       cli purge [flags] -all\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	host := fs.String("host", config.CLIHost, "virtual `host` whose cache to purge (the edge's default host if empty)")
+	all := fs.Bool("all", false, "purge every cached file of the host")
+	asJSON := fs.Bool("json", false, "print the purged files as JSON")
+
+	files, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if *all == (len(files) > 0) {
+		fs.Usage()
+		return exitUsage
+	}
+	if config.AdminPort == "" {
+		fmt.Fprintln(os.Stderr, "cli: the edge admin port is not configured (set ADMIN_PORT)")
+		return exitError
+	}
+	if *all {
+		files = []string{""} // no file = every file
+	}
+
+	var result struct {
+		Host   string   `json:"host"`
+		Purged []string `json:"purged"`
+	}
+	result.Purged = []string{}
+	for _, file := range files {
+		query := url.Values{}
+		if *host != "" {
+			query.Set("host", *host)
+		}
+		if file != "" {
+			query.Set("file", file)
+		}
+		var resp struct {
+			Host   string   `json:"host"`
+			Purged []string `json:"purged"`
+		}
+		if err := adminDo("POST", "/cache/purge?"+query.Encode(), nil, &resp); err != nil {
+			return adminError(err)
+		}
+		result.Host = resp.Host
+		result.Purged = append(result.Purged, resp.Purged...)
+		if file != "" && len(resp.Purged) == 0 && !*asJSON {
+			fmt.Printf("Not cached: %s/%s\n", resp.Host, file)
+		}
+	}
+
+	if *asJSON {
+		if err := printJSON(result); err != nil {
+			return exitError
+		}
+		return exitOK
+	}
+	for _, file := range result.Purged {
+		fmt.Printf("Purged: %s/%s\n", result.Host, file)
+	}
+	return exitOK
+}
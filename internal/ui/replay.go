@@ -0,0 +1,217 @@
+package ui
+
+import (
+	"cdn-edge-server/internal/config"
+	"cdn-edge-server/internal/logging"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// replayDiffJSON is a replayed request whose response differs from the recording, or that failed.
+type replayDiffJSON struct {
+	Kind           string `json:"kind"` // "status", "body" or "error"
+	RequestID      string `json:"request_id,omitempty"`
+	Method         string `json:"method"`
+	Target         string `json:"target"`
+	RecordedStatus int    `json:"recorded_status"`
+	Status         int    `json:"status,omitempty"`
+	RecordedSHA256 string `json:"recorded_sha256,omitempty"`
+	SHA256         string `json:"sha256,omitempty"`
+	Error          string `json:"error,omitempty"`
+}
+
+// replayReportJSON is the -json output of the replay command.
+type replayReportJSON struct {
+	Replayed    int              `json:"replayed"`
+	Skipped     int              `json:"skipped"` // writes (without -writes, or without a captured body)
+	Matched     int              `json:"matched"`
+	StatusDiffs int              `json:"status_diffs"`
+	BodyDiffs   int              `json:"body_diffs"`
+	Errors      int              `json:"errors"`
+	DurationS   float64          `json:"duration_s"`
+	Differences []replayDiffJSON `json:"differences"`
+}
+
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cli replay [flags] <capture file>...\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	edge := fs.String("edge", net.JoinHostPort(config.EdgeHost, config.EdgePort), "edge server `address`")
+	host := fs.String("host", "", "virtual `host` to send in the Host header (the recorded one if empty)")
+	speed := fs.Float64("speed", 1, "pace relative to the recording (2 = twice as fast, 0 = as fast as possible)")
+	concurrency := fs.Int("c", 50, "maximum number of requests in flight")
+	writes := fs.Bool("writes", false, "also replay POST/PUT/DELETE requests (they change the origin's files)")
+	timeout := fs.Duration("timeout", 10*time.Second, "connection and response timeout of each request")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+
+	files, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if len(files) == 0 || *speed < 0 || *concurrency < 1 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	var records []logging.Record
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cli:", err)
+			return exitError
+		}
+		recs, err := logging.ReadCapture(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cli: %s: %v\n", path, err)
+			return exitError
+		}
+		records = append(records, recs...)
+	}
+	// Records are written as requests complete, replay them in the order they arrived
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	report := replayReportJSON{Differences: []replayDiffJSON{}}
+	var replay []logging.Record
+	for _, rec := range records {
+		isWrite := rec.Method != "GET" && rec.Method != "HEAD"
+		if isWrite && (!*writes || rec.BodySize > 0 && rec.Body == nil) {
+			report.Skipped++
+			continue
+		}
+		replay = append(replay, rec)
+	}
+	if len(replay) == 0 {
+		fmt.Fprintln(os.Stderr, "cli: no requests to replay")
+		return exitError
+	}
+
+	if !*asJSON {
+		pace := fmt.Sprintf("at %gx speed", *speed)
+		if *speed == 0 {
+			pace = "as fast as possible"
+		}
+		fmt.Fprintf(os.Stderr, "Replaying %d requests against %s %s\n", len(replay), *edge, pace)
+	}
+
+	diffs := make([]*replayDiffJSON, len(replay))
+	sem := make(chan struct{}, *concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, rec := range replay {
+		if *speed > 0 {
+			offset := time.Duration(float64(rec.Time.Sub(replay[0].Time)) / *speed)
+			time.Sleep(time.Until(start.Add(offset)))
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() { <-sem; wg.Done() }()
+			diffs[i] = replayRecord(*edge, *host, *timeout, rec)
+		}()
+	}
+	wg.Wait()
+
+	report.Replayed = len(replay)
+	report.DurationS = roundTo(time.Since(start).Seconds(), 3)
+	for _, d := range diffs {
+		if d == nil {
+			report.Matched++
+			continue
+		}
+		switch d.Kind {
+		case "status":
+			report.StatusDiffs++
+		case "body":
+			report.BodyDiffs++
+		case "error":
+			report.Errors++
+		}
+		report.Differences = append(report.Differences, *d)
+	}
+
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return exitError
+		}
+	} else {
+		printReplayReport(report)
+	}
+	if report.Matched < report.Replayed {
+		return exitError
+	}
+	return exitOK
+}
+
+// replayRecord sends the recorded request and compares the response with the recorded one,
+// returning nil if the status and body match.
+func replayRecord(edge, host string, timeout time.Duration, rec logging.Record) *replayDiffJSON {
+	if host == "" {
+		host = rec.Headers["Host"]
+	}
+	var headers []string
+	for name, value := range rec.Headers {
+		if name != "Host" && name != "Content-Length" {
+			headers = append(headers, name+": "+value)
+		}
+	}
+	slices.Sort(headers)
+
+	diff := &replayDiffJSON{
+		RequestID:      rec.RequestID,
+		Method:         rec.Method,
+		Target:         rec.Target,
+		RecordedStatus: rec.Status,
+	}
+	req := buildRequest(rec.Method, strings.TrimPrefix(rec.Target, "/"), host, headers, rec.Body)
+	resp, err := doRequest(edge, timeout, req, rec.Method == "HEAD")
+	if err != nil {
+		diff.Kind, diff.Error = "error", err.Error()
+		return diff
+	}
+
+	diff.Status = resp.Status
+	if resp.Status != rec.Status {
+		diff.Kind = "status"
+		return diff
+	}
+	if sum := logging.HashBody(resp.Body); sum != rec.ResponseSHA256 {
+		diff.Kind, diff.RecordedSHA256, diff.SHA256 = "body", rec.ResponseSHA256, sum
+		return diff
+	}
+	return nil
+}
+
+func printReplayReport(r replayReportJSON) {
+	fmt.Println("\n Replay")
+	fmt.Println("═══════════════════════════════════════")
+	fmt.Printf("Requests:   %d replayed in %.3fs, %d skipped\n", r.Replayed, r.DurationS, r.Skipped)
+	fmt.Printf("Matched:    %d\n", r.Matched)
+	fmt.Printf("Differ:     %d (status %d, body %d)\n", r.StatusDiffs+r.BodyDiffs, r.StatusDiffs, r.BodyDiffs)
+	fmt.Printf("Errors:     %d\n", r.Errors)
+	fmt.Println("═══════════════════════════════════════")
+
+	for _, d := range r.Differences {
+		request := d.Method + " " + d.Target
+		if d.RequestID != "" {
+			request += " (id " + d.RequestID + ")"
+		}
+		switch d.Kind {
+		case "status":
+			fmt.Printf("STATUS  %s: %d → %d\n", request, d.RecordedStatus, d.Status)
+		case "body":
+			fmt.Printf("BODY    %s: sha256 %.12s… → %.12s…\n", request, d.RecordedSHA256, d.SHA256)
+		case "error":
+			fmt.Printf("ERROR   %s: %s\n", request, d.Error)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package ui
+
+import (
+	"cdn-edge-server/internal/cache"
+	"cdn-edge-server/internal/cachesim"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func runSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cli simulate [flags] <trace file>...\n\n"+
+			"Trace files are edge access logs (any format), capture files, or JSONL lines of\n"+
+			"{\"key\", \"size\", \"time\", \"host\"} (\"-\" for stdin).\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	policyList := fs.String("policies", "fifo,lru,clock", "comma-separated eviction `policies` to simulate")
+	capacityList := fs.String("capacities", "1,2,5,10,20,50,100,200,500,1000", "comma-separated cache `capacities` (files per host) to simulate")
+	output := fs.String("o", "", "write the CSV to `path` instead of stdout")
+
+	files, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if len(files) == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	var policies []cache.Policy
+	for _, name := range strings.Split(*policyList, ",") {
+		policy, err := cache.ParsePolicy(strings.TrimSpace(name))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cli:", err)
+			return exitUsage
+		}
+		policies = append(policies, policy)
+	}
+	var capacities []int
+	for _, s := range strings.Split(*capacityList, ",") {
+		capacity, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || capacity < 1 {
+			fmt.Fprintf(os.Stderr, "cli: invalid capacity %q\n", s)
+			return exitUsage
+		}
+		capacities = append(capacities, capacity)
+	}
+
+	var requests []cachesim.Request
+	skipped := 0
+	for _, path := range files {
+		var r io.Reader = os.Stdin
+		if path != "-" {
+			f, err := os.Open(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "cli:", err)
+				return exitError
+			}
+			defer f.Close()
+			r = f
+		}
+		reqs, n, err := cachesim.ReadTrace(r)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "cli: %s: %v\n", path, err)
+			return exitError
+		}
+		requests = append(requests, reqs...)
+		skipped += n
+	}
+	cachesim.SortByTime(requests)
+
+	// The simulated caches log every eviction
+	slog.SetDefault(slog.New(slog.DiscardHandler))
+
+	start := time.Now()
+	results := cachesim.RunAll(requests, policies, capacities)
+	fmt.Fprintf(os.Stderr, "Simulated %d requests (%d lines skipped) with %d policies × %d capacities in %s\n",
+		len(requests), skipped, len(policies), len(capacities), time.Since(start).Round(time.Millisecond))
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cli:", err)
+			return exitError
+		}
+		defer f.Close()
+		out = f
+	}
+	if err := cachesim.WriteCSV(out, results); err != nil {
+		fmt.Fprintln(os.Stderr, "cli:", err)
+		return exitError
+	}
+	return exitOK
+}
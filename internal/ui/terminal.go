@@ -2,7 +2,9 @@ package ui
 
 import (
 	"bufio"
+	"cdn-edge-server/internal/auth"
 	"cdn-edge-server/internal/config"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
@@ -40,7 +42,9 @@ func (c *CLI) mainMenu() {
 		fmt.Println("│ 1. Check Server Status                  │")
 		fmt.Println("│ 2. Send requests to edge server         │")
 		fmt.Println("│ 3. View Configuration                   │")
-		fmt.Println("│ 4. Exit                                 │")
+		fmt.Println("│ 4. Generate signed URL                  │")
+		fmt.Println("│ 5. Cache                                │")
+		fmt.Println("│ 6. Exit                                 │")
 		fmt.Println("└─────────────────────────────────────────┘")
 		fmt.Print("\nSelect option: ")
 
@@ -54,6 +58,10 @@ func (c *CLI) mainMenu() {
 		case "3":
 			c.viewConfiguration()
 		case "4":
+			c.generateSignedURL()
+		case "5":
+			c.cacheMenu()
+		case "6":
 			fmt.Println("\nCLI Exited")
 			os.Exit(0)
 		default:
@@ -192,26 +200,13 @@ func (c *CLI) sendRequest(method, filename, body string) {
 	}
 	defer conn.Close()
 
-	// Construct HTTP request (headers)
-	req := fmt.Sprintf("%s /%s HTTP/1.0\r\nHost: localhost\r\nContent-Length: %d\r\n\r\n",
-		method, filename, len(body))
-
-	// Send headers
-	_, err = conn.Write([]byte(req))
+	// Send request
+	_, err = conn.Write(buildRequest(method, filename, config.CLIHost, nil, []byte(body)))
 	if err != nil {
 		fmt.Println("Error sending request:", err)
 		return
 	}
 
-	// Send body if present
-	if len(body) > 0 {
-		_, err = conn.Write([]byte(body))
-		if err != nil {
-			fmt.Println("Error sending body:", err)
-			return
-		}
-	}
-
 	// Read response (read until connection closes)
 	var response strings.Builder
 	buf := make([]byte, 4096)
@@ -237,6 +232,65 @@ func (c *CLI) sendRequest(method, filename, body string) {
 	fmt.Println("═══════════════════════════════════════")
 }
 
+// authHeaders returns the credential headers (CRLF-terminated) to send with the given request:
+// writes (POST/PUT/DELETE) carry the first credentials configured for the CLI, others none.
+func authHeaders(method, path, body string) string {
+	if method != "POST" && method != "PUT" && method != "DELETE" {
+		return ""
+	}
+
+	switch {
+	case config.CLIAuthToken != "":
+		return "Authorization: Bearer " + config.CLIAuthToken + "\r\n"
+	case config.CLIAuthUser != "":
+		creds := base64.StdEncoding.EncodeToString([]byte(config.CLIAuthUser + ":" + config.CLIAuthPassword))
+		return "Authorization: Basic " + creds + "\r\n"
+	case config.CLIHMACKey != "":
+		keyID, secret, _ := strings.Cut(config.CLIHMACKey, ":")
+		date, authorization := auth.SignHMAC(keyID, secret, method, path, []byte(body), time.Now())
+		return "X-Auth-Date: " + date + "\r\nAuthorization: " + authorization + "\r\n"
+	default:
+		return ""
+	}
+}
+
+func (c *CLI) generateSignedURL() {
+	keys, err := auth.ParseURLKeys(config.SignedURLKeys)
+	if err != nil {
+		fmt.Println("\nCannot sign URLs:", err)
+		fmt.Println("   Set SIGNED_URL_KEYS in .env")
+		return
+	}
+
+	fmt.Print("\nEnter filename: ")
+	filename := c.readInput()
+
+	if filename == "" {
+		fmt.Println("Filename cannot be empty")
+		return
+	}
+
+	fmt.Print("Valid for (e.g. 30m, 24h) [1h]: ")
+	ttl := time.Hour
+	if input := c.readInput(); input != "" {
+		if ttl, err = time.ParseDuration(input); err != nil || ttl <= 0 {
+			fmt.Println("Invalid duration")
+			return
+		}
+	}
+
+	path := "/" + filename
+	expires := time.Now().Add(ttl)
+	scheme := "http"
+	if config.TLSCertDir != "" { // the edge terminates TLS on EDGE_PORT
+		scheme = "https"
+	}
+	fmt.Println("\n Signed URL (expires " + expires.Format(time.RFC1123) + "):")
+	fmt.Println("═══════════════════════════════════════")
+	fmt.Printf("%s://%s:%s%s?%s\n", scheme, config.EdgeHost, config.EdgePort, path, keys.Sign(path, expires))
+	fmt.Println("═══════════════════════════════════════")
+}
+
 func (c *CLI) viewConfiguration() {
 	fmt.Println("\n  Configuration")
 	fmt.Println("═══════════════════════════════════════")
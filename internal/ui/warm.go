@@ -0,0 +1,185 @@
+package ui
+
+import (
+	"cdn-edge-server/internal/config"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// warmJobJSON is a cache warm-up of one virtual host, as reported by the admin API.
+type warmJobJSON struct {
+	Host          string     `json:"host"`
+	Source        string     `json:"source"`
+	Started       time.Time  `json:"started"`
+	Finished      *time.Time `json:"finished"`
+	Total         int        `json:"total"`
+	Done          int        `json:"done"`
+	Cached        int        `json:"cached"`
+	AlreadyCached int        `json:"already_cached"`
+	Failed        int        `json:"failed"`
+	NoRoom        int        `json:"no_room"`
+}
+
+func runWarm(args []string) int {
+	fs := flag.NewFlagSet("warm", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: cli warm [flags] <manifest|->...\n       cli warm -status\n\n"+
+			"A manifest lists one \"[<host> ]<file>\" per line, most important first.\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	host := fs.String("host", config.CLIHost, "virtual `host` of the files listed without one (the edge's default host if empty)")
+	status := fs.Bool("status", false, "only print the progress of the last warm-up of each host")
+	noWait := fs.Bool("no-wait", false, "return once the warm-up has started")
+	interval := fs.Duration("interval", 500*time.Millisecond, "how often to poll the progress")
+	asJSON := fs.Bool("json", false, "print the warm-ups as JSON")
+
+	files, code, ok := parseFlags(fs, args)
+	if !ok {
+		return code
+	}
+	if *status == (len(files) > 0) || *interval <= 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if config.AdminPort == "" {
+		fmt.Fprintln(os.Stderr, "cli: the edge admin port is not configured (set ADMIN_PORT)")
+		return exitError
+	}
+
+	var resp struct {
+		Jobs []warmJobJSON `json:"jobs"`
+	}
+	if *status {
+		if err := adminGet("/cache/warm", &resp); err != nil {
+			return adminError(err)
+		}
+		return printWarmJobs(resp.Jobs, *asJSON)
+	}
+
+	var manifest []byte
+	for _, path := range files {
+		var data []byte
+		var err error
+		if path == "-" {
+			data, err = io.ReadAll(os.Stdin)
+		} else {
+			data, err = os.ReadFile(path)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "cli:", err)
+			return exitError
+		}
+		manifest = append(manifest, data...)
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			manifest = append(manifest, '\n')
+		}
+	}
+
+	query := url.Values{}
+	if *host != "" {
+		query.Set("host", *host)
+	}
+	if err := adminDo("POST", "/cache/warm?"+query.Encode(), manifest, &resp); err != nil {
+		return adminError(err)
+	}
+	if *noWait {
+		return printWarmJobs(resp.Jobs, *asJSON)
+	}
+
+	// Poll until the warm-ups started above are done
+	started := resp.Jobs
+	for {
+		if !*asJSON {
+			fmt.Fprintf(os.Stderr, "\r%s", warmProgress(started))
+		}
+		if warmDone(started) {
+			break
+		}
+		time.Sleep(*interval)
+
+		var current struct {
+			Jobs []warmJobJSON `json:"jobs"`
+		}
+		if err := adminGet("/cache/warm", &current); err != nil {
+			return adminError(err)
+		}
+		for i, j := range started {
+			for _, c := range current.Jobs {
+				if c.Host == j.Host && c.Started.Equal(j.Started) {
+					started[i] = c
+				}
+			}
+		}
+	}
+	if !*asJSON {
+		fmt.Fprintln(os.Stderr)
+	}
+	return printWarmJobs(started, *asJSON)
+}
+
+// adminError prints an admin API error and returns the matching exit status.
+func adminError(err error) int {
+	fmt.Fprintln(os.Stderr, "cli:", err)
+	var statusErr *adminStatusError
+	if errors.As(err, &statusErr) {
+		return statusExitCode(statusErr.status)
+	}
+	return exitError
+}
+
+func warmDone(jobs []warmJobJSON) bool {
+	for _, j := range jobs {
+		if j.Finished == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// warmProgress is a one-line summary of the warm-ups' progress.
+func warmProgress(jobs []warmJobJSON) string {
+	var total, done int
+	for _, j := range jobs {
+		total += j.Total
+		done += j.Done
+	}
+	return fmt.Sprintf("Warming %d host(s): %d/%d files", len(jobs), done, total)
+}
+
+// printWarmJobs prints the warm-ups, and returns exitError if any file failed to be fetched.
+func printWarmJobs(jobs []warmJobJSON, asJSON bool) int {
+	if asJSON {
+		if err := printJSON(map[string]any{"jobs": jobs}); err != nil {
+			return exitError
+		}
+	} else if len(jobs) == 0 {
+		fmt.Println("No cache warm-up since the edge started")
+	}
+
+	code := exitOK
+	for _, j := range jobs {
+		if j.Failed > 0 {
+			code = exitError
+		}
+		if asJSON {
+			continue
+		}
+		state := "running"
+		if j.Finished != nil {
+			state = "done in " + j.Finished.Sub(j.Started).Round(time.Millisecond).String()
+		}
+		fmt.Printf("%s (%s, %s): %d/%d files, %s\n", j.Host, j.Source, state, j.Done, j.Total, strings.Join([]string{
+			fmt.Sprintf("%d cached", j.Cached),
+			fmt.Sprintf("%d already cached", j.AlreadyCached),
+			fmt.Sprintf("%d failed", j.Failed),
+			fmt.Sprintf("%d skipped for lack of room", j.NoRoom),
+		}, ", "))
+	}
+	return code
+}